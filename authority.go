@@ -1,6 +1,9 @@
 package main
 
 import (
+	"database/sql"
+
+	"github.com/di0nys1us/authgo/security"
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 )
@@ -11,8 +14,23 @@ type roleAuthoritiesFinder interface {
 	findRoleAuthorities(roleID string) ([]*authority, error)
 }
 
+type authorityByIDFinder interface {
+	findAuthorityByID(id string) (*authority, error)
+}
+
+type authoritySaver interface {
+	saveAuthority(authority *authority) error
+}
+
+type authorityUpdater interface {
+	updateAuthority(authority *authority) error
+}
+
 type authorityRepository interface {
 	roleAuthoritiesFinder
+	authorityByIDFinder
+	authoritySaver
+	authorityUpdater
 }
 
 // STRUCTS
@@ -25,10 +43,38 @@ type authority struct {
 }
 
 func (a *authority) save(tx *tx) error {
+	id, err := tx.save(a, sqlSaveAuthority)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	a.ID, err = uuid.FromString(id)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
 	return nil
 }
 
 func (a *authority) update(tx *tx) error {
+	result, err := tx.Exec(sqlUpdateAuthority, a.ID, a.Version, a.Name)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if rowsAffected != 1 {
+		return errors.New("authgo: no update performed")
+	}
+
 	return nil
 }
 
@@ -36,6 +82,40 @@ func (a *authority) delete(tx *tx) error {
 	return nil
 }
 
+func (db *db) findAuthorityByID(id string) (*authority, error) {
+	a := &authority{}
+
+	err := db.Get(a, sqlFindAuthorityByID, id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return a, nil
+}
+
+func (db *db) saveAuthority(authority *authority) error {
+	return db.commit(authority.save)
+}
+
+// updateAuthority renames the authority, as long as nobody changed it since the version it
+// has.
+func (db *db) updateAuthority(authority *authority) error {
+	err := db.commit(authority.update)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	authority.Version++
+
+	return nil
+}
+
 func (db *db) findRoleAuthorities(roleID string) ([]*authority, error) {
 	authorities := []*authority{}
 
@@ -48,7 +128,66 @@ func (db *db) findRoleAuthorities(roleID string) ([]*authority, error) {
 	return authorities, nil
 }
 
+// FindUserAuthorities returns the names of the roles of the user, and of the authorities
+// the roles grant.
+func (db *db) FindUserAuthorities(userID string) (*security.Authorities, error) {
+	authorities := &security.Authorities{Roles: []string{}, Authorities: []string{}}
+
+	err := db.Select(&authorities.Roles, sqlFindUserRoleNames, userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = db.Select(&authorities.Authorities, sqlFindUserAuthorityNames, userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return authorities, nil
+}
+
 const (
+	sqlFindAuthorityByID = `
+		select
+			"authority"."id",
+			"authority"."version",
+			"authority"."name"
+		from "authgo"."authority"
+		where "authority"."id" = $1;
+	`
+	sqlSaveAuthority = `
+		insert into "authgo"."authority" (
+			"name"
+		) values (
+			:name
+		) returning "authority"."id";
+	`
+	sqlUpdateAuthority = `
+		update "authgo"."authority" set
+			"version" = "authority"."version" + 1,
+			"name" = $3
+		where "authority"."id" = $1
+			and "authority"."version" = $2;
+	`
+	sqlFindUserRoleNames = `
+		select
+			"role"."name"
+		from "authgo"."role"
+			inner join "authgo"."user_role" on "user_role"."role_id" = "role"."id"
+		where "user_role"."user_id" = $1
+		order by "role"."name";
+	`
+	sqlFindUserAuthorityNames = `
+		select distinct
+			"authority"."name"
+		from "authgo"."authority"
+			inner join "authgo"."role_authority" on "role_authority"."authority_id" = "authority"."id"
+			inner join "authgo"."user_role" on "user_role"."role_id" = "role_authority"."role_id"
+		where "user_role"."user_id" = $1
+		order by "authority"."name";
+	`
 	sqlFindRoleAuthorities = `
 		select
 			"authority"."id",
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/graph-gophers/graphql-go"
 )
 
@@ -21,14 +23,18 @@ func (r *authorityResolver) Name() string {
 	return r.authority.Name
 }
 
-func (r *authorityResolver) Events() ([]*eventResolver, error) {
+func (r *authorityResolver) Events(ctx context.Context) (*[]*eventResolver, error) {
+	if err := authorizeField(ctx, "Authority.events", ""); err != nil {
+		return nil, err
+	}
+
 	var resolvers []*eventResolver
 
 	for _, event := range r.authority.Events {
 		resolvers = append(resolvers, &eventResolver{r.repository, event})
 	}
 
-	return resolvers, nil
+	return &resolvers, nil
 }
 
 func (r *authorityResolver) Roles() ([]*roleResolver, error) {
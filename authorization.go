@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// The authorities that the REST routes require. Those of the GraphQL API are declared in the
+// schema, with the @hasAuthority directive.
+const (
+	authorityReadUsers        = "READ_USERS"
+	authorityRevokeTokens     = "REVOKE_TOKENS"
+	authorityImpersonateUsers = "IMPERSONATE_USERS"
+	// impersonationMaxAge is how recently support staff must have authenticated to
+	// impersonate users.
+	impersonationMaxAge = 10 * time.Minute
+)
+
+var (
+	// schemaPermissions are the permissions declared in the schema, by "Type.field".
+	schemaPermissions = permissions{}
+)
+
+// permission is what the directives of a field require. A field with both @self and
+// @hasAuthority is allowed to the owner, and to those with the authority. A field with
+// @noImpersonation is never allowed to someone acting on behalf of the user, and one with
+// @stepUp only to users who authenticated within maxAge, at the assurance level acr.
+type permission struct {
+	authority       string
+	self            bool
+	noImpersonation bool
+	maxAge          time.Duration
+	acr             string
+}
+
+type permissions map[string]*permission
+
+// forbiddenError is returned for the fields the user of the request is not permitted to
+// resolve. The field resolves to null, and the error carries the FORBIDDEN code.
+type forbiddenError struct {
+	field string
+}
+
+func (e *forbiddenError) Error() string {
+	return fmt.Sprintf("authgo: forbidden to resolve %s", e.field)
+}
+
+func (e *forbiddenError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "FORBIDDEN"}
+}
+
+// stepUpRequiredError is returned for the fields that need a more recent or stronger
+// authentication than the user of the request has. Clients should send the user through the
+// reauthentication, and try again.
+type stepUpRequiredError struct {
+	field  string
+	maxAge time.Duration
+	acr    string
+}
+
+func (e *stepUpRequiredError) Error() string {
+	return fmt.Sprintf("authgo: step-up authentication required to resolve %s", e.field)
+}
+
+func (e *stepUpRequiredError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   "STEP_UP_REQUIRED",
+		"maxAge": int64(e.maxAge / time.Second),
+	}
+
+	if e.acr != "" {
+		extensions["acr"] = e.acr
+	}
+
+	return extensions
+}
+
+// parsePermissions reads the @hasAuthority, @self, @noImpersonation and @stepUp directives of
+// the fields of the object types in the schema. The schema is tokenized, so the definitions
+// may be formatted in any way, and a directive without its arguments is an error.
+func parsePermissions(schema string) (permissions, error) {
+	p := &sdlParser{tokens: tokenizeSDL(schema)}
+	result := permissions{}
+
+	for !p.done() {
+		switch p.next() {
+		case "type":
+			typeName := p.next()
+
+			for !p.done() && p.peek() != "{" {
+				p.next()
+			}
+
+			err := p.parseFields(typeName, result)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		case "{":
+			p.skipUntil("}")
+		case "(":
+			p.skipUntil(")")
+		}
+	}
+
+	return result, nil
+}
+
+// sdlParser walks the tokens of a schema, as far as the permissions need it.
+type sdlParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *sdlParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *sdlParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *sdlParser) next() string {
+	token := p.peek()
+	p.pos++
+
+	return token
+}
+
+func (p *sdlParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return errors.Errorf("authgo: expected %q in schema, found %q", token, next)
+	}
+
+	return nil
+}
+
+// skipUntil skips the tokens up to the closing token, and any nested in between.
+func (p *sdlParser) skipUntil(closing string) {
+	opening := map[string]string{"}": "{", ")": "(", "]": "["}[closing]
+	depth := 1
+
+	for !p.done() && depth > 0 {
+		switch p.next() {
+		case opening:
+			depth++
+		case closing:
+			depth--
+		}
+	}
+}
+
+// parseFields reads the fields of the type between its braces, as
+// name(arguments): Type @directive(arguments).
+func (p *sdlParser) parseFields(typeName string, result permissions) error {
+	err := p.expect("{")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for p.peek() != "}" {
+		if p.done() {
+			return errors.Errorf("authgo: type %s is not closed in schema", typeName)
+		}
+
+		// Descriptions come before the name.
+		for strings.HasPrefix(p.peek(), `"`) {
+			p.next()
+		}
+
+		field := typeName + "." + p.next()
+
+		if p.peek() == "(" {
+			p.next()
+			p.skipUntil(")")
+		}
+
+		err = p.expect(":")
+
+		if err != nil {
+			return errors.Wrap(err, field)
+		}
+
+		err = p.skipType()
+
+		if err != nil {
+			return errors.Wrap(err, field)
+		}
+
+		permission, err := p.parseDirectives(field)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if permission != nil {
+			result[field] = permission
+		}
+	}
+
+	p.next()
+
+	return nil
+}
+
+func (p *sdlParser) skipType() error {
+	if p.next() == "[" {
+		err := p.skipType()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = p.expect("]")
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if p.peek() == "!" {
+		p.next()
+	}
+
+	return nil
+}
+
+// parseDirectives returns the permission that the directives of the field declare, or nil
+// when they declare none. Other directives are skipped.
+func (p *sdlParser) parseDirectives(field string) (*permission, error) {
+	permission := &permission{}
+
+	for p.peek() == "@" {
+		p.next()
+		name := p.next()
+		arguments := map[string]string{}
+
+		if p.peek() == "(" {
+			p.next()
+
+			for p.peek() != ")" && !p.done() {
+				argument := p.next()
+
+				err := p.expect(":")
+
+				if err != nil {
+					return nil, errors.Wrap(err, field)
+				}
+
+				arguments[argument] = p.parseValue()
+			}
+
+			p.next()
+		}
+
+		switch name {
+		case "self":
+			permission.self = true
+		case "noImpersonation":
+			permission.noImpersonation = true
+		case "hasAuthority":
+			permission.authority = arguments["name"]
+
+			if permission.authority == "" {
+				return nil, errors.Errorf("authgo: @hasAuthority of %s needs a name", field)
+			}
+		case "stepUp":
+			seconds, err := strconv.Atoi(arguments["maxAge"])
+
+			if err != nil || seconds <= 0 {
+				return nil, errors.Errorf("authgo: @stepUp of %s needs a positive maxAge", field)
+			}
+
+			permission.maxAge = time.Duration(seconds) * time.Second
+			permission.acr = arguments["acr"]
+		}
+	}
+
+	if !permission.self && permission.authority == "" && !permission.noImpersonation && permission.maxAge == 0 {
+		return nil, nil
+	}
+
+	return permission, nil
+}
+
+// parseValue returns a string, number or enum value as it is meant, and skips lists and
+// objects.
+func (p *sdlParser) parseValue() string {
+	value := p.next()
+
+	switch {
+	case value == "[":
+		p.skipUntil("]")
+		return ""
+	case value == "{":
+		p.skipUntil("}")
+		return ""
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+
+		if err != nil {
+			return ""
+		}
+
+		return unquoted
+	}
+
+	return value
+}
+
+// tokenizeSDL splits a schema into names, numbers, strings and punctuators, leaving out
+// whitespace, commas and comments.
+func tokenizeSDL(schema string) []string {
+	tokens := []string{}
+
+	for i := 0; i < len(schema); {
+		c := schema[i]
+
+		switch {
+		case c == '#':
+			for i < len(schema) && schema[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case strings.HasPrefix(schema[i:], `"""`):
+			end := strings.Index(schema[i+3:], `"""`)
+
+			if end < 0 {
+				end = len(schema) - i - 6
+			}
+
+			tokens = append(tokens, strconv.Quote(schema[i+3:i+3+end]))
+			i += end + 6
+		case c == '"':
+			j := i + 1
+
+			for j < len(schema) && schema[j] != '"' && schema[j] != '\n' {
+				if schema[j] == '\\' {
+					j++
+				}
+
+				j++
+			}
+
+			if j >= len(schema) {
+				j = len(schema) - 1
+			}
+
+			tokens = append(tokens, schema[i:j+1])
+			i = j + 1
+		case isNameByte(c) || c == '-':
+			j := i + 1
+
+			for j < len(schema) && (isNameByte(schema[j]) || schema[j] == '.') {
+				j++
+			}
+
+			tokens = append(tokens, schema[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+
+	return tokens
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// check permits the user of the request to resolve the field of something owned by the
+// user with ownerID, which is empty when there is no owner.
+func (p permissions) check(ctx context.Context, field, ownerID string) error {
+	permission, ok := p[field]
+
+	if !ok {
+		return nil
+	}
+
+	if permission.noImpersonation && security.ImpersonatingFromContext(ctx) {
+		return &forbiddenError{field}
+	}
+
+	if !permission.allows(ctx, ownerID) {
+		return &forbiddenError{field}
+	}
+
+	if permission.maxAge > 0 && !security.AuthenticatedRecently(ctx, permission.maxAge, permission.acr) {
+		return &stepUpRequiredError{field, permission.maxAge, permission.acr}
+	}
+
+	return nil
+}
+
+func (p *permission) allows(ctx context.Context, ownerID string) bool {
+	if !p.self && p.authority == "" {
+		return true
+	}
+
+	if p.self && ownerID != "" && ownerID == security.UserIDFromContext(ctx) {
+		return true
+	}
+
+	return p.authority != "" && security.HasAuthority(ctx, p.authority)
+}
+
+// authorizeField checks the permissions the schema declares for the field.
+func authorizeField(ctx context.Context, field, ownerID string) error {
+	return schemaPermissions.check(ctx, field, ownerID)
+}
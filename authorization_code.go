@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type authorizationCode struct {
+	CodeHash      string         `db:"code_hash"`
+	ClientID      string         `db:"client_id"`
+	UserID        string         `db:"user_id"`
+	RedirectURI   string         `db:"redirect_uri"`
+	Scope         string         `db:"scope"`
+	CodeChallenge string         `db:"code_challenge"`
+	Nonce         string         `db:"nonce"`
+	AuthTime      time.Time      `db:"auth_time"`
+	AMR           pq.StringArray `db:"amr"`
+	CreatedAt     time.Time      `db:"created_at"`
+	ExpiresAt     time.Time      `db:"expires_at"`
+}
+
+func (c *authorizationCode) authorizationCode() *security.AuthorizationCode {
+	return &security.AuthorizationCode{
+		CodeHash:      c.CodeHash,
+		ClientID:      c.ClientID,
+		UserID:        c.UserID,
+		RedirectURI:   c.RedirectURI,
+		Scope:         c.Scope,
+		CodeChallenge: c.CodeChallenge,
+		Nonce:         c.Nonce,
+		AuthTime:      c.AuthTime,
+		AMR:           c.AMR,
+		CreatedAt:     c.CreatedAt,
+		ExpiresAt:     c.ExpiresAt,
+	}
+}
+
+func newAuthorizationCode(code *security.AuthorizationCode) *authorizationCode {
+	c := &authorizationCode{
+		CodeHash:      code.CodeHash,
+		ClientID:      code.ClientID,
+		UserID:        code.UserID,
+		RedirectURI:   code.RedirectURI,
+		Scope:         code.Scope,
+		CodeChallenge: code.CodeChallenge,
+		Nonce:         code.Nonce,
+		AuthTime:      code.AuthTime,
+		AMR:           pq.StringArray(code.AMR),
+		CreatedAt:     code.CreatedAt,
+		ExpiresAt:     code.ExpiresAt,
+	}
+
+	if c.AMR == nil {
+		c.AMR = pq.StringArray{}
+	}
+
+	return c
+}
+
+func (db *db) SaveAuthorizationCode(code *security.AuthorizationCode) error {
+	_, err := db.NamedExec(sqlSaveAuthorizationCode, newAuthorizationCode(code))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) ConsumeAuthorizationCode(codeHash string) (*security.AuthorizationCode, error) {
+	c := authorizationCode{}
+
+	err := db.Get(&c, sqlConsumeAuthorizationCode, codeHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.authorizationCode(), nil
+}
+
+const (
+	sqlSaveAuthorizationCode = `
+		insert into "authgo"."authorization_code" (
+			"code_hash",
+			"client_id",
+			"user_id",
+			"redirect_uri",
+			"scope",
+			"code_challenge",
+			"nonce",
+			"auth_time",
+			"amr",
+			"created_at",
+			"expires_at"
+		) values (
+			:code_hash,
+			:client_id,
+			:user_id,
+			:redirect_uri,
+			:scope,
+			:code_challenge,
+			:nonce,
+			:auth_time,
+			:amr,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlConsumeAuthorizationCode = `
+		delete from "authgo"."authorization_code"
+		where "authorization_code"."code_hash" = $1
+		returning
+			"authorization_code"."code_hash",
+			"authorization_code"."client_id",
+			"authorization_code"."user_id",
+			"authorization_code"."redirect_uri",
+			"authorization_code"."scope",
+			"authorization_code"."code_challenge",
+			"authorization_code"."nonce",
+			"authorization_code"."auth_time",
+			"authorization_code"."amr",
+			"authorization_code"."created_at",
+			"authorization_code"."expires_at";
+	`
+)
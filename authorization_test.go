@@ -0,0 +1,148 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePermissions(t *testing.T) {
+	schema := `
+		# The user of the request must have the authority.
+		directive @hasAuthority(name: String!) on FIELD_DEFINITION
+
+		type Query {
+			users: [User!] @hasAuthority(name: "READ_USERS")
+			"""
+			A user, by id or email.
+			"""
+			user(
+				id: ID,
+				email: String
+			): User
+				@self
+				@hasAuthority(
+					name: "READ_USERS"
+				)
+			version: Int!
+		}
+
+		input UserInput {
+			email: String!
+		}
+
+		type Mutation { resetMfa(userId: ID!): Boolean @hasAuthority(name: "WRITE_USERS") @stepUp(maxAge: 600, acr: "aal2") }
+	`
+
+	result, err := parsePermissions(schema)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 3 {
+		t.Fatalf("expected 3 permissions, got %d", len(result))
+	}
+
+	if p := result["Query.user"]; p == nil || !p.self || p.authority != "READ_USERS" {
+		t.Fatalf("unexpected permission of Query.user: %+v", p)
+	}
+
+	if p := result["Mutation.resetMfa"]; p == nil || p.maxAge != 10*time.Minute || p.acr != "aal2" {
+		t.Fatalf("unexpected permission of Mutation.resetMfa: %+v", p)
+	}
+
+	for _, schema := range []string{
+		`type Query { users: [User!] @hasAuthority }`,
+		`type Query { users: [User!] @stepUp(acr: "aal2") }`,
+		`type Query { users: [User!] @hasAuthority(name: "READ_USERS")`,
+	} {
+		if _, err := parsePermissions(schema); err == nil {
+			t.Fatalf("expected an error for %s", schema)
+		}
+	}
+}
+
+// TestAuthorizeFieldCalls makes sure that every field with permissions in the schema is
+// checked by its resolver, and that the resolvers only check fields of the schema.
+func TestAuthorizeFieldCalls(t *testing.T) {
+	declared, err := parsePermissions(readSchema())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := parser.ParseDir(token.NewFileSet(), ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked := map[string]bool{}
+
+	ast.Inspect(packages["main"], func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+
+		if ident, ok := call.Fun.(*ast.Ident); !ok || ident.Name != "authorizeField" {
+			return true
+		}
+
+		if literal, ok := call.Args[1].(*ast.BasicLit); ok {
+			field, _ := strconv.Unquote(literal.Value)
+			checked[field] = true
+		}
+
+		return true
+	})
+
+	for field := range declared {
+		if !checked[field] {
+			t.Errorf("%s has permissions in the schema, but its resolver does not check them", field)
+		}
+	}
+
+	for field := range checked {
+		if declared[field] == nil {
+			t.Errorf("%s is checked by its resolver, but has no permissions in the schema", field)
+		}
+	}
+}
+
+// TestSensitiveMutationsRequireStepUp makes sure that the mutations that change passwords,
+// roles and who may sign in require a recent authentication.
+func TestSensitiveMutationsRequireStepUp(t *testing.T) {
+	declared, err := parsePermissions(readSchema())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{
+		"Mutation.createUser",
+		"Mutation.updateUser",
+		"Mutation.createRole",
+		"Mutation.updateRole",
+		"Mutation.createAuthority",
+		"Mutation.updateAuthority",
+		"Mutation.setRoleMfaRequired",
+		"Mutation.setRoleRegistrationDefault",
+		"Mutation.approveUser",
+		"Mutation.unlockUser",
+		"Mutation.resetMfa",
+	} {
+		if p := declared[field]; p == nil || p.maxAge <= 0 {
+			t.Errorf("%s does not require step-up authentication", field)
+		}
+	}
+}
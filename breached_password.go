@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	breachedPasswordPrefixLength = 5
+)
+
+func (db *db) FindBreachedPasswordSuffixes(prefix string) ([]string, error) {
+	suffixes := []string{}
+
+	err := db.Select(&suffixes, sqlFindBreachedPasswordSuffixes, prefix)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return suffixes, nil
+}
+
+// ReplaceBreachedPasswords copies the hashes into the table, which is emptied first, in a
+// single transaction.
+func (db *db) ReplaceBreachedPasswords(hashes []string) error {
+	return db.commit(func(tx *tx) error {
+		_, err := tx.Exec(sqlDeleteBreachedPasswords)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		stmt, err := tx.Prepare(pq.CopyInSchema("authgo", "breached_password", "prefix", "suffix"))
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		defer stmt.Close()
+
+		for _, hash := range hashes {
+			_, err = stmt.Exec(hash[:breachedPasswordPrefixLength], hash[breachedPasswordPrefixLength:])
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		_, err = stmt.Exec()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+}
+
+const (
+	sqlFindBreachedPasswordSuffixes = `
+		select
+			"breached_password"."suffix"
+		from "authgo"."breached_password"
+		where "breached_password"."prefix" = $1;
+	`
+	sqlDeleteBreachedPasswords = `
+		delete from "authgo"."breached_password";
+	`
+)
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// INTERFACES
+
+type allClientsFinder interface {
+	findAllClients() ([]*client, error)
+}
+
+type clientSaver interface {
+	saveClient(c *client) error
+}
+
+type clientRepository interface {
+	allClientsFinder
+	clientSaver
+}
+
+// STRUCTS
+
+type client struct {
+	ID               string         `db:"id" json:"id,omitempty"`
+	Name             string         `db:"name" json:"name,omitempty"`
+	SecretHash       string         `db:"secret_hash" json:"-"`
+	PublicKey        string         `db:"public_key" json:"publicKey,omitempty"`
+	RedirectURIs     pq.StringArray `db:"redirect_uris" json:"redirectUris,omitempty"`
+	ServiceAccountID string         `db:"service_account_id" json:"serviceAccountId,omitempty"`
+}
+
+func (db *db) findAllClients() ([]*client, error) {
+	clients := []*client{}
+
+	err := db.Select(&clients, sqlFindAllClients)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return clients, nil
+}
+
+func (db *db) findClientByID(id string) (*client, error) {
+	c := &client{}
+
+	err := db.Get(c, sqlFindClientByID, id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c, nil
+}
+
+func (db *db) saveClient(c *client) error {
+	return db.commit(func(tx *tx) error {
+		id, err := tx.save(c, sqlSaveClient)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		c.ID = id
+
+		return nil
+	})
+}
+
+func (db *db) FindClientByID(id string) (*security.Client, error) {
+	if !isUUID(id) {
+		return nil, nil
+	}
+
+	c, err := db.findClientByID(id)
+
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	return &security.Client{
+		ID:               c.ID,
+		Name:             c.Name,
+		SecretHash:       c.SecretHash,
+		PublicKey:        c.PublicKey,
+		RedirectURIs:     c.RedirectURIs,
+		ServiceAccountID: c.ServiceAccountID,
+	}, nil
+}
+
+const (
+	sqlFindAllClients = `
+		select
+			"client"."id",
+			"client"."name",
+			"client"."secret_hash",
+			"client"."public_key",
+			"client"."redirect_uris",
+			coalesce("client"."service_account_id"::text, '') as "service_account_id"
+		from "authgo"."client"
+		order by "client"."name";
+	`
+	sqlFindClientByID = `
+		select
+			"client"."id",
+			"client"."name",
+			"client"."secret_hash",
+			"client"."public_key",
+			"client"."redirect_uris",
+			coalesce("client"."service_account_id"::text, '') as "service_account_id"
+		from "authgo"."client"
+		where "client"."id" = $1;
+	`
+	sqlSaveClient = `
+		insert into "authgo"."client" (
+			"name",
+			"secret_hash",
+			"public_key",
+			"redirect_uris",
+			"service_account_id"
+		) values (
+			:name,
+			:secret_hash,
+			:public_key,
+			:redirect_uris,
+			cast(nullif(:service_account_id, '') as uuid)
+		) returning "client"."id";
+	`
+)
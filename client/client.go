@@ -0,0 +1,147 @@
+// Package client lets services verify the access tokens that authgo issues, without sharing
+// its keys. The verification keys are fetched from the JWKS endpoint of the issuer and cached.
+//
+// Tokens are verified offline, so a revoked token is accepted until it expires, which is
+// minutes. Services that cannot accept that, or that accept personal access tokens, should
+// ask the introspection endpoint of the issuer instead.
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAudience is the audience of the access tokens of authgo.
+	DefaultAudience             = "eies.land"
+	jwksPath                    = "/.well-known/jwks.json"
+	jwtHeaderKeyID              = "kid"
+	principalTypeServiceAccount = "service_account"
+	defaultRefreshInterval      = 5 * time.Minute
+	minRefreshInterval          = 10 * time.Second
+	defaultTimeout              = 10 * time.Second
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired, not signed by the
+	// issuer, or meant for someone else.
+	ErrInvalidToken = errors.New("authgo: invalid token")
+	// ErrMissingToken is returned for requests without a token.
+	ErrMissingToken = errors.New("authgo: missing token")
+)
+
+// Verifier verifies access tokens against the keys of an issuer. It is safe for concurrent
+// use.
+type Verifier struct {
+	issuer   string
+	audience string
+	keys     *keyCache
+}
+
+type Option func(*Verifier)
+
+// WithIssuer sets the iss claim the tokens must have, which is the base URL by default. It
+// is the AUTHGO_ISSUER of the issuer.
+func WithIssuer(issuer string) Option {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim the tokens must have.
+func WithAudience(audience string) Option {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithHTTPClient sets the client the keys are fetched with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(v *Verifier) {
+		v.keys.httpClient = httpClient
+	}
+}
+
+// WithRefreshInterval sets how long the keys are cached. Keys the cache has not seen are
+// fetched right away regardless, at most every 10 seconds.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.keys.refreshInterval = d
+	}
+}
+
+// New returns a Verifier for the tokens of the authgo instance at baseURL, such as
+// "https://auth.eies.land".
+func New(baseURL string, opts ...Option) *Verifier {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	v := &Verifier{
+		issuer:   baseURL,
+		audience: DefaultAudience,
+		keys: &keyCache{
+			url:             baseURL + jwksPath,
+			httpClient:      &http.Client{Timeout: defaultTimeout},
+			refreshInterval: defaultRefreshInterval,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+type claims struct {
+	*jwt.StandardClaims
+	UserID        string   `json:"uid"`
+	ClientID      string   `json:"client_id,omitempty"`
+	PrincipalType string   `json:"principal_type,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	Authorities   []string `json:"authorities,omitempty"`
+	Actor         *actor   `json:"act,omitempty"`
+}
+
+type actor struct {
+	Subject string `json:"sub"`
+	UserID  string `json:"uid"`
+}
+
+// Verify checks the signature, lifetime, issuer and audience of the token, and returns whom
+// it was issued to.
+func (v *Verifier) Verify(signedToken string) (*Principal, error) {
+	c := &claims{StandardClaims: &jwt.StandardClaims{}}
+	token, err := jwt.ParseWithClaims(signedToken, c, v.keys.verificationKey)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	if !token.Valid {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+
+	// Tokens for other audiences, such as ID tokens, are no access tokens.
+	if c.ExpiresAt == 0 || !c.VerifyIssuer(v.issuer, true) || !c.VerifyAudience(v.audience, true) || c.UserID == "" {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+
+	p := &Principal{
+		ID:             c.UserID,
+		Email:          c.Subject,
+		Roles:          c.Roles,
+		Authorities:    c.Authorities,
+		ClientID:       c.ClientID,
+		ServiceAccount: c.PrincipalType == principalTypeServiceAccount,
+	}
+
+	if c.Actor != nil {
+		p.ActorID = c.Actor.UserID
+	}
+
+	return p, nil
+}
@@ -0,0 +1,206 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/client"
+	"github.com/di0nys1us/authgo/client/clienttest"
+)
+
+var _ = Describe("Client", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		issuer    *clienttest.Issuer
+		verifier  *Verifier
+		principal *Principal
+	)
+
+	issue := func(lifetime time.Duration) string {
+		token, err := issuer.Issue(principal, lifetime)
+
+		Expect(err).To(BeNil())
+
+		return token
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		issuer, err = clienttest.NewIssuer()
+
+		Expect(err).To(BeNil())
+
+		verifier = issuer.Verifier()
+		principal = &Principal{ID: userID, Email: "erik@eies.land", Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS"}}
+	})
+
+	AfterEach(func() {
+		issuer.Close()
+	})
+
+	Describe("Verify", func() {
+
+		It("should return the principal of the token", func() {
+			p, err := verifier.Verify(issue(time.Minute))
+
+			Expect(err).To(BeNil())
+			Expect(p).To(Equal(principal))
+		})
+
+		It("should reject expired tokens and tokens of other issuers", func() {
+			_, err := verifier.Verify(issue(-time.Minute))
+
+			Expect(err).NotTo(BeNil())
+
+			other, err := clienttest.NewIssuer()
+
+			Expect(err).To(BeNil())
+
+			defer other.Close()
+
+			token, err := other.Issue(principal, time.Minute)
+
+			Expect(err).To(BeNil())
+
+			_, err = verifier.Verify(token)
+
+			Expect(err).NotTo(BeNil())
+
+			_, err = issuer.Verifier(WithAudience("other")).Verify(issue(time.Minute))
+
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should cache the keys", func() {
+			for i := 0; i < 3; i++ {
+				_, err := verifier.Verify(issue(time.Minute))
+
+				Expect(err).To(BeNil())
+			}
+
+			Expect(issuer.Fetches()).To(Equal(1))
+		})
+
+		It("should fetch the keys again when they are rotated", func() {
+			verifier = issuer.Verifier(WithRefreshInterval(time.Millisecond))
+
+			_, err := verifier.Verify(issue(time.Minute))
+
+			Expect(err).To(BeNil())
+			Expect(issuer.Rotate()).To(Succeed())
+
+			time.Sleep(2 * time.Millisecond)
+
+			_, err = verifier.Verify(issue(time.Minute))
+
+			Expect(err).To(BeNil())
+			Expect(issuer.Fetches()).To(Equal(2))
+		})
+	})
+
+	Describe("Middleware", func() {
+
+		var (
+			served *Principal
+		)
+
+		serve := func(handler http.Handler, token string) int {
+			served = nil
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			handler.ServeHTTP(w, r)
+
+			return w.Code
+		}
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = PrincipalFromContext(r.Context())
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		It("should put the principal in the context", func() {
+			Expect(serve(verifier.Middleware(next), issue(time.Minute))).To(Equal(http.StatusNoContent))
+			Expect(served).To(Equal(principal))
+		})
+
+		It("should reject requests without a valid token", func() {
+			Expect(serve(verifier.Middleware(next), "")).To(Equal(http.StatusUnauthorized))
+			Expect(serve(verifier.Middleware(next), "invalid")).To(Equal(http.StatusUnauthorized))
+			Expect(served).To(BeNil())
+		})
+
+		It("should require authorities", func() {
+			Expect(serve(verifier.Middleware(RequireAuthority("READ_USERS")(next)), issue(time.Minute))).To(Equal(http.StatusNoContent))
+			Expect(serve(verifier.Middleware(RequireAuthority("WRITE_USERS")(next)), issue(time.Minute))).To(Equal(http.StatusForbidden))
+			Expect(serve(verifier.Middleware(RequireRole("ADMIN")(next)), issue(time.Minute))).To(Equal(http.StatusNoContent))
+		})
+	})
+
+	Describe("Interceptors", func() {
+
+		incoming := func(token string) context.Context {
+			return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		}
+
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return PrincipalFromContext(ctx), nil
+		}
+
+		It("should put the principal in the context of unary calls", func() {
+			response, err := verifier.UnaryServerInterceptor()(incoming(issue(time.Minute)), nil, &grpc.UnaryServerInfo{}, handler)
+
+			Expect(err).To(BeNil())
+			Expect(response).To(Equal(principal))
+
+			_, err = verifier.UnaryServerInterceptor()(incoming("invalid"), nil, &grpc.UnaryServerInfo{}, handler)
+
+			Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+
+			_, err = RequireAuthorityUnary("WRITE_USERS")(NewContext(context.Background(), principal), nil, &grpc.UnaryServerInfo{}, handler)
+
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+
+		It("should put the principal in the context of streams", func() {
+			var served *Principal
+
+			err := verifier.StreamServerInterceptor()(nil, &serverStream{ctx: incoming(issue(time.Minute))}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+				served = PrincipalFromContext(stream.Context())
+				return nil
+			})
+
+			Expect(err).To(BeNil())
+			Expect(served).To(Equal(principal))
+		})
+	})
+})
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,184 @@
+// Package clienttest provides a fake authgo issuer, for the tests of services that verify
+// tokens with package client.
+package clienttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"github.com/di0nys1us/authgo/client"
+)
+
+const (
+	jwksPath = "/.well-known/jwks.json"
+)
+
+type claims struct {
+	*jwt.StandardClaims
+	UserID        string   `json:"uid"`
+	ClientID      string   `json:"client_id,omitempty"`
+	PrincipalType string   `json:"principal_type,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	Authorities   []string `json:"authorities,omitempty"`
+	Actor         *actor   `json:"act,omitempty"`
+}
+
+type actor struct {
+	Subject string `json:"sub"`
+	UserID  string `json:"uid"`
+}
+
+type key struct {
+	id      string
+	private *ecdsa.PrivateKey
+}
+
+// Issuer serves a JWKS endpoint like authgo does, and issues tokens signed with its keys.
+type Issuer struct {
+	sync.Mutex
+	server  *httptest.Server
+	keys    []*key
+	fetches int
+}
+
+// NewIssuer starts an issuer. It must be closed when the test is done.
+func NewIssuer() (*Issuer, error) {
+	i := &Issuer{}
+
+	err := i.Rotate()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	i.server = httptest.NewServer(http.HandlerFunc(i.serveJWKS))
+
+	return i, nil
+}
+
+// URL is the base URL of the issuer, which is also its iss claim.
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+// Verifier returns a Verifier for the tokens of the issuer.
+func (i *Issuer) Verifier(opts ...client.Option) *client.Verifier {
+	return client.New(i.URL(), opts...)
+}
+
+// Fetches counts the requests for the keys.
+func (i *Issuer) Fetches() int {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.fetches
+}
+
+// Close stops the issuer.
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// Rotate signs the next tokens with a new key. The old keys are still published.
+func (i *Issuer) Rotate() error {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	i.keys = append(i.keys, &key{fmt.Sprintf("key-%d", len(i.keys)+1), private})
+
+	return nil
+}
+
+// Issue returns a token for the principal, which expires after the lifetime. A negative
+// lifetime gives a token that has already expired.
+func (i *Issuer) Issue(p *client.Principal, lifetime time.Duration) (string, error) {
+	i.Lock()
+	k := i.keys[len(i.keys)-1]
+	i.Unlock()
+
+	now := time.Now()
+	c := &claims{
+		StandardClaims: &jwt.StandardClaims{
+			Audience:  client.DefaultAudience,
+			ExpiresAt: now.Add(lifetime).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    i.URL(),
+			NotBefore: now.Add(-time.Minute).Unix(),
+			Subject:   p.Email,
+		},
+		UserID:      p.ID,
+		ClientID:    p.ClientID,
+		Roles:       p.Roles,
+		Authorities: p.Authorities,
+	}
+
+	if p.ServiceAccount {
+		c.PrincipalType = "service_account"
+	}
+
+	if p.ActorID != "" {
+		c.Actor = &actor{UserID: p.ActorID}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	token.Header["kid"] = k.id
+
+	signedToken, err := token.SignedString(k.private)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return signedToken, nil
+}
+
+func (i *Issuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != jwksPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	i.fetches++
+
+	keys := []map[string]string{}
+
+	for _, k := range i.keys {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"kid": k.id,
+			"use": "sig",
+			"alg": jwt.SigningMethodES256.Alg(),
+			"crv": "P-256",
+			"x":   encodeJWKInt(k.private.X.FillBytes(make([]byte, 32))),
+			"y":   encodeJWKInt(k.private.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
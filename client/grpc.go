@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	metadataKeyAuthorization = "authorization"
+)
+
+// UnaryServerInterceptor only lets calls through that carry a valid token as a Bearer
+// credential in their authorization metadata, and puts the principal of the token in their
+// context.
+func (v *Verifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := v.verifyCall(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func (v *Verifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.verifyCall(ss.Context())
+
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ss, ctx})
+	}
+}
+
+func (v *Verifier) verifyCall(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, authorization := range md.Get(metadataKeyAuthorization) {
+		token, ok := bearerToken(authorization)
+
+		if !ok {
+			continue
+		}
+
+		p, err := v.Verify(token)
+
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return NewContext(ctx, p), nil
+	}
+
+	return nil, status.Error(codes.Unauthenticated, ErrMissingToken.Error())
+}
+
+// RequireAuthorityUnary only lets calls through whose principal has at least one of the
+// authorities. It must come after UnaryServerInterceptor.
+func RequireAuthorityUnary(authorities ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !HasAuthority(ctx, authorities...) {
+			return nil, status.Error(codes.PermissionDenied, ErrForbidden.Error())
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// serverStream replaces the context of a stream with one that carries the principal.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	headerAuthorization       = "Authorization"
+	headerWWWAuthenticate     = "WWW-Authenticate"
+	authorizationSchemeBearer = "Bearer"
+	bearerChallenge           = `Bearer realm="authgo"`
+	jwtCookieName             = "authgo_token"
+)
+
+var (
+	// ErrForbidden is returned when the principal of the request lacks the authority for what
+	// they asked for.
+	ErrForbidden = errors.New("authgo: forbidden")
+)
+
+// Middleware only lets requests through that carry a valid token, as a Bearer credential or
+// in the cookie of authgo, and puts the principal of the token in their context.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		p, err := v.verifyRequest(r)
+
+		if err != nil {
+			w.Header().Set(headerWWWAuthenticate, bearerChallenge)
+
+			return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+
+		return nil
+	})
+}
+
+func (v *Verifier) verifyRequest(r *http.Request) (*Principal, error) {
+	if token, ok := bearerToken(r.Header.Get(headerAuthorization)); ok {
+		return v.Verify(token)
+	}
+
+	if cookie, err := r.Cookie(jwtCookieName); err == nil && cookie.Value != "" {
+		return v.Verify(cookie.Value)
+	}
+
+	return nil, errors.WithStack(ErrMissingToken)
+}
+
+// RequireAuthority only lets requests through whose principal has at least one of the
+// authorities. It must come after Middleware.
+func RequireAuthority(authorities ...string) func(http.Handler) http.Handler {
+	return require(func(ctx context.Context) bool {
+		return HasAuthority(ctx, authorities...)
+	})
+}
+
+// RequireRole only lets requests through whose principal has at least one of the roles. It
+// must come after Middleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return require(func(ctx context.Context) bool {
+		return HasRole(ctx, roles...)
+	})
+}
+
+func require(allowed func(ctx context.Context) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			if !allowed(r.Context()) {
+				return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, ErrForbidden))
+			}
+
+			next.ServeHTTP(w, r)
+
+			return nil
+		})
+	}
+}
+
+func bearerToken(authorization string) (string, bool) {
+	parts := strings.SplitN(authorization, " ", 2)
+
+	if len(parts) != 2 || !strings.EqualFold(parts[0], authorizationSchemeBearer) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(parts[1])
+
+	return token, token != ""
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	signingAlgorithmEdDSA = "EdDSA"
+	jwkKeyTypeRSA         = "RSA"
+	jwkKeyTypeEC          = "EC"
+	jwkKeyTypeOKP         = "OKP"
+	jwkCurveP256          = "P-256"
+	jwkCurveEd25519       = "Ed25519"
+)
+
+var (
+	errUnknownKeyID         = errors.New("authgo: unknown key id")
+	errInvalidSigningMethod = errors.New("authgo: invalid signing method")
+	errUnsupportedKey       = errors.New("authgo: unsupported key")
+)
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type jwks struct {
+	Keys []*jwk `json:"keys"`
+}
+
+type key struct {
+	algorithm string
+	public    interface{}
+}
+
+// keyCache keeps the verification keys of the issuer in memory. They are fetched again when
+// they grow old, and when a token names a key the cache has not seen, since the issuer
+// rotates its keys.
+type keyCache struct {
+	sync.Mutex
+	url             string
+	httpClient      *http.Client
+	refreshInterval time.Duration
+	keys            map[string]*key
+	fetchedAt       time.Time
+}
+
+// verificationKey implements jwt.Keyfunc.
+func (kc *keyCache) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header[jwtHeaderKeyID].(string)
+
+	if kid == "" {
+		return nil, errUnknownKeyID
+	}
+
+	k, err := kc.find(kid)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if t.Method.Alg() != k.algorithm {
+		return nil, errInvalidSigningMethod
+	}
+
+	return k.public, nil
+}
+
+func (kc *keyCache) find(kid string) (*key, error) {
+	kc.Lock()
+	defer kc.Unlock()
+
+	now := time.Now()
+	age := now.Sub(kc.fetchedAt)
+
+	if k, ok := kc.keys[kid]; ok && age < kc.refreshInterval {
+		return k, nil
+	}
+
+	// Unknown keys must not let anyone make us hammer the issuer.
+	if age >= minRefreshInterval || age >= kc.refreshInterval {
+		err := kc.fetch()
+		kc.fetchedAt = now
+
+		// The keys we have stay usable while the issuer cannot be reached.
+		if err != nil && kc.keys[kid] == nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if k, ok := kc.keys[kid]; ok {
+		return k, nil
+	}
+
+	return nil, errUnknownKeyID
+}
+
+func (kc *keyCache) fetch() error {
+	response, err := kc.httpClient.Get(kc.url)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("authgo: fetching keys failed with status %d", response.StatusCode)
+	}
+
+	set := &jwks{}
+	err = json.NewDecoder(response.Body).Decode(set)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys := make(map[string]*key, len(set.Keys))
+
+	for _, j := range set.Keys {
+		public, err := j.publicKey()
+
+		// Keys we cannot use are skipped, so that new kinds of keys do not break us.
+		if err != nil {
+			continue
+		}
+
+		keys[j.KeyID] = &key{j.Algorithm, public}
+	}
+
+	kc.keys = keys
+
+	return nil
+}
+
+func (j *jwk) publicKey() (interface{}, error) {
+	switch j.KeyType {
+	case jwkKeyTypeRSA:
+		n, err := decodeJWKInt(j.N)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		e, err := decodeJWKInt(j.E)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case jwkKeyTypeEC:
+		if j.Curve != jwkCurveP256 {
+			return nil, errUnsupportedKey
+		}
+
+		x, err := decodeJWKInt(j.X)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		y, err := decodeJWKInt(j.Y)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case jwkKeyTypeOKP:
+		if j.Curve != jwkCurveEd25519 {
+			return nil, errUnsupportedKey
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, errors.Errorf("authgo: unsupported key type %q", j.KeyType)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// signingMethodEdDSA adds Ed25519 support, which jwt-go does not ship with. Only verifying
+// is supported.
+type signingMethodEdDSA struct{}
+
+func init() {
+	if jwt.GetSigningMethod(signingAlgorithmEdDSA) == nil {
+		jwt.RegisterSigningMethod(signingAlgorithmEdDSA, func() jwt.SigningMethod {
+			return &signingMethodEdDSA{}
+		})
+	}
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return signingAlgorithmEdDSA
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, k interface{}) error {
+	public, ok := k.(ed25519.PublicKey)
+
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, k interface{}) (string, error) {
+	return "", jwt.ErrInvalidKeyType
+}
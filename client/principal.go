@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+)
+
+const (
+	ctxKeyPrincipal = contextKeyPrincipal("ctxKeyPrincipal")
+)
+
+type contextKey string
+type contextKeyPrincipal contextKey
+
+// Principal is whom a token was issued to.
+type Principal struct {
+	ID          string
+	Email       string
+	Roles       []string
+	Authorities []string
+	// ClientID is the OAuth 2.0 client the token was issued to, if any.
+	ClientID string
+	// ServiceAccount is set for the tokens of service accounts.
+	ServiceAccount bool
+	// ActorID is who really acts, when support staff impersonate the user.
+	ActorID string
+}
+
+// HasAuthority reports whether the principal has at least one of the authorities.
+func (p *Principal) HasAuthority(authorities ...string) bool {
+	return containsAny(p.Authorities, authorities)
+}
+
+// HasRole reports whether the principal has at least one of the roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	return containsAny(p.Roles, roles)
+}
+
+// NewContext returns a copy of the context that carries the principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKeyPrincipal, p)
+}
+
+// PrincipalFromContext returns the principal of the request, or nil when there is none.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKeyPrincipal).(*Principal)
+	return p
+}
+
+// HasAuthority reports whether the principal of the request has at least one of the
+// authorities.
+func HasAuthority(ctx context.Context, authorities ...string) bool {
+	p := PrincipalFromContext(ctx)
+	return p != nil && p.HasAuthority(authorities...)
+}
+
+// HasRole reports whether the principal of the request has at least one of the roles.
+func HasRole(ctx context.Context, roles ...string) bool {
+	p := PrincipalFromContext(ctx)
+	return p != nil && p.HasRole(roles...)
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/graph-gophers/graphql-go"
+)
+
+type clientResolver struct {
+	repository repository
+	client     *client
+}
+
+func (r *clientResolver) ID() graphql.ID {
+	return graphQLID(r.client.ID)
+}
+
+func (r *clientResolver) Name() string {
+	return r.client.Name
+}
+
+func (r *clientResolver) Confidential() bool {
+	return r.client.SecretHash != "" || r.client.PublicKey != ""
+}
+
+func (r *clientResolver) RedirectURIs() []string {
+	return r.client.RedirectURIs
+}
+
+func (r *clientResolver) ServiceAccount() (*userResolver, error) {
+	if r.client.ServiceAccountID == "" {
+		return nil, nil
+	}
+
+	user, err := r.repository.findUserByID(r.client.ServiceAccountID)
+
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	return &userResolver{r.repository, user}, nil
+}
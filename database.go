@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 )
 
 const (
@@ -67,6 +68,12 @@ func (db *db) generateUUID() (string, error) {
 	return generated, nil
 }
 
+// isUUID guards lookups by ids that come from outside, which postgres would reject.
+func isUUID(s string) bool {
+	_, err := uuid.FromString(s)
+	return err == nil
+}
+
 type tx struct {
 	*sqlx.Tx
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type emailVerificationToken struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	Email     string     `db:"email"`
+	TokenHash string     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+func (db *db) SaveEmailVerificationToken(token *security.EmailVerificationToken) error {
+	_, err := db.NamedExec(sqlSaveEmailVerificationToken, emailVerificationToken(*token))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindEmailVerificationToken(tokenHash string) (*security.EmailVerificationToken, error) {
+	t := emailVerificationToken{}
+
+	err := db.Get(&t, sqlFindEmailVerificationToken, tokenHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	token := security.EmailVerificationToken(t)
+
+	return &token, nil
+}
+
+func (db *db) FindLatestEmailVerificationToken(userID string) (*security.EmailVerificationToken, error) {
+	t := emailVerificationToken{}
+
+	err := db.Get(&t, sqlFindLatestEmailVerificationToken, userID)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	token := security.EmailVerificationToken(t)
+
+	return &token, nil
+}
+
+func (db *db) UseEmailVerificationToken(id string, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseEmailVerificationToken, id, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (db *db) DeleteUserEmailVerificationTokens(userID string) error {
+	_, err := db.Exec(sqlDeleteUserEmailVerificationTokens, userID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlSaveEmailVerificationToken = `
+		insert into "authgo"."email_verification_token" (
+			"id",
+			"user_id",
+			"email",
+			"token_hash",
+			"created_at",
+			"expires_at"
+		) values (
+			:id,
+			:user_id,
+			:email,
+			:token_hash,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlFindEmailVerificationToken = `
+		select
+			"email_verification_token"."id",
+			"email_verification_token"."user_id",
+			"email_verification_token"."email",
+			"email_verification_token"."token_hash",
+			"email_verification_token"."created_at",
+			"email_verification_token"."expires_at",
+			"email_verification_token"."used_at"
+		from "authgo"."email_verification_token"
+		where "email_verification_token"."token_hash" = $1;
+	`
+	sqlFindLatestEmailVerificationToken = `
+		select
+			"email_verification_token"."id",
+			"email_verification_token"."user_id",
+			"email_verification_token"."email",
+			"email_verification_token"."token_hash",
+			"email_verification_token"."created_at",
+			"email_verification_token"."expires_at",
+			"email_verification_token"."used_at"
+		from "authgo"."email_verification_token"
+		where "email_verification_token"."user_id" = $1
+		order by "email_verification_token"."created_at" desc
+		limit 1;
+	`
+	sqlUseEmailVerificationToken = `
+		update "authgo"."email_verification_token" set
+			"used_at" = $2
+		where "email_verification_token"."id" = $1
+			and "email_verification_token"."used_at" is null;
+	`
+	sqlDeleteUserEmailVerificationTokens = `
+		delete from "authgo"."email_verification_token"
+		where "email_verification_token"."user_id" = $1;
+	`
+)
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"time"
 
+	"github.com/di0nys1us/authgo/security"
 	"github.com/pkg/errors"
 )
 
@@ -75,6 +77,53 @@ func (e events) Value() (driver.Value, error) {
 	return string(v), nil
 }
 
+// RecordEvent appends a security event, such as a failed login, to the events of the user.
+func (db *db) RecordEvent(ctx context.Context, userID, eventType, description string) error {
+	return db.commit(func(tx *tx) error {
+		return db.appendUserEvent(ctx, tx, userID, eventType, description)
+	})
+}
+
+// appendUserEvent appends an event to the user, made by the actor of the context. Support
+// staff that impersonate the user are recorded as themselves.
+func (db *db) appendUserEvent(ctx context.Context, tx *tx, userID, eventType, description string) error {
+	eventID, err := db.generateUUID()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	value, err := events{&event{
+		ID:          eventID,
+		CreatedBy:   eventCreatedBy(ctx, userID),
+		CreatedAt:   time.Now(),
+		Type:        eventType,
+		Description: description,
+	}}.Value()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.Exec(sqlAppendUserEvent, userID, value)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// eventCreatedBy is the ID of whoever made the request, even when they impersonate someone.
+// Without anyone signed in, as for logins and links sent by mail, it is the user itself.
+func eventCreatedBy(ctx context.Context, userID string) string {
+	if actorID := security.ActorIDFromContext(ctx); actorID != "" {
+		return actorID
+	}
+
+	return userID
+}
+
 func (db *db) findAllEvents() ([]*event, error) {
 	events := []*event{}
 
@@ -166,11 +215,19 @@ const (
 		where "user_event"."user_id" = $1
 		order by "event"."created_at" desc, "event"."id" desc;
 	`
+	sqlAppendUserEvent = `
+		update "authgo"."user" set
+			"events" = "user"."events" || cast($2 as jsonb)
+		where "user"."id" = $1;
+	`
 )
 
 const (
-	eventTypeUserCreated = "USER_CREATED"
-	eventTypeUserUpdated = "USER_UPDATED"
+	eventTypeUserCreated          = "USER_CREATED"
+	eventTypeUserUpdated          = "USER_UPDATED"
+	eventTypeUserEnabled          = "USER_ENABLED"
+	eventTypeCredentialRegistered = "CREDENTIAL_REGISTERED"
+	eventTypeCredentialRemoved    = "CREDENTIAL_REMOVED"
 )
 
 type eventType struct {
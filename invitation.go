@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type invitation struct {
+	ID        string     `db:"id"`
+	Email     string     `db:"email"`
+	InvitedBy string     `db:"invited_by"`
+	TokenHash string     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+func (db *db) SaveInvitation(i *security.Invitation) error {
+	_, err := db.NamedExec(sqlSaveInvitation, invitation(*i))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindInvitation(tokenHash string) (*security.Invitation, error) {
+	i := invitation{}
+
+	err := db.Get(&i, sqlFindInvitation, tokenHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	found := security.Invitation(i)
+
+	return &found, nil
+}
+
+func (db *db) UseInvitation(id string, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseInvitation, id, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+const (
+	sqlSaveInvitation = `
+		insert into "authgo"."invitation" (
+			"id",
+			"email",
+			"invited_by",
+			"token_hash",
+			"created_at",
+			"expires_at"
+		) values (
+			:id,
+			:email,
+			:invited_by,
+			:token_hash,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlFindInvitation = `
+		select
+			"invitation"."id",
+			"invitation"."email",
+			"invitation"."invited_by",
+			"invitation"."token_hash",
+			"invitation"."created_at",
+			"invitation"."expires_at",
+			"invitation"."used_at"
+		from "authgo"."invitation"
+		where "invitation"."token_hash" = $1;
+	`
+	sqlUseInvitation = `
+		update "authgo"."invitation" set
+			"used_at" = $2
+		where "invitation"."id" = $1
+			and "invitation"."used_at" is null;
+	`
+)
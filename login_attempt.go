@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type loginAttempts struct {
+	Key          string     `db:"key"`
+	Failures     int        `db:"failures"`
+	LastFailedAt time.Time  `db:"last_failed_at"`
+	LockedUntil  *time.Time `db:"locked_until"`
+}
+
+func (db *db) FindLoginAttempts(key string) (*security.LoginAttempts, error) {
+	a := loginAttempts{}
+
+	err := db.Get(&a, sqlFindLoginAttempts, key)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	attempts := security.LoginAttempts(a)
+
+	return &attempts, nil
+}
+
+func (db *db) RecordLoginFailure(key string, failedAt, since time.Time) (*security.LoginAttempts, error) {
+	a := loginAttempts{}
+
+	err := db.Get(&a, sqlRecordLoginFailure, key, failedAt, since)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	attempts := security.LoginAttempts(a)
+
+	return &attempts, nil
+}
+
+func (db *db) LockLogin(key string, until time.Time) error {
+	_, err := db.Exec(sqlLockLogin, key, until)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) DeleteLoginAttempts(key string) error {
+	_, err := db.Exec(sqlDeleteLoginAttempts, key)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlFindLoginAttempts = `
+		select
+			"login_attempt"."key",
+			"login_attempt"."failures",
+			"login_attempt"."last_failed_at",
+			"login_attempt"."locked_until"
+		from "authgo"."login_attempt"
+		where "login_attempt"."key" = $1;
+	`
+	sqlRecordLoginFailure = `
+		insert into "authgo"."login_attempt" (
+			"key",
+			"failures",
+			"last_failed_at"
+		) values (
+			$1,
+			1,
+			$2
+		) on conflict ("key") do update set
+			"failures" = case
+				when "login_attempt"."last_failed_at" < $3 then 1
+				else "login_attempt"."failures" + 1
+			end,
+			"last_failed_at" = excluded."last_failed_at"
+		returning
+			"login_attempt"."key",
+			"login_attempt"."failures",
+			"login_attempt"."last_failed_at",
+			"login_attempt"."locked_until";
+	`
+	sqlLockLogin = `
+		update "authgo"."login_attempt" set
+			"failures" = 0,
+			"locked_until" = $2
+		where "login_attempt"."key" = $1;
+	`
+	sqlDeleteLoginAttempts = `
+		delete from "authgo"."login_attempt"
+		where "login_attempt"."key" = $1;
+	`
+)
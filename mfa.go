@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type totpFactor struct {
+	UserID       string     `db:"user_id"`
+	Secret       string     `db:"secret"`
+	ConfirmedAt  *time.Time `db:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+func (db *db) FindTOTPFactor(userID string) (*security.TOTPFactor, error) {
+	f := totpFactor{}
+
+	err := db.Get(&f, sqlFindTOTPFactor, userID)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	factor := security.TOTPFactor(f)
+
+	return &factor, nil
+}
+
+func (db *db) SaveTOTPFactor(factor *security.TOTPFactor) error {
+	_, err := db.NamedExec(sqlSaveTOTPFactor, totpFactor(*factor))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) UseTOTPStep(userID string, step int64) (bool, error) {
+	result, err := db.Exec(sqlUseTOTPStep, userID, step)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (db *db) DeleteTOTPFactor(userID string) error {
+	return db.commit(func(tx *tx) error {
+		_, err := tx.Exec(sqlDeleteRecoveryCodes, userID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = tx.Exec(sqlDeleteTOTPFactor, userID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+}
+
+func (db *db) SaveRecoveryCodes(userID string, codeHashes []string) error {
+	return db.commit(func(tx *tx) error {
+		_, err := tx.Exec(sqlDeleteRecoveryCodes, userID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, codeHash := range codeHashes {
+			_, err = tx.Exec(sqlSaveRecoveryCode, userID, codeHash)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *db) UseRecoveryCode(userID, codeHash string, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseRecoveryCode, userID, codeHash, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// MFARequired requires a second factor from users with any role that requires one.
+func (db *db) MFARequired(userID string) (bool, error) {
+	var required bool
+
+	err := db.Get(&required, sqlFindUserMFARequired, userID)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return required, nil
+}
+
+const (
+	sqlFindTOTPFactor = `
+		select
+			"totp_factor"."user_id",
+			"totp_factor"."secret",
+			"totp_factor"."confirmed_at",
+			"totp_factor"."last_used_step",
+			"totp_factor"."created_at"
+		from "authgo"."totp_factor"
+		where "totp_factor"."user_id" = $1;
+	`
+	sqlSaveTOTPFactor = `
+		insert into "authgo"."totp_factor" (
+			"user_id",
+			"secret",
+			"confirmed_at",
+			"last_used_step",
+			"created_at"
+		) values (
+			:user_id,
+			:secret,
+			:confirmed_at,
+			:last_used_step,
+			:created_at
+		) on conflict ("user_id") do update set
+			"secret" = excluded."secret",
+			"confirmed_at" = excluded."confirmed_at",
+			"last_used_step" = excluded."last_used_step",
+			"created_at" = excluded."created_at";
+	`
+	sqlUseTOTPStep = `
+		update "authgo"."totp_factor" set
+			"last_used_step" = $2
+		where "totp_factor"."user_id" = $1
+			and "totp_factor"."last_used_step" < $2;
+	`
+	sqlDeleteTOTPFactor = `
+		delete from "authgo"."totp_factor"
+		where "totp_factor"."user_id" = $1;
+	`
+	sqlSaveRecoveryCode = `
+		insert into "authgo"."recovery_code" (
+			"user_id",
+			"code_hash"
+		) values (
+			$1,
+			$2
+		);
+	`
+	sqlUseRecoveryCode = `
+		update "authgo"."recovery_code" set
+			"used_at" = $3
+		where "recovery_code"."user_id" = $1
+			and "recovery_code"."code_hash" = $2
+			and "recovery_code"."used_at" is null;
+	`
+	sqlDeleteRecoveryCodes = `
+		delete from "authgo"."recovery_code"
+		where "recovery_code"."user_id" = $1;
+	`
+	sqlFindUserMFARequired = `
+		select exists (
+			select 1
+			from "authgo"."role"
+				inner join "authgo"."user_role" on "user_role"."role_id" = "role"."id"
+			where "user_role"."user_id" = $1
+				and "role"."mfa_required"
+		);
+	`
+)
@@ -0,0 +1 @@
+ALTER TABLE "authgo"."user" DROP COLUMN "tokens_revoked_at";
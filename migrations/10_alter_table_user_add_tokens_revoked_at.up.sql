@@ -0,0 +1 @@
+ALTER TABLE "authgo"."user" ADD COLUMN "tokens_revoked_at" TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,8 @@
+CREATE TABLE "authgo"."client" (
+    "id" UUID NOT NULL DEFAULT uuid_generate_v1mc(),
+    "name" VARCHAR(255) NOT NULL,
+    "secret_hash" TEXT NOT NULL DEFAULT '',
+    "redirect_uris" TEXT[] NOT NULL DEFAULT '{}',
+
+    PRIMARY KEY ("id")
+);
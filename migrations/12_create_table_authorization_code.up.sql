@@ -0,0 +1,14 @@
+CREATE TABLE "authgo"."authorization_code" (
+    "code_hash" CHAR(64) NOT NULL,
+    "client_id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "redirect_uri" TEXT NOT NULL,
+    "scope" TEXT NOT NULL,
+    "code_challenge" VARCHAR(128) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY ("code_hash"),
+    FOREIGN KEY ("client_id") REFERENCES "authgo"."client" ("id"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
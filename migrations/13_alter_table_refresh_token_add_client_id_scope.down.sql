@@ -0,0 +1,3 @@
+ALTER TABLE "authgo"."refresh_token"
+    DROP COLUMN "client_id",
+    DROP COLUMN "scope";
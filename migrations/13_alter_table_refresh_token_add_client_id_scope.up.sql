@@ -0,0 +1,3 @@
+ALTER TABLE "authgo"."refresh_token"
+    ADD COLUMN "client_id" UUID REFERENCES "authgo"."client" ("id"),
+    ADD COLUMN "scope" TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+ALTER TABLE "authgo"."authorization_code"
+    DROP COLUMN "nonce",
+    DROP COLUMN "auth_time";
+
+ALTER TABLE "authgo"."refresh_token"
+    DROP COLUMN "auth_time";
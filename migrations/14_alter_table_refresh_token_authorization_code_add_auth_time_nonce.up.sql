@@ -0,0 +1,6 @@
+ALTER TABLE "authgo"."refresh_token"
+    ADD COLUMN "auth_time" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+
+ALTER TABLE "authgo"."authorization_code"
+    ADD COLUMN "nonce" TEXT NOT NULL DEFAULT '',
+    ADD COLUMN "auth_time" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
@@ -0,0 +1,6 @@
+ALTER TABLE "authgo"."client"
+    DROP COLUMN "public_key",
+    DROP COLUMN "service_account_id";
+
+ALTER TABLE "authgo"."user"
+    DROP COLUMN "service_account";
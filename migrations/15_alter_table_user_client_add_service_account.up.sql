@@ -0,0 +1,6 @@
+ALTER TABLE "authgo"."user"
+    ADD COLUMN "service_account" BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE "authgo"."client"
+    ADD COLUMN "public_key" TEXT NOT NULL DEFAULT '',
+    ADD COLUMN "service_account_id" UUID REFERENCES "authgo"."user" ("id");
@@ -0,0 +1 @@
+DROP TABLE "authgo"."totp_factor";
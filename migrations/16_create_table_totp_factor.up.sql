@@ -0,0 +1,10 @@
+CREATE TABLE "authgo"."totp_factor" (
+    "user_id" UUID NOT NULL,
+    "secret" VARCHAR(64) NOT NULL,
+    "confirmed_at" TIMESTAMP WITH TIME ZONE,
+    "last_used_step" BIGINT NOT NULL DEFAULT 0,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY ("user_id"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
@@ -0,0 +1 @@
+DROP TABLE "authgo"."recovery_code";
@@ -0,0 +1,8 @@
+CREATE TABLE "authgo"."recovery_code" (
+    "user_id" UUID NOT NULL,
+    "code_hash" CHAR(64) NOT NULL,
+    "used_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("user_id", "code_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
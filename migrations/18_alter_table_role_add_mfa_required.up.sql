@@ -0,0 +1,2 @@
+ALTER TABLE "authgo"."role"
+    ADD COLUMN "mfa_required" BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+CREATE TABLE "authgo"."webauthn_credential" (
+    "id" VARCHAR(1366) NOT NULL,
+    "user_id" UUID NOT NULL,
+    "name" VARCHAR(64) NOT NULL,
+    "public_key" BYTEA NOT NULL,
+    "sign_count" BIGINT NOT NULL DEFAULT 0,
+    "transports" TEXT[] NOT NULL DEFAULT '{}',
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "last_used_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
@@ -0,0 +1 @@
+DROP TABLE "authgo"."login_attempt";
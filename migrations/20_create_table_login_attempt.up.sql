@@ -0,0 +1,8 @@
+CREATE TABLE "authgo"."login_attempt" (
+    "key" VARCHAR(128) NOT NULL,
+    "failures" INTEGER NOT NULL DEFAULT 0,
+    "last_failed_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "locked_until" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("key")
+);
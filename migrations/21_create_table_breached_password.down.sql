@@ -0,0 +1 @@
+DROP TABLE "authgo"."breached_password";
@@ -0,0 +1,6 @@
+CREATE TABLE "authgo"."breached_password" (
+    "prefix" CHAR(5) NOT NULL,
+    "suffix" CHAR(35) NOT NULL,
+
+    PRIMARY KEY ("prefix", "suffix")
+);
@@ -0,0 +1 @@
+DROP TABLE "authgo"."password_reset_token";
@@ -0,0 +1,14 @@
+CREATE TABLE "authgo"."password_reset_token" (
+    "id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "token_hash" CHAR(64) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
+
+CREATE INDEX ON "authgo"."password_reset_token" ("user_id");
@@ -0,0 +1,6 @@
+-- The users that exist already count as verified, only new ones have to verify their email.
+ALTER TABLE "authgo"."user"
+    ADD COLUMN "email_verified" BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE "authgo"."user"
+    ALTER COLUMN "email_verified" SET DEFAULT FALSE;
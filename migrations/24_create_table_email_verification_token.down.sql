@@ -0,0 +1 @@
+DROP TABLE "authgo"."email_verification_token";
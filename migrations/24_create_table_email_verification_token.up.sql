@@ -0,0 +1,15 @@
+CREATE TABLE "authgo"."email_verification_token" (
+    "id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "email" VARCHAR(255) NOT NULL,
+    "token_hash" CHAR(64) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
+
+CREATE INDEX ON "authgo"."email_verification_token" ("user_id");
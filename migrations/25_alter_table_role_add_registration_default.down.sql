@@ -0,0 +1,2 @@
+ALTER TABLE "authgo"."role"
+    DROP COLUMN "registration_default";
@@ -0,0 +1,2 @@
+ALTER TABLE "authgo"."role"
+    ADD COLUMN "registration_default" BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+CREATE TABLE "authgo"."invitation" (
+    "id" UUID NOT NULL,
+    "email" VARCHAR(255) NOT NULL,
+    "invited_by" UUID NOT NULL,
+    "token_hash" CHAR(64) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("invited_by") REFERENCES "authgo"."user" ("id")
+);
@@ -0,0 +1,18 @@
+CREATE TABLE "authgo"."session" (
+    "id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "client_id" UUID,
+    "token_id" UUID NOT NULL,
+    "user_agent" TEXT NOT NULL,
+    "ip_address" VARCHAR(45) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "last_seen_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "revoked_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id"),
+    FOREIGN KEY ("client_id") REFERENCES "authgo"."client" ("id")
+);
+
+CREATE INDEX ON "authgo"."session" ("user_id");
@@ -0,0 +1,17 @@
+DELETE FROM "authgo"."role_authority"
+    WHERE "role_id" = (SELECT "id" FROM "authgo"."role" WHERE "name" = 'ADMIN');
+
+DELETE FROM "authgo"."user_role"
+    WHERE "role_id" = (SELECT "id" FROM "authgo"."role" WHERE "name" = 'ADMIN');
+
+DELETE FROM "authgo"."role" WHERE "name" = 'ADMIN';
+
+DELETE FROM "authgo"."authority" WHERE "name" IN (
+    'READ_USERS',
+    'WRITE_USERS',
+    'WRITE_ROLES',
+    'READ_CLIENTS',
+    'WRITE_CLIENTS',
+    'REVOKE_TOKENS',
+    'WRITE_BREACHED_PASSWORDS'
+);
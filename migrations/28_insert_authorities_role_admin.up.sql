@@ -0,0 +1,15 @@
+INSERT INTO "authgo"."authority" ("name") VALUES
+    ('READ_USERS'),
+    ('WRITE_USERS'),
+    ('WRITE_ROLES'),
+    ('READ_CLIENTS'),
+    ('WRITE_CLIENTS'),
+    ('REVOKE_TOKENS'),
+    ('WRITE_BREACHED_PASSWORDS');
+
+INSERT INTO "authgo"."role" ("name") VALUES ('ADMIN');
+
+INSERT INTO "authgo"."role_authority" ("role_id", "authority_id")
+    SELECT "role"."id", "authority"."id"
+    FROM "authgo"."role", "authgo"."authority"
+    WHERE "role"."name" = 'ADMIN';
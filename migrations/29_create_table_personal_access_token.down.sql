@@ -0,0 +1 @@
+DROP TABLE "authgo"."personal_access_token";
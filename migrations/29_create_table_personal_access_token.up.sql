@@ -0,0 +1,17 @@
+CREATE TABLE "authgo"."personal_access_token" (
+    "id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "name" VARCHAR(255) NOT NULL,
+    "token_hash" CHAR(64) NOT NULL,
+    "scopes" TEXT[] NOT NULL DEFAULT '{}',
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "last_used_at" TIMESTAMP WITH TIME ZONE,
+    "revoked_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
+
+CREATE INDEX ON "authgo"."personal_access_token" ("user_id");
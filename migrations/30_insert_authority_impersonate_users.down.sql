@@ -0,0 +1,4 @@
+DELETE FROM "authgo"."role_authority"
+    WHERE "authority_id" = (SELECT "id" FROM "authgo"."authority" WHERE "name" = 'IMPERSONATE_USERS');
+
+DELETE FROM "authgo"."authority" WHERE "name" = 'IMPERSONATE_USERS';
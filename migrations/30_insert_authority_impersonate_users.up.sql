@@ -0,0 +1,6 @@
+INSERT INTO "authgo"."authority" ("name") VALUES ('IMPERSONATE_USERS');
+
+INSERT INTO "authgo"."role_authority" ("role_id", "authority_id")
+    SELECT "role"."id", "authority"."id"
+    FROM "authgo"."role", "authgo"."authority"
+    WHERE "role"."name" = 'ADMIN' AND "authority"."name" = 'IMPERSONATE_USERS';
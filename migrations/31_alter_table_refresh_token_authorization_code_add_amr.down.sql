@@ -0,0 +1,5 @@
+ALTER TABLE "authgo"."authorization_code"
+    DROP COLUMN "amr";
+
+ALTER TABLE "authgo"."refresh_token"
+    DROP COLUMN "amr";
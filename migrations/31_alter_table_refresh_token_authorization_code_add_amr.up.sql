@@ -0,0 +1,5 @@
+ALTER TABLE "authgo"."refresh_token"
+    ADD COLUMN "amr" TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE "authgo"."authorization_code"
+    ADD COLUMN "amr" TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE "authgo"."user"
+    DROP COLUMN "pending_approval";
@@ -0,0 +1,2 @@
+ALTER TABLE "authgo"."user"
+    ADD COLUMN "pending_approval" BOOLEAN NOT NULL DEFAULT FALSE;
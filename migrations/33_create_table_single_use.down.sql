@@ -0,0 +1 @@
+DROP TABLE "authgo"."single_use";
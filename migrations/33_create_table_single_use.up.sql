@@ -0,0 +1,6 @@
+CREATE TABLE "authgo"."single_use" (
+    "id" TEXT NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY ("id")
+);
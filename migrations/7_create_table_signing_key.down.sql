@@ -0,0 +1 @@
+DROP TABLE "authgo"."signing_key";
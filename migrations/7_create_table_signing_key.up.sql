@@ -0,0 +1,8 @@
+CREATE TABLE "authgo"."signing_key" (
+    "id" UUID NOT NULL,
+    "algorithm" VARCHAR(16) NOT NULL,
+    "private_key" TEXT NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY ("id")
+);
@@ -0,0 +1,16 @@
+CREATE TABLE "authgo"."refresh_token" (
+    "id" UUID NOT NULL,
+    "family_id" UUID NOT NULL,
+    "user_id" UUID NOT NULL,
+    "token_hash" CHAR(64) NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at" TIMESTAMP WITH TIME ZONE,
+    "revoked_at" TIMESTAMP WITH TIME ZONE,
+
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "authgo"."user" ("id")
+);
+
+CREATE INDEX ON "authgo"."refresh_token" ("family_id");
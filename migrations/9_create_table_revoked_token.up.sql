@@ -0,0 +1,6 @@
+CREATE TABLE "authgo"."revoked_token" (
+    "token_id" UUID NOT NULL,
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY ("token_id")
+);
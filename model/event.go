@@ -46,7 +46,7 @@ func NewEvent(ctx context.Context, eventType, description string) (*Event, error
 
 	return &Event{
 		ID:          eventID.String(),
-		CreatedBy:   security.UserEmailFromContext(ctx),
+		CreatedBy:   security.ActorIDFromContext(ctx),
 		CreatedAt:   time.Now(),
 		Type:        eventType,
 		Description: description,
@@ -2,10 +2,65 @@ package main
 
 import (
 	"context"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+type tokenRevoker interface {
+	RevokeToken(tokenID string) error
+	RevokeUserTokens(userID string) error
+	RevokeSession(userID, sessionID string) error
+	RevokeAllSessions(userID string) error
+}
+
+type mfaManager interface {
+	EnrollTOTP(userID string) (*security.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	RegenerateRecoveryCodes(userID string) ([]string, error)
+	ResetMFA(userID string) error
+	RemoveWebAuthnCredential(ctx context.Context, userID, id string) error
+}
+
+type userUnlocker interface {
+	UnlockUser(userID string) error
+}
+
+type passwordValidator interface {
+	ValidatePassword(password string, owner security.PasswordOwner) error
+	RefreshBreachedPasswords(path string) (int, error)
+}
+
+type emailVerifier interface {
+	SendEmailVerification(userID string) error
+	ChangeEmail(userID, email string) error
+}
+
+type userInviter interface {
+	InviteUser(invitedBy, email string) error
+}
+
+type personalAccessTokenIssuer interface {
+	CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (string, *security.PersonalAccessToken, error)
+	RevokePersonalAccessToken(userID, id string) error
+}
+
+var (
+	errPersonalAccessTokenIssuedWithToken = errors.New("authgo: personal access tokens cannot create personal access tokens")
+	errEmailChangedWithUpdate             = errors.New("authgo: the email of a user is changed with changeEmail, so that it is verified")
 )
 
 type rootMutation struct {
 	repository repository
+	tokens     tokenRevoker
+	mfa        mfaManager
+	lockout    userUnlocker
+	passwords  passwordValidator
+	emails     emailVerifier
+	invites    userInviter
+	pats       personalAccessTokenIssuer
 }
 
 type identity struct {
@@ -18,6 +73,10 @@ type identity struct {
 func (m *rootMutation) CreateUser(ctx context.Context, args struct {
 	Input userInput
 }) (*userOutput, error) {
+	if err := authorizeField(ctx, "Mutation.createUser", ""); err != nil {
+		return nil, err
+	}
+
 	user := &user{
 		FirstName: args.Input.FirstName,
 		LastName:  args.Input.LastName,
@@ -27,7 +86,24 @@ func (m *rootMutation) CreateUser(ctx context.Context, args struct {
 		Deleted:   args.Input.Deleted,
 	}
 
-	err := m.repository.saveUser(ctx, user)
+	// The validation error is returned as it is, graphql-go only reads its extensions then.
+	err := m.passwords.ValidatePassword(user.Password, security.PasswordOwner{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.repository.saveUser(ctx, user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.emails.SendEmailVerification(user.ID)
 
 	if err != nil {
 		return nil, err
@@ -55,19 +131,87 @@ func (o *userOutput) User() *userResolver {
 
 // UpdateUser
 
-func (m *rootMutation) UpdateUser(args struct {
+func (m *rootMutation) UpdateUser(ctx context.Context, args struct {
 	Identity identity
 	Input    userInput
 }) (*userOutput, error) {
-	return nil, nil
+	if err := authorizeField(ctx, "Mutation.updateUser", ""); err != nil {
+		return nil, err
+	}
+
+	if !isUUID(args.Identity.ID) {
+		return &userOutput{}, nil
+	}
+
+	user, err := m.repository.findUserByID(args.Identity.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return &userOutput{}, nil
+	}
+
+	if args.Input.Email != user.Email {
+		return nil, errEmailChangedWithUpdate
+	}
+
+	user.Version = args.Identity.Version
+	user.FirstName = args.Input.FirstName
+	user.LastName = args.Input.LastName
+	user.Enabled = args.Input.Enabled
+	user.Deleted = args.Input.Deleted
+
+	// An empty password keeps the current one.
+	if args.Input.Password != "" {
+		err = m.passwords.ValidatePassword(args.Input.Password, security.PasswordOwner{
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.repository.updateUser(ctx, user, args.Input.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Disabled users and old passwords must not keep working through the tokens issued so far.
+	if !user.UserActive() || args.Input.Password != "" {
+		err = m.tokens.RevokeUserTokens(user.ID)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &userOutput{&userResolver{m.repository, user}}, nil
 }
 
 // CreateRole
 
-func (m *rootMutation) CreateRole(args struct {
+func (m *rootMutation) CreateRole(ctx context.Context, args struct {
 	Input roleInput
 }) (*roleOutput, error) {
-	return nil, nil
+	if err := authorizeField(ctx, "Mutation.createRole", ""); err != nil {
+		return nil, err
+	}
+
+	role := &role{Name: args.Input.Name}
+
+	err := m.repository.saveRole(role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleOutput{&roleResolver{m.repository, role}}, nil
 }
 
 type roleInput struct {
@@ -84,19 +228,58 @@ func (o *roleOutput) Role() *roleResolver {
 
 // UpdateRole
 
-func (m *rootMutation) UpdateRole(args struct {
+func (m *rootMutation) UpdateRole(ctx context.Context, args struct {
 	Identity identity
 	Input    roleInput
 }) (*roleOutput, error) {
-	return nil, nil
+	if err := authorizeField(ctx, "Mutation.updateRole", ""); err != nil {
+		return nil, err
+	}
+
+	if !isUUID(args.Identity.ID) {
+		return &roleOutput{}, nil
+	}
+
+	role, err := m.repository.findRoleByID(args.Identity.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return &roleOutput{}, nil
+	}
+
+	role.Version = args.Identity.Version
+	role.Name = args.Input.Name
+
+	err = m.repository.updateRole(role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleOutput{&roleResolver{m.repository, role}}, nil
 }
 
 // CreateAuthority
 
-func (m *rootMutation) CreateAuthority(args struct {
+func (m *rootMutation) CreateAuthority(ctx context.Context, args struct {
 	Input authorityInput
 }) (*authorityOutput, error) {
-	return nil, nil
+	if err := authorizeField(ctx, "Mutation.createAuthority", ""); err != nil {
+		return nil, err
+	}
+
+	authority := &authority{Name: args.Input.Name}
+
+	err := m.repository.saveAuthority(authority)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &authorityOutput{&authorityResolver{m.repository, authority}}, nil
 }
 
 type authorityInput struct {
@@ -113,9 +296,567 @@ func (o *authorityOutput) Authority() *authorityResolver {
 
 // UpdateAuthority
 
-func (m *rootMutation) UpdateAuthority(args struct {
+func (m *rootMutation) UpdateAuthority(ctx context.Context, args struct {
 	Identity identity
 	Input    authorityInput
 }) (*authorityOutput, error) {
-	return nil, nil
+	if err := authorizeField(ctx, "Mutation.updateAuthority", ""); err != nil {
+		return nil, err
+	}
+
+	if !isUUID(args.Identity.ID) {
+		return &authorityOutput{}, nil
+	}
+
+	authority, err := m.repository.findAuthorityByID(args.Identity.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if authority == nil {
+		return &authorityOutput{}, nil
+	}
+
+	authority.Version = args.Identity.Version
+	authority.Name = args.Input.Name
+
+	err = m.repository.updateAuthority(authority)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &authorityOutput{&authorityResolver{m.repository, authority}}, nil
+}
+
+// CreateClient
+
+func (m *rootMutation) CreateClient(ctx context.Context, args struct {
+	Input clientInput
+}) (*clientOutput, error) {
+	if err := authorizeField(ctx, "Mutation.createClient", ""); err != nil {
+		return nil, err
+	}
+
+	client := &client{
+		Name:         args.Input.Name,
+		RedirectURIs: args.Input.RedirectURIs,
+	}
+
+	var secret string
+
+	if args.Input.Confidential {
+		var err error
+
+		secret, client.SecretHash, err = security.GenerateClientSecret()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := m.repository.saveClient(client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output := &clientOutput{client: &clientResolver{m.repository, client}}
+
+	if secret != "" {
+		output.clientSecret = &secret
+	}
+
+	return output, nil
+}
+
+type clientInput struct {
+	Name         string
+	RedirectURIs []string
+	Confidential bool
+}
+
+type clientOutput struct {
+	client       *clientResolver
+	clientSecret *string
+}
+
+func (o *clientOutput) Client() *clientResolver {
+	return o.client
+}
+
+// ClientSecret is only ever returned when the client is created.
+func (o *clientOutput) ClientSecret() *string {
+	return o.clientSecret
+}
+
+// CreateServiceAccount
+
+func (m *rootMutation) CreateServiceAccount(ctx context.Context, args struct {
+	Input serviceAccountInput
+}) (*clientOutput, error) {
+	if err := authorizeField(ctx, "Mutation.createServiceAccount", ""); err != nil {
+		return nil, err
+	}
+
+	user := &user{
+		FirstName: args.Input.Name,
+	}
+	client := &client{
+		Name:         args.Input.Name,
+		RedirectURIs: []string{},
+	}
+
+	var secret string
+
+	if args.Input.PublicKey != nil {
+		err := security.ValidatePublicKey(*args.Input.PublicKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		client.PublicKey = *args.Input.PublicKey
+	} else {
+		var err error
+
+		secret, client.SecretHash, err = security.GenerateClientSecret()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var roleIDs []string
+
+	for _, roleID := range args.Input.RoleIDs {
+		roleIDs = append(roleIDs, string(roleID))
+	}
+
+	err := m.repository.saveServiceAccount(ctx, user, client, roleIDs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output := &clientOutput{client: &clientResolver{m.repository, client}}
+
+	if secret != "" {
+		output.clientSecret = &secret
+	}
+
+	return output, nil
+}
+
+type serviceAccountInput struct {
+	Name      string
+	RoleIDs   []graphql.ID
+	PublicKey *string
+}
+
+// SetRoleMfaRequired
+
+func (m *rootMutation) SetRoleMfaRequired(ctx context.Context, args struct {
+	ID       graphql.ID
+	Required bool
+}) (*roleOutput, error) {
+	if err := authorizeField(ctx, "Mutation.setRoleMfaRequired", ""); err != nil {
+		return nil, err
+	}
+
+	role, err := m.repository.updateRoleMFARequired(string(args.ID), args.Required)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return &roleOutput{}, nil
+	}
+
+	return &roleOutput{&roleResolver{m.repository, role}}, nil
+}
+
+// SetRoleRegistrationDefault
+
+func (m *rootMutation) SetRoleRegistrationDefault(ctx context.Context, args struct {
+	ID                  graphql.ID
+	RegistrationDefault bool
+}) (*roleOutput, error) {
+	if err := authorizeField(ctx, "Mutation.setRoleRegistrationDefault", ""); err != nil {
+		return nil, err
+	}
+
+	role, err := m.repository.updateRoleRegistrationDefault(string(args.ID), args.RegistrationDefault)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return &roleOutput{}, nil
+	}
+
+	return &roleOutput{&roleResolver{m.repository, role}}, nil
+}
+
+// EnrollTotp
+
+func (m *rootMutation) EnrollTotp(ctx context.Context) (*totpEnrollmentResolver, error) {
+	if err := authorizeField(ctx, "Mutation.enrollTotp", ""); err != nil {
+		return nil, err
+	}
+
+	enrollment, err := m.mfa.EnrollTOTP(security.UserIDFromContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totpEnrollmentResolver{enrollment}, nil
+}
+
+type totpEnrollmentResolver struct {
+	enrollment *security.TOTPEnrollment
+}
+
+func (r *totpEnrollmentResolver) Secret() string {
+	return r.enrollment.Secret
+}
+
+func (r *totpEnrollmentResolver) ProvisioningURI() string {
+	return r.enrollment.ProvisioningURI
+}
+
+// ConfirmTotp
+
+func (m *rootMutation) ConfirmTotp(ctx context.Context, args struct {
+	Code string
+}) (*[]string, error) {
+	if err := authorizeField(ctx, "Mutation.confirmTotp", ""); err != nil {
+		return nil, err
+	}
+
+	codes, err := m.mfa.ConfirmTOTP(ctx, security.UserIDFromContext(ctx), args.Code)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &codes, nil
+}
+
+// RegenerateRecoveryCodes
+
+func (m *rootMutation) RegenerateRecoveryCodes(ctx context.Context) (*[]string, error) {
+	if err := authorizeField(ctx, "Mutation.regenerateRecoveryCodes", ""); err != nil {
+		return nil, err
+	}
+
+	codes, err := m.mfa.RegenerateRecoveryCodes(security.UserIDFromContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &codes, nil
+}
+
+// ResetMfa
+
+func (m *rootMutation) ResetMfa(ctx context.Context, args struct {
+	UserID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.resetMfa", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.mfa.ResetMFA(string(args.UserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RemoveCredential
+
+func (m *rootMutation) RemoveCredential(ctx context.Context, args struct {
+	ID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.removeCredential", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.mfa.RemoveWebAuthnCredential(ctx, security.UserIDFromContext(ctx), string(args.ID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RevokeToken
+
+func (m *rootMutation) RevokeToken(ctx context.Context, args struct {
+	ID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.revokeToken", ""); err != nil {
+		return nil, err
+	}
+
+	if !isUUID(string(args.ID)) {
+		return boolRef(false), nil
+	}
+
+	err := m.tokens.RevokeToken(string(args.ID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RevokeUserTokens
+
+func (m *rootMutation) RevokeUserTokens(ctx context.Context, args struct {
+	UserID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.revokeUserTokens", string(args.UserID)); err != nil {
+		return nil, err
+	}
+
+	if !isUUID(string(args.UserID)) {
+		return boolRef(false), nil
+	}
+
+	err := m.tokens.RevokeUserTokens(string(args.UserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RevokeSession
+
+func (m *rootMutation) RevokeSession(ctx context.Context, args struct {
+	ID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.revokeSession", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.tokens.RevokeSession(security.UserIDFromContext(ctx), string(args.ID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RevokeAllSessions
+
+func (m *rootMutation) RevokeAllSessions(ctx context.Context) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.revokeAllSessions", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.tokens.RevokeAllSessions(security.UserIDFromContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// RefreshBreachedPasswords
+
+func (m *rootMutation) RefreshBreachedPasswords(ctx context.Context) (*int32, error) {
+	if err := authorizeField(ctx, "Mutation.refreshBreachedPasswords", ""); err != nil {
+		return nil, err
+	}
+
+	n, err := m.passwords.RefreshBreachedPasswords("")
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := int32(n)
+
+	return &refreshed, nil
+}
+
+// UnlockUser
+
+func (m *rootMutation) UnlockUser(ctx context.Context, args struct {
+	UserID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.unlockUser", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.lockout.UnlockUser(string(args.UserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// SendEmailVerification
+
+func (m *rootMutation) SendEmailVerification(ctx context.Context, args struct {
+	UserID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.sendEmailVerification", string(args.UserID)); err != nil {
+		return nil, err
+	}
+
+	err := m.emails.SendEmailVerification(string(args.UserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// ChangeEmail
+
+func (m *rootMutation) ChangeEmail(ctx context.Context, args struct {
+	Email string
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.changeEmail", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.emails.ChangeEmail(security.UserIDFromContext(ctx), args.Email)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// InviteUser
+
+func (m *rootMutation) InviteUser(ctx context.Context, args struct {
+	Email string
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.inviteUser", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.invites.InviteUser(security.ActorIDFromContext(ctx), args.Email)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+// ApproveUser
+
+func (m *rootMutation) ApproveUser(ctx context.Context, args struct {
+	UserID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.approveUser", ""); err != nil {
+		return nil, err
+	}
+
+	approved, err := m.repository.approveUser(ctx, string(args.UserID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &approved, nil
+}
+
+// CreatePersonalAccessToken
+
+func (m *rootMutation) CreatePersonalAccessToken(ctx context.Context, args struct {
+	Input personalAccessTokenInput
+}) (*personalAccessTokenOutput, error) {
+	if err := authorizeField(ctx, "Mutation.createPersonalAccessToken", ""); err != nil {
+		return nil, err
+	}
+
+	// Otherwise a leaked token could be turned into tokens that outlive it.
+	if security.PersonalAccessTokenFromContext(ctx) {
+		return nil, errPersonalAccessTokenIssuedWithToken
+	}
+
+	var expiresAt *time.Time
+
+	if args.Input.ExpiresAt != nil {
+		parsed, err := time.Parse(time.RFC3339, *args.Input.ExpiresAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expiresAt = &parsed
+	}
+
+	token, created, err := m.pats.CreatePersonalAccessToken(ctx, security.UserIDFromContext(ctx), args.Input.Name, args.Input.Scopes, expiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &personalAccessTokenOutput{token, &personalAccessTokenResolver{newPersonalAccessToken(created)}}, nil
+}
+
+type personalAccessTokenInput struct {
+	Name      string
+	Scopes    []string
+	ExpiresAt *string
+}
+
+type personalAccessTokenOutput struct {
+	token               string
+	personalAccessToken *personalAccessTokenResolver
+}
+
+func (o *personalAccessTokenOutput) Token() string {
+	return o.token
+}
+
+func (o *personalAccessTokenOutput) PersonalAccessToken() *personalAccessTokenResolver {
+	return o.personalAccessToken
+}
+
+// RevokePersonalAccessToken
+
+func (m *rootMutation) RevokePersonalAccessToken(ctx context.Context, args struct {
+	ID graphql.ID
+}) (*bool, error) {
+	if err := authorizeField(ctx, "Mutation.revokePersonalAccessToken", ""); err != nil {
+		return nil, err
+	}
+
+	err := m.pats.RevokePersonalAccessToken(security.UserIDFromContext(ctx), string(args.ID))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return boolRef(true), nil
+}
+
+func boolRef(b bool) *bool {
+	return &b
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	adminID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	userID  = "0c2a4ab6-5804-11e8-8879-afa0dd22785d"
+)
+
+// memoryRepository keeps the users, roles and authorities that the mutations change, and
+// checks their versions as the database does. The rest of the repository is left out.
+type memoryRepository struct {
+	repository
+	users       map[string]*user
+	passwords   map[string]string
+	roles       map[string]*role
+	authorities map[string]*authority
+}
+
+func newMemoryRepository(t *testing.T) *memoryRepository {
+	hashedPassword, err := security.GenerateHashedPassword("secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &memoryRepository{
+		users: map[string]*user{
+			adminID: {ID: adminID, Version: 1, Email: "erik@eies.land", Password: hashedPassword, Enabled: true, EmailVerified: true},
+			userID:  {ID: userID, Version: 1, FirstName: "Ola", LastName: "Nordmann", Email: "ola@eies.land", Password: hashedPassword, Enabled: true, EmailVerified: true},
+		},
+		passwords:   map[string]string{},
+		roles:       map[string]*role{},
+		authorities: map[string]*authority{},
+	}
+}
+
+func (m *memoryRepository) FindSubjectByEmail(email string) (security.Subject, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *memoryRepository) FindSubjectByID(id string) (security.Subject, error) {
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+
+	return nil, nil
+}
+
+func (m *memoryRepository) FindUserAuthorities(userID string) (*security.Authorities, error) {
+	if userID != adminID {
+		return &security.Authorities{}, nil
+	}
+
+	return &security.Authorities{Authorities: []string{"WRITE_USERS", "WRITE_ROLES"}}, nil
+}
+
+func (m *memoryRepository) findUserByID(id string) (*user, error) {
+	if u, ok := m.users[id]; ok {
+		copied := *u
+		return &copied, nil
+	}
+
+	return nil, nil
+}
+
+func (m *memoryRepository) updateUser(ctx context.Context, u *user, password string) error {
+	if m.users[u.ID].Version != u.Version {
+		return errors.New("authgo: no update performed")
+	}
+
+	u.Version++
+	copied := *u
+	m.users[u.ID] = &copied
+	m.passwords[u.ID] = password
+
+	return nil
+}
+
+func (m *memoryRepository) saveRole(r *role) error {
+	r.ID = uuid.Must(uuid.NewV4()).String()
+	copied := *r
+	m.roles[r.ID] = &copied
+
+	return nil
+}
+
+func (m *memoryRepository) findRoleByID(id string) (*role, error) {
+	if r, ok := m.roles[id]; ok {
+		copied := *r
+		return &copied, nil
+	}
+
+	return nil, nil
+}
+
+func (m *memoryRepository) updateRole(r *role) error {
+	if m.roles[r.ID].Version != r.Version {
+		return errors.New("authgo: no update performed")
+	}
+
+	r.Version++
+	copied := *r
+	m.roles[r.ID] = &copied
+
+	return nil
+}
+
+func (m *memoryRepository) saveAuthority(a *authority) error {
+	a.ID = uuid.Must(uuid.NewV4())
+	copied := *a
+	m.authorities[a.ID.String()] = &copied
+
+	return nil
+}
+
+func (m *memoryRepository) findAuthorityByID(id string) (*authority, error) {
+	if a, ok := m.authorities[id]; ok {
+		copied := *a
+		return &copied, nil
+	}
+
+	return nil, nil
+}
+
+func (m *memoryRepository) updateAuthority(a *authority) error {
+	if m.authorities[a.ID.String()].Version != a.Version {
+		return errors.New("authgo: no update performed")
+	}
+
+	a.Version++
+	copied := *a
+	m.authorities[a.ID.String()] = &copied
+
+	return nil
+}
+
+// newTestMutation returns the mutation, with the context of a request by the administrator,
+// who just signed in.
+func newTestMutation(t *testing.T) (*rootMutation, *memoryRepository, context.Context) {
+	var err error
+
+	schemaPermissions, err = parsePermissions(readSchema())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := newMemoryRepository(t)
+	s := security.New(repository, security.WithAuthorityFinder(repository))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = s.Authenticate(w, r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ctx context.Context
+
+	r = httptest.NewRequest(http.MethodPost, "/graphql", nil)
+
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	s.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	if ctx == nil {
+		t.Fatal("the administrator was not authorized")
+	}
+
+	return &rootMutation{repository: repository, tokens: s, passwords: s}, repository, ctx
+}
+
+func TestUpdateUser(t *testing.T) {
+	m, repository, ctx := newTestMutation(t)
+
+	type args = struct {
+		Identity identity
+		Input    userInput
+	}
+
+	input := userInput{FirstName: "Kari", LastName: "Nordmann", Email: "ola@eies.land", Enabled: true}
+	output, err := m.UpdateUser(ctx, args{identity{userID, 1}, input})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.User().FirstName() != "Kari" || output.User().Version() != 2 {
+		t.Fatalf("unexpected user: %+v", output.User().user)
+	}
+
+	if password, ok := repository.passwords[userID]; !ok || password != "" {
+		t.Fatalf("expected the password to be kept, got %q", password)
+	}
+
+	if _, err := m.UpdateUser(ctx, args{identity{userID, 1}, input}); err == nil {
+		t.Fatal("expected an error for a stale version")
+	}
+
+	input.Password = "secret"
+
+	if _, err := m.UpdateUser(ctx, args{identity{userID, 2}, input}); err == nil {
+		t.Fatal("expected a weak password to be refused")
+	}
+
+	input.Password = "correct horse battery staple"
+
+	if _, err := m.UpdateUser(ctx, args{identity{userID, 2}, input}); err != nil {
+		t.Fatal(err)
+	}
+
+	if repository.passwords[userID] != input.Password {
+		t.Fatal("expected the new password to be stored")
+	}
+
+	input.Email = "kari@eies.land"
+
+	if _, err := m.UpdateUser(ctx, args{identity{userID, 3}, input}); errors.Cause(err) != errEmailChangedWithUpdate {
+		t.Fatalf("expected the email change to be refused, got %v", err)
+	}
+
+	if output, err := m.UpdateUser(ctx, args{identity{"unknown", 1}, input}); err != nil || output.User() != nil {
+		t.Fatalf("expected no user for an unknown id, got %v", err)
+	}
+}
+
+func TestCreateAndUpdateRole(t *testing.T) {
+	m, _, ctx := newTestMutation(t)
+
+	created, err := m.CreateRole(ctx, struct{ Input roleInput }{roleInput{"SUPPORT"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args = struct {
+		Identity identity
+		Input    roleInput
+	}
+
+	updated, err := m.UpdateRole(ctx, args{identity{created.Role().role.ID, 0}, roleInput{"HELPDESK"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Role().Name() != "HELPDESK" || updated.Role().Version() != 1 {
+		t.Fatalf("unexpected role: %+v", updated.Role().role)
+	}
+
+	if _, err := m.UpdateRole(ctx, args{identity{created.Role().role.ID, 0}, roleInput{"SUPPORT"}}); err == nil {
+		t.Fatal("expected an error for a stale version")
+	}
+}
+
+func TestCreateAndUpdateAuthority(t *testing.T) {
+	m, _, ctx := newTestMutation(t)
+
+	created, err := m.CreateAuthority(ctx, struct{ Input authorityInput }{authorityInput{"READ_REPORTS"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args = struct {
+		Identity identity
+		Input    authorityInput
+	}
+
+	id := created.Authority().authority.ID.String()
+	updated, err := m.UpdateAuthority(ctx, args{identity{id, 0}, authorityInput{"READ_STATISTICS"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Authority().Name() != "READ_STATISTICS" || updated.Authority().Version() != 1 {
+		t.Fatalf("unexpected authority: %+v", updated.Authority().authority)
+	}
+
+	if _, err := m.UpdateAuthority(ctx, args{identity{id, 0}, authorityInput{"READ_REPORTS"}}); err == nil {
+		t.Fatal("expected an error for a stale version")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type passwordResetToken struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+func (db *db) SavePasswordResetToken(token *security.PasswordResetToken) error {
+	_, err := db.NamedExec(sqlSavePasswordResetToken, passwordResetToken(*token))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindPasswordResetToken(tokenHash string) (*security.PasswordResetToken, error) {
+	t := passwordResetToken{}
+
+	err := db.Get(&t, sqlFindPasswordResetToken, tokenHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	token := security.PasswordResetToken(t)
+
+	return &token, nil
+}
+
+func (db *db) UsePasswordResetToken(id string, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUsePasswordResetToken, id, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (db *db) DeleteUserPasswordResetTokens(userID string) error {
+	_, err := db.Exec(sqlDeleteUserPasswordResetTokens, userID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlSavePasswordResetToken = `
+		insert into "authgo"."password_reset_token" (
+			"id",
+			"user_id",
+			"token_hash",
+			"created_at",
+			"expires_at"
+		) values (
+			:id,
+			:user_id,
+			:token_hash,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlFindPasswordResetToken = `
+		select
+			"password_reset_token"."id",
+			"password_reset_token"."user_id",
+			"password_reset_token"."token_hash",
+			"password_reset_token"."created_at",
+			"password_reset_token"."expires_at",
+			"password_reset_token"."used_at"
+		from "authgo"."password_reset_token"
+		where "password_reset_token"."token_hash" = $1;
+	`
+	sqlUsePasswordResetToken = `
+		update "authgo"."password_reset_token" set
+			"used_at" = $2
+		where "password_reset_token"."id" = $1
+			and "password_reset_token"."used_at" is null;
+	`
+	sqlDeleteUserPasswordResetTokens = `
+		delete from "authgo"."password_reset_token"
+		where "password_reset_token"."user_id" = $1;
+	`
+)
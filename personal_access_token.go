@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// INTERFACES
+
+type personalAccessTokensByUserIDFinder interface {
+	findPersonalAccessTokensByUserID(userID string) ([]*personalAccessToken, error)
+}
+
+type personalAccessTokenRepository interface {
+	personalAccessTokensByUserIDFinder
+}
+
+// STRUCTS
+
+type personalAccessToken struct {
+	ID         string         `db:"id"`
+	UserID     string         `db:"user_id"`
+	Name       string         `db:"name"`
+	TokenHash  string         `db:"token_hash"`
+	Scopes     pq.StringArray `db:"scopes"`
+	CreatedAt  time.Time      `db:"created_at"`
+	ExpiresAt  time.Time      `db:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	RevokedAt  *time.Time     `db:"revoked_at"`
+}
+
+func (t *personalAccessToken) personalAccessToken() *security.PersonalAccessToken {
+	return &security.PersonalAccessToken{
+		ID:         t.ID,
+		UserID:     t.UserID,
+		Name:       t.Name,
+		TokenHash:  t.TokenHash,
+		Scopes:     t.Scopes,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		RevokedAt:  t.RevokedAt,
+	}
+}
+
+func newPersonalAccessToken(token *security.PersonalAccessToken) *personalAccessToken {
+	t := &personalAccessToken{
+		ID:         token.ID,
+		UserID:     token.UserID,
+		Name:       token.Name,
+		TokenHash:  token.TokenHash,
+		Scopes:     pq.StringArray(token.Scopes),
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		RevokedAt:  token.RevokedAt,
+	}
+
+	if t.Scopes == nil {
+		t.Scopes = pq.StringArray{}
+	}
+
+	return t
+}
+
+func (db *db) findPersonalAccessTokensByUserID(userID string) ([]*personalAccessToken, error) {
+	tokens := []*personalAccessToken{}
+
+	err := db.Select(&tokens, sqlFindPersonalAccessTokensByUserID, userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return tokens, nil
+}
+
+func (db *db) SavePersonalAccessToken(token *security.PersonalAccessToken) error {
+	t := newPersonalAccessToken(token)
+
+	_, err := db.NamedExec(sqlSavePersonalAccessToken, t)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindPersonalAccessToken(tokenHash string) (*security.PersonalAccessToken, error) {
+	t := &personalAccessToken{}
+
+	err := db.Get(t, sqlFindPersonalAccessTokenByTokenHash, tokenHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return t.personalAccessToken(), nil
+}
+
+func (db *db) FindUserPersonalAccessTokens(userID string) ([]*security.PersonalAccessToken, error) {
+	tokens, err := db.findPersonalAccessTokensByUserID(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := []*security.PersonalAccessToken{}
+
+	for _, t := range tokens {
+		result = append(result, t.personalAccessToken())
+	}
+
+	return result, nil
+}
+
+func (db *db) TouchPersonalAccessToken(id string, lastUsedAt time.Time) error {
+	_, err := db.Exec(sqlTouchPersonalAccessToken, id, lastUsedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) RevokePersonalAccessToken(userID, id string, revokedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlRevokePersonalAccessToken, userID, id, revokedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+const (
+	sqlSavePersonalAccessToken = `
+		insert into "authgo"."personal_access_token" (
+			"id",
+			"user_id",
+			"name",
+			"token_hash",
+			"scopes",
+			"created_at",
+			"expires_at"
+		) values (
+			:id,
+			:user_id,
+			:name,
+			:token_hash,
+			:scopes,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlFindPersonalAccessTokenByTokenHash = `
+		select
+			"personal_access_token"."id",
+			"personal_access_token"."user_id",
+			"personal_access_token"."name",
+			"personal_access_token"."token_hash",
+			"personal_access_token"."scopes",
+			"personal_access_token"."created_at",
+			"personal_access_token"."expires_at",
+			"personal_access_token"."last_used_at",
+			"personal_access_token"."revoked_at"
+		from "authgo"."personal_access_token"
+		where "personal_access_token"."token_hash" = $1;
+	`
+	sqlFindPersonalAccessTokensByUserID = `
+		select
+			"personal_access_token"."id",
+			"personal_access_token"."user_id",
+			"personal_access_token"."name",
+			"personal_access_token"."token_hash",
+			"personal_access_token"."scopes",
+			"personal_access_token"."created_at",
+			"personal_access_token"."expires_at",
+			"personal_access_token"."last_used_at",
+			"personal_access_token"."revoked_at"
+		from "authgo"."personal_access_token"
+		where "personal_access_token"."user_id" = $1
+			and "personal_access_token"."revoked_at" is null
+			and "personal_access_token"."expires_at" > now()
+		order by "personal_access_token"."created_at" desc;
+	`
+	sqlTouchPersonalAccessToken = `
+		update "authgo"."personal_access_token" set
+			"last_used_at" = $2
+		where "personal_access_token"."id" = $1;
+	`
+	sqlRevokePersonalAccessToken = `
+		update "authgo"."personal_access_token" set
+			"revoked_at" = $3
+		where "personal_access_token"."user_id" = $1
+			and "personal_access_token"."id" = $2
+			and "personal_access_token"."revoked_at" is null;
+	`
+)
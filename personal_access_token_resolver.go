@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+type personalAccessTokenResolver struct {
+	token *personalAccessToken
+}
+
+func (r *personalAccessTokenResolver) ID() graphql.ID {
+	return graphQLID(r.token.ID)
+}
+
+func (r *personalAccessTokenResolver) Name() string {
+	return r.token.Name
+}
+
+func (r *personalAccessTokenResolver) Scopes() []string {
+	return r.token.Scopes
+}
+
+func (r *personalAccessTokenResolver) CreatedAt() string {
+	return r.token.CreatedAt.Format(time.RFC3339)
+}
+
+func (r *personalAccessTokenResolver) ExpiresAt() string {
+	return r.token.ExpiresAt.Format(time.RFC3339)
+}
+
+func (r *personalAccessTokenResolver) LastUsedAt() *string {
+	if r.token.LastUsedAt == nil {
+		return nil
+	}
+
+	lastUsedAt := r.token.LastUsedAt.Format(time.RFC3339)
+
+	return &lastUsedAt
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/graph-gophers/graphql-go"
 )
 
@@ -8,7 +10,11 @@ type rootQuery struct {
 	repository repository
 }
 
-func (r *rootQuery) Users() ([]*userResolver, error) {
+func (r *rootQuery) Users(ctx context.Context) (*[]*userResolver, error) {
+	if err := authorizeField(ctx, "Query.users", ""); err != nil {
+		return nil, err
+	}
+
 	users, err := r.repository.findAllUsers()
 
 	if err != nil {
@@ -21,10 +27,10 @@ func (r *rootQuery) Users() ([]*userResolver, error) {
 		resolvers = append(resolvers, &userResolver{r.repository, user})
 	}
 
-	return resolvers, nil
+	return &resolvers, nil
 }
 
-func (r *rootQuery) User(args struct {
+func (r *rootQuery) User(ctx context.Context, args struct {
 	ID    *graphql.ID
 	Email *string
 }) (*userResolver, error) {
@@ -44,21 +50,40 @@ func (r *rootQuery) User(args struct {
 	}
 
 	if user == nil {
-		return nil, nil
+		// Only those who may read users learn that one does not exist.
+		return nil, authorizeField(ctx, "Query.user", "")
+	}
+
+	err = authorizeField(ctx, "Query.user", user.ID)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return &userResolver{r.repository, user}, nil
 }
 
-func (r *rootQuery) Events(args struct {
+func (r *rootQuery) Events(ctx context.Context, args struct {
 	UserID *graphql.ID
-}) ([]*eventResolver, error) {
+}) (*[]*eventResolver, error) {
 	var events []*event
 	var err error
 
 	if args.UserID != nil {
+		err = authorizeField(ctx, "Query.events", string(*args.UserID))
+
+		if err != nil {
+			return nil, err
+		}
+
 		events, err = r.repository.findEventsByUserID(string(*args.UserID))
 	} else {
+		err = authorizeField(ctx, "Query.events", "")
+
+		if err != nil {
+			return nil, err
+		}
+
 		events, err = r.repository.findAllEvents()
 	}
 
@@ -72,12 +97,16 @@ func (r *rootQuery) Events(args struct {
 		resolvers = append(resolvers, &eventResolver{r.repository, event})
 	}
 
-	return resolvers, nil
+	return &resolvers, nil
 }
 
-func (r *rootQuery) Event(args struct {
+func (r *rootQuery) Event(ctx context.Context, args struct {
 	ID graphql.ID
 }) (*eventResolver, error) {
+	if err := authorizeField(ctx, "Query.event", ""); err != nil {
+		return nil, err
+	}
+
 	event, err := r.repository.findEventByID(string(args.ID))
 
 	if err != nil {
@@ -90,3 +119,23 @@ func (r *rootQuery) Event(args struct {
 
 	return &eventResolver{r.repository, event}, nil
 }
+
+func (r *rootQuery) Clients(ctx context.Context) (*[]*clientResolver, error) {
+	if err := authorizeField(ctx, "Query.clients", ""); err != nil {
+		return nil, err
+	}
+
+	clients, err := r.repository.findAllClients()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*clientResolver
+
+	for _, client := range clients {
+		resolvers = append(resolvers, &clientResolver{r.repository, client})
+	}
+
+	return &resolvers, nil
+}
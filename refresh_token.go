@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type refreshToken struct {
+	ID        string         `db:"id"`
+	FamilyID  string         `db:"family_id"`
+	UserID    string         `db:"user_id"`
+	ClientID  string         `db:"client_id"`
+	Scope     string         `db:"scope"`
+	AuthTime  time.Time      `db:"auth_time"`
+	AMR       pq.StringArray `db:"amr"`
+	TokenHash string         `db:"token_hash"`
+	CreatedAt time.Time      `db:"created_at"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	UsedAt    *time.Time     `db:"used_at"`
+	RevokedAt *time.Time     `db:"revoked_at"`
+}
+
+func (t *refreshToken) refreshToken() *security.RefreshToken {
+	return &security.RefreshToken{
+		ID:        t.ID,
+		FamilyID:  t.FamilyID,
+		UserID:    t.UserID,
+		ClientID:  t.ClientID,
+		Scope:     t.Scope,
+		AuthTime:  t.AuthTime,
+		AMR:       t.AMR,
+		TokenHash: t.TokenHash,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+		UsedAt:    t.UsedAt,
+		RevokedAt: t.RevokedAt,
+	}
+}
+
+func newRefreshToken(token *security.RefreshToken) *refreshToken {
+	t := &refreshToken{
+		ID:        token.ID,
+		FamilyID:  token.FamilyID,
+		UserID:    token.UserID,
+		ClientID:  token.ClientID,
+		Scope:     token.Scope,
+		AuthTime:  token.AuthTime,
+		AMR:       pq.StringArray(token.AMR),
+		TokenHash: token.TokenHash,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		UsedAt:    token.UsedAt,
+		RevokedAt: token.RevokedAt,
+	}
+
+	if t.AMR == nil {
+		t.AMR = pq.StringArray{}
+	}
+
+	return t
+}
+
+func (db *db) SaveRefreshToken(token *security.RefreshToken) error {
+	_, err := db.NamedExec(sqlSaveRefreshToken, newRefreshToken(token))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindRefreshToken(tokenHash string) (*security.RefreshToken, error) {
+	t := refreshToken{}
+
+	err := db.Get(&t, sqlFindRefreshToken, tokenHash)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return t.refreshToken(), nil
+}
+
+func (db *db) UseRefreshToken(id string, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseRefreshToken, id, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (db *db) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	_, err := db.Exec(sqlRevokeRefreshTokenFamily, familyID, revokedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) RevokeUserRefreshTokens(userID string, revokedAt time.Time) error {
+	_, err := db.Exec(sqlRevokeUserRefreshTokens, userID, revokedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlSaveRefreshToken = `
+		insert into "authgo"."refresh_token" (
+			"id",
+			"family_id",
+			"user_id",
+			"client_id",
+			"scope",
+			"auth_time",
+			"amr",
+			"token_hash",
+			"created_at",
+			"expires_at"
+		) values (
+			:id,
+			:family_id,
+			:user_id,
+			cast(nullif(:client_id, '') as uuid),
+			:scope,
+			:auth_time,
+			:amr,
+			:token_hash,
+			:created_at,
+			:expires_at
+		);
+	`
+	sqlFindRefreshToken = `
+		select
+			"refresh_token"."id",
+			"refresh_token"."family_id",
+			"refresh_token"."user_id",
+			coalesce("refresh_token"."client_id"::text, '') as "client_id",
+			"refresh_token"."scope",
+			"refresh_token"."auth_time",
+			"refresh_token"."amr",
+			"refresh_token"."token_hash",
+			"refresh_token"."created_at",
+			"refresh_token"."expires_at",
+			"refresh_token"."used_at",
+			"refresh_token"."revoked_at"
+		from "authgo"."refresh_token"
+		where "refresh_token"."token_hash" = $1;
+	`
+	sqlUseRefreshToken = `
+		update "authgo"."refresh_token" set
+			"used_at" = $2
+		where "refresh_token"."id" = $1
+			and "refresh_token"."used_at" is null
+			and "refresh_token"."revoked_at" is null;
+	`
+	sqlRevokeRefreshTokenFamily = `
+		update "authgo"."refresh_token" set
+			"revoked_at" = $2
+		where "refresh_token"."family_id" = $1
+			and "refresh_token"."revoked_at" is null;
+	`
+	sqlRevokeUserRefreshTokens = `
+		update "authgo"."refresh_token" set
+			"revoked_at" = $2
+		where "refresh_token"."user_id" = $1
+			and "refresh_token"."revoked_at" is null;
+	`
+)
@@ -5,6 +5,11 @@ type repository interface {
 	roleRepository
 	authorityRepository
 	eventRepository
+	clientRepository
+	serviceAccountRepository
+	webAuthnCredentialRepository
+	sessionRepository
+	personalAccessTokenRepository
 }
 
 type saver interface {
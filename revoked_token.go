@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (db *db) RevokeToken(tokenID string, expiresAt time.Time) error {
+	_, err := db.Exec(sqlRevokeToken, tokenID, expiresAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) IsTokenRevoked(tokenID string) (bool, error) {
+	var revoked bool
+
+	err := db.Get(&revoked, sqlIsTokenRevoked, tokenID)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return revoked, nil
+}
+
+func (db *db) RevokeUserTokens(userID string, revokedAt time.Time) error {
+	_, err := db.Exec(sqlRevokeUserTokens, userID, revokedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindUserTokensRevokedAt(userID string) (*time.Time, error) {
+	var revokedAt *time.Time
+
+	err := db.Get(&revokedAt, sqlFindUserTokensRevokedAt, userID)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return revokedAt, nil
+}
+
+const (
+	sqlRevokeToken = `
+		insert into "authgo"."revoked_token" ("token_id", "expires_at")
+		values ($1, $2)
+		on conflict ("token_id") do nothing;
+	`
+	sqlIsTokenRevoked = `
+		select exists (
+			select 1
+			from "authgo"."revoked_token"
+			where "revoked_token"."token_id" = $1
+		);
+	`
+	sqlRevokeUserTokens = `
+		update "authgo"."user" set
+			"tokens_revoked_at" = $2
+		where "user"."id" = $1;
+	`
+	sqlFindUserTokensRevokedAt = `
+		select
+			"user"."tokens_revoked_at"
+		from "authgo"."user"
+		where "user"."id" = $1;
+	`
+)
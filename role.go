@@ -10,22 +10,74 @@ type userRolesFinder interface {
 	findUserRoles(userID string) ([]*role, error)
 }
 
+type roleMFARequiredUpdater interface {
+	updateRoleMFARequired(id string, required bool) (*role, error)
+}
+
+type roleRegistrationDefaultUpdater interface {
+	updateRoleRegistrationDefault(id string, registrationDefault bool) (*role, error)
+}
+
+type roleByIDFinder interface {
+	findRoleByID(id string) (*role, error)
+}
+
+type roleSaver interface {
+	saveRole(role *role) error
+}
+
+type roleUpdater interface {
+	updateRole(role *role) error
+}
+
 type roleRepository interface {
 	userRolesFinder
+	roleByIDFinder
+	roleSaver
+	roleUpdater
+	roleMFARequiredUpdater
+	roleRegistrationDefaultUpdater
 }
 
 type role struct {
-	ID      string `db:"id" json:"id,omitempty"`
-	Version int    `db:"version" json:"version,omitempty"`
-	Name    string `db:"name" json:"name,omitempty"`
-	Events  events `db:"events" json:"events,omitempty"`
+	ID          string `db:"id" json:"id,omitempty"`
+	Version     int    `db:"version" json:"version,omitempty"`
+	Name        string `db:"name" json:"name,omitempty"`
+	Events      events `db:"events" json:"events,omitempty"`
+	MFARequired bool   `db:"mfa_required" json:"mfaRequired,omitempty"`
+	// RegistrationDefault roles are given to the users that sign up themselves.
+	RegistrationDefault bool `db:"registration_default" json:"registrationDefault,omitempty"`
 }
 
 func (r *role) save(tx *tx) error {
+	id, err := tx.save(r, sqlSaveRole)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r.ID = id
+
 	return nil
 }
 
 func (r *role) update(tx *tx) error {
+	result, err := tx.Exec(sqlUpdateRole, r.ID, r.Version, r.Name)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if rowsAffected != 1 {
+		return errors.New("authgo: no update performed")
+	}
+
 	return nil
 }
 
@@ -89,12 +141,87 @@ func (db *db) findUserRoles(userID string) ([]*role, error) {
 	return roles, nil
 }
 
+func (db *db) saveRole(role *role) error {
+	return db.commit(role.save)
+}
+
+// updateRole renames the role, as long as nobody changed it since the version it has.
+func (db *db) updateRole(role *role) error {
+	err := db.commit(role.update)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	role.Version++
+
+	return nil
+}
+
+// updateRoleMFARequired requires, or stops requiring, a second factor from the users with
+// the role.
+func (db *db) updateRoleMFARequired(id string, required bool) (*role, error) {
+	if !isUUID(id) {
+		return nil, nil
+	}
+
+	_, err := db.Exec(sqlUpdateRoleMFARequired, id, required)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "authgo: error when updating role")
+	}
+
+	return db.findRoleByID(id)
+}
+
+// updateRoleRegistrationDefault gives, or stops giving, the role to the users that sign up
+// themselves.
+func (db *db) updateRoleRegistrationDefault(id string, registrationDefault bool) (*role, error) {
+	if !isUUID(id) {
+		return nil, nil
+	}
+
+	_, err := db.Exec(sqlUpdateRoleRegistrationDefault, id, registrationDefault)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "authgo: error when updating role")
+	}
+
+	return db.findRoleByID(id)
+}
+
 const (
+	sqlSaveRole = `
+		insert into "authgo"."role" (
+			"name"
+		) values (
+			:name
+		) returning "role"."id";
+	`
+	sqlUpdateRole = `
+		update "authgo"."role" set
+			"version" = "role"."version" + 1,
+			"name" = $3
+		where "role"."id" = $1
+			and "role"."version" = $2;
+	`
+	sqlUpdateRoleRegistrationDefault = `
+		update "authgo"."role" set
+			"registration_default" = $2
+		where "role"."id" = $1;
+	`
+	sqlUpdateRoleMFARequired = `
+		update "authgo"."role" set
+			"mfa_required" = $2
+		where "role"."id" = $1;
+	`
 	sqlFindRoleByID = `
 		select
 			"role"."id",
 			"role"."version",
-			"role"."name"
+			"role"."name",
+			"role"."mfa_required",
+			"role"."registration_default"
 		from "authgo"."role"
 		where "role"."id" = $1;
 	`
@@ -102,7 +229,9 @@ const (
 		select
 			"role"."id",
 			"role"."version",
-			"role"."name"
+			"role"."name",
+			"role"."mfa_required",
+			"role"."registration_default"
 		from "authgo"."role"
 		where "role"."name" = $1;
 	`
@@ -110,7 +239,9 @@ const (
 		select
 			"role"."id",
 			"role"."version",
-			"role"."name"
+			"role"."name",
+			"role"."mfa_required",
+			"role"."registration_default"
 		from "authgo"."role"
 		order by "role"."id";
 	`
@@ -119,6 +250,8 @@ const (
 			"role"."id",
 			"role"."version",
 			"role"."name",
+			"role"."mfa_required",
+			"role"."registration_default",
 			"role"."events"
 		from "authgo"."role"
 			inner join "authgo"."user_role" on "user_role"."role_id" = "role"."id"
@@ -130,6 +263,8 @@ const (
 			"role"."id",
 			"role"."version",
 			"role"."name",
+			"role"."mfa_required",
+			"role"."registration_default",
 			"role"."events"
 		from "authgo"."role"
 			inner join "authgo"."role_authority" on "role_authority"."role_id" = "role"."id"
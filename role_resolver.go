@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/graph-gophers/graphql-go"
 )
 
@@ -21,14 +23,26 @@ func (r *roleResolver) Name() string {
 	return r.role.Name
 }
 
-func (r *roleResolver) Events() ([]*eventResolver, error) {
+func (r *roleResolver) MfaRequired() bool {
+	return r.role.MFARequired
+}
+
+func (r *roleResolver) RegistrationDefault() bool {
+	return r.role.RegistrationDefault
+}
+
+func (r *roleResolver) Events(ctx context.Context) (*[]*eventResolver, error) {
+	if err := authorizeField(ctx, "Role.events", ""); err != nil {
+		return nil, err
+	}
+
 	var resolvers []*eventResolver
 
 	for _, event := range r.role.Events {
 		resolvers = append(resolvers, &eventResolver{r.repository, event})
 	}
 
-	return resolvers, nil
+	return &resolvers, nil
 }
 
 func (r *roleResolver) Authorities() ([]*authorityResolver, error) {
@@ -47,6 +61,10 @@ func (r *roleResolver) Authorities() ([]*authorityResolver, error) {
 	return resolvers, nil
 }
 
-func (r *roleResolver) Users() ([]*userResolver, error) {
+func (r *roleResolver) Users(ctx context.Context) (*[]*userResolver, error) {
+	if err := authorizeField(ctx, "Role.users", ""); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 
@@ -26,7 +25,37 @@ func newRouter() http.Handler {
 		log.Fatal(err)
 	}
 
-	s := security.New(db)
+	s := security.New(
+		db,
+		security.WithSigningKeyStore(db),
+		security.WithRefreshTokenStore(db),
+		security.WithRevocationStore(db),
+		security.WithSingleUseStore(db),
+		security.WithClientFinder(db),
+		security.WithAuthorizationCodeStore(db),
+		security.WithMFAStore(db),
+		security.WithMFAPolicy(db),
+		security.WithWebAuthnCredentialStore(db),
+		security.WithLoginAttemptStore(db),
+		security.WithEventRecorder(db),
+		security.WithBreachedPasswordStore(db),
+		security.WithPasswordUpdater(db),
+		security.WithPasswordResetTokenStore(db),
+		security.WithEmailVerificationTokenStore(db),
+		security.WithEmailUpdater(db),
+		security.WithUserRegistrar(db),
+		security.WithInvitationStore(db),
+		security.WithSessionStore(db),
+		security.WithAuthorityFinder(db),
+		security.WithPersonalAccessTokenStore(db),
+	)
+
+	err = s.CheckIssuer()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	router := chi.NewRouter()
 
 	db2, err := sqlgo.NewDB()
@@ -37,9 +66,16 @@ func newRouter() http.Handler {
 
 	uh := &userHandler{db2}
 
-	schema, err := graphql.ParseSchema(readSchema(), &rootResolver{
+	sdl := readSchema()
+	schemaPermissions, err = parsePermissions(sdl)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schema, err := graphql.ParseSchema(sdl, &rootResolver{
 		&rootQuery{db},
-		&rootMutation{db},
+		&rootMutation{db, s, s, s, s, s, s, s},
 	})
 
 	if err != nil {
@@ -48,11 +84,12 @@ func newRouter() http.Handler {
 
 	// Protected routes
 	router.Group(func(g chi.Router) {
-		g.Use(security.Authorize)
+		g.Use(s.CSRF)
+		g.Use(s.Authorize)
 
 		g.Handle("/graphql", &relay.Handler{Schema: schema})
 		g.Method(http.MethodGet, "/", httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
-			tmpl, err := template.ParseFiles("./templates/graphiql.html")
+			tmpl, err := security.ParseTemplate(r, "./templates/graphiql.html")
 
 			if err != nil {
 				return err
@@ -61,16 +98,55 @@ func newRouter() http.Handler {
 			return tmpl.Execute(w, nil)
 		}))
 
-		g.Method(http.MethodGet, "/users", httpgo.ErrorHandlerFunc(uh.getUsers))
-		g.Method(http.MethodGet, fmt.Sprintf("/users/{userID:%s}", regexpUUID), httpgo.ErrorHandlerFunc(uh.getUser))
+		g.With(security.RequireAuthority(authorityReadUsers)).Method(http.MethodGet, "/users", httpgo.ErrorHandlerFunc(uh.getUsers))
+		g.With(security.RequireAuthority(authorityReadUsers)).Method(http.MethodGet, fmt.Sprintf("/users/{userID:%s}", regexpUUID), httpgo.ErrorHandlerFunc(uh.getUser))
+		g.With(security.RequireAuthority(authorityRevokeTokens)).Method(http.MethodPost, "/tokens/revoke", httpgo.ErrorHandlerFunc(s.RevokeTokens))
+		g.With(security.RequireAuthority(authorityImpersonateUsers), security.RequireStepUp(impersonationMaxAge, "")).Method(http.MethodPost, "/impersonate", httpgo.ErrorHandlerFunc(s.Impersonate))
+		g.Method(http.MethodPost, "/impersonate/end", httpgo.ErrorHandlerFunc(s.EndImpersonation))
+		g.Method(http.MethodGet, "/passkeys", httpgo.ErrorHandlerFunc(s.GetPasskeys))
+		g.Method(http.MethodGet, "/sessions", httpgo.ErrorHandlerFunc(s.GetSessions))
+		g.With(security.RefuseImpersonation).Method(http.MethodPost, "/webauthn/register/begin", httpgo.ErrorHandlerFunc(s.BeginWebAuthnRegistration))
+		g.With(security.RefuseImpersonation).Method(http.MethodPost, "/webauthn/register/finish", httpgo.ErrorHandlerFunc(s.FinishWebAuthnRegistration))
 	})
 
 	// Public routes
 	router.Group(func(g chi.Router) {
-		g.Method(http.MethodGet, "/login", httpgo.ErrorHandlerFunc(security.GetLogin))
+		g.Use(s.CSRF)
+
+		g.Method(http.MethodGet, "/login", httpgo.ErrorHandlerFunc(s.GetLogin))
 		g.Method(http.MethodPost, "/login", httpgo.ErrorHandlerFunc(s.PostLogin))
+		g.Method(http.MethodGet, "/login/mfa", httpgo.ErrorHandlerFunc(s.GetLoginMFA))
+		g.Method(http.MethodPost, "/login/mfa", httpgo.ErrorHandlerFunc(s.PostLoginMFA))
 		g.Method(http.MethodPost, "/authenticate", httpgo.ErrorHandlerFunc(s.Authenticate))
-		g.Method(http.MethodGet, "/logout", httpgo.ErrorHandlerFunc(security.Logout))
+		g.Method(http.MethodPost, "/authenticate/mfa", httpgo.ErrorHandlerFunc(s.AuthenticateMFA))
+		g.Method(http.MethodPost, "/reauthenticate", httpgo.ErrorHandlerFunc(s.Reauthenticate))
+		g.Method(http.MethodPost, "/webauthn/login/begin", httpgo.ErrorHandlerFunc(s.BeginWebAuthnLogin))
+		g.Method(http.MethodPost, "/webauthn/login/finish", httpgo.ErrorHandlerFunc(s.FinishWebAuthnLogin))
+		g.Method(http.MethodPost, "/password/check", httpgo.ErrorHandlerFunc(s.CheckPassword))
+		g.Method(http.MethodGet, "/password/forgot", httpgo.ErrorHandlerFunc(security.GetPasswordForgot))
+		g.Method(http.MethodPost, "/password/forgot", httpgo.ErrorHandlerFunc(s.PostPasswordForgot))
+		g.Method(http.MethodGet, "/password/reset", httpgo.ErrorHandlerFunc(s.GetPasswordReset))
+		g.Method(http.MethodPost, "/password/reset", httpgo.ErrorHandlerFunc(s.PostPasswordReset))
+		g.Method(http.MethodGet, "/email/verify", httpgo.ErrorHandlerFunc(s.GetEmailVerify))
+		g.Method(http.MethodPost, "/email/verify", httpgo.ErrorHandlerFunc(s.PostEmailVerify))
+		g.Method(http.MethodGet, "/register", httpgo.ErrorHandlerFunc(s.GetRegister))
+		g.Method(http.MethodPost, "/register", httpgo.ErrorHandlerFunc(s.PostRegister))
+		g.Method(http.MethodPost, "/logout", httpgo.ErrorHandlerFunc(s.Logout))
+		g.Method(http.MethodGet, "/oauth/authorize", httpgo.ErrorHandlerFunc(s.GetAuthorize))
+		g.Method(http.MethodGet, "/verify", httpgo.ErrorHandlerFunc(s.GetVerify))
+		g.Method(http.MethodHead, "/verify", httpgo.ErrorHandlerFunc(s.GetVerify))
+	})
+
+	// Client routes, which clients of other origins call with their own credentials
+	router.Group(func(g chi.Router) {
+		g.Method(http.MethodPost, "/token/refresh", httpgo.ErrorHandlerFunc(s.RefreshToken))
+		g.Method(http.MethodGet, "/.well-known/jwks.json", httpgo.ErrorHandlerFunc(s.GetJWKS))
+		g.Method(http.MethodGet, "/.well-known/openid-configuration", httpgo.ErrorHandlerFunc(s.GetOpenIDConfiguration))
+		g.Method(http.MethodGet, "/userinfo", httpgo.ErrorHandlerFunc(s.GetUserInfo))
+		g.Method(http.MethodPost, "/userinfo", httpgo.ErrorHandlerFunc(s.GetUserInfo))
+		g.Method(http.MethodPost, "/oauth/token", httpgo.ErrorHandlerFunc(s.PostToken))
+		g.Method(http.MethodPost, "/oauth/introspect", httpgo.ErrorHandlerFunc(s.PostIntrospect))
+		g.Method(http.MethodPost, "/authorize/check", httpgo.ErrorHandlerFunc(s.PostAuthorizeCheck))
 	})
 
 	return router
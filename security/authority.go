@@ -0,0 +1,118 @@
+package security
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrForbidden is returned when the user of the request lacks the authority for what they
+	// asked for.
+	ErrForbidden = errors.New("authgo: forbidden")
+)
+
+// Authorities are what a user may do: the names of their roles, and of the authorities the
+// roles grant.
+type Authorities struct {
+	Roles       []string
+	Authorities []string
+}
+
+// authorityFinder finds the roles and authorities of a user, which are put in their tokens.
+// Changes take effect when the next token is issued.
+type authorityFinder interface {
+	FindUserAuthorities(userID string) (*Authorities, error)
+}
+
+// noAuthorityFinder grants nothing, which is the default.
+type noAuthorityFinder struct{}
+
+func (noAuthorityFinder) FindUserAuthorities(userID string) (*Authorities, error) {
+	return &Authorities{}, nil
+}
+
+// RolesFromContext returns the roles of the user of the request.
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(ctxKeyRoles).([]string)
+	return roles
+}
+
+// AuthoritiesFromContext returns the authorities of the user of the request.
+func AuthoritiesFromContext(ctx context.Context) []string {
+	authorities, _ := ctx.Value(ctxKeyAuthorities).([]string)
+	return authorities
+}
+
+// HasAuthority reports whether the user of the request has at least one of the authorities.
+func HasAuthority(ctx context.Context, authorities ...string) bool {
+	return containsAny(AuthoritiesFromContext(ctx), authorities)
+}
+
+// HasRole reports whether the user of the request has at least one of the roles.
+func HasRole(ctx context.Context, roles ...string) bool {
+	return containsAny(RolesFromContext(ctx), roles)
+}
+
+// RequireAuthority only lets requests through whose user has at least one of the
+// authorities. It must come after Authorize.
+func RequireAuthority(authorities ...string) func(http.Handler) http.Handler {
+	return require(func(ctx context.Context) bool {
+		return HasAuthority(ctx, authorities...)
+	})
+}
+
+// RequireRole only lets requests through whose user has at least one of the roles. It must
+// come after Authorize.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return require(func(ctx context.Context) bool {
+		return HasRole(ctx, roles...)
+	})
+}
+
+func require(allowed func(ctx context.Context) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			if !allowed(r.Context()) {
+				return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, ErrForbidden))
+			}
+
+			next.ServeHTTP(w, r)
+
+			return nil
+		})
+	}
+}
+
+// addAuthorities puts the roles and authorities of the user in the claims. Tokens issued to
+// third-party clients are limited by their scope instead, and carry none.
+func (s *security) addAuthorities(claims *jwtClaims, subj Subject, g *grant) error {
+	if g.clientID != "" && !g.serviceAccount {
+		return nil
+	}
+
+	authorities, err := s.authorities.FindUserAuthorities(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	claims.Roles = authorities.Roles
+	claims.Authorities = authorities.Authorities
+
+	return nil
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type grants map[string]*Authorities
+
+func (g grants) FindUserAuthorities(userID string) (*Authorities, error) {
+	if authorities, ok := g[userID]; ok {
+		return authorities, nil
+	}
+
+	return &Authorities{}, nil
+}
+
+var _ = Describe("Authority", func() {
+
+	const (
+		adminID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		userID  = "0c2a4ab6-5804-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			Authorize(next http.Handler) http.Handler
+		}
+	)
+
+	// serve authenticates as the user, and serves a request through the middleware.
+	serve := func(email string, middleware func(http.Handler) http.Handler) (int, *http.Request) {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {email}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var served *http.Request
+
+		handler := security.Authorize(middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+			w.WriteHeader(http.StatusNoContent)
+		})))
+
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.AddCookie(findCookie(w, "authgo_token"))
+		w = httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		return w.Code, served
+	}
+
+	BeforeEach(func() {
+		security = New(
+			subjects{newSubject(adminID, "erik@eies.land", "secret"), newSubject(userID, "ola@eies.land", "secret")},
+			WithAuthorityFinder(grants{adminID: {Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS", "WRITE_USERS"}}}),
+		)
+	})
+
+	It("should put the roles and authorities of the user in the request context", func() {
+		code, r := serve("erik@eies.land", RequireAuthority("READ_USERS"))
+
+		Expect(code).To(Equal(http.StatusNoContent))
+		Expect(RolesFromContext(r.Context())).To(Equal([]string{"ADMIN"}))
+		Expect(AuthoritiesFromContext(r.Context())).To(Equal([]string{"READ_USERS", "WRITE_USERS"}))
+		Expect(HasAuthority(r.Context(), "WRITE_CLIENTS", "WRITE_USERS")).To(BeTrue())
+		Expect(HasRole(r.Context(), "AUDITOR")).To(BeFalse())
+	})
+
+	It("should forbid users without the authority", func() {
+		code, r := serve("ola@eies.land", RequireAuthority("READ_USERS"))
+
+		Expect(code).To(Equal(http.StatusForbidden))
+		Expect(r).To(BeNil())
+	})
+
+	It("should require one of the roles", func() {
+		code, _ := serve("erik@eies.land", RequireRole("AUDITOR", "ADMIN"))
+
+		Expect(code).To(Equal(http.StatusNoContent))
+
+		code, _ = serve("ola@eies.land", RequireRole("AUDITOR", "ADMIN"))
+
+		Expect(code).To(Equal(http.StatusForbidden))
+	})
+})
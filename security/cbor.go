@@ -0,0 +1,184 @@
+package security
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cborMajorUnsigned = 0
+	cborMajorNegative = 1
+	cborMajorBytes    = 2
+	cborMajorText     = 3
+	cborMajorArray    = 4
+	cborMajorMap      = 5
+	cborMajorTag      = 6
+	cborMajorSimple   = 7
+	cborMaxLength     = 1 << 20
+)
+
+var (
+	errInvalidCBOR = errors.New("authgo: invalid or unsupported CBOR")
+)
+
+// cborDecoder reads the subset of CBOR (RFC 7049) that WebAuthn uses: integers, byte and
+// text strings, arrays, maps and simple values. Integers are decoded as int64, maps as
+// map[interface{}]interface{}.
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+// decodeCBOR decodes the first item of data, and returns the number of bytes it took.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := &cborDecoder{data: data}
+	value, err := d.decode()
+
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	return value, d.offset, nil
+}
+
+func (d *cborDecoder) decode() (interface{}, error) {
+	if d.offset >= len(d.data) {
+		return nil, errInvalidCBOR
+	}
+
+	initial := d.data[d.offset]
+	d.offset++
+	major := initial >> 5
+	info := initial & 0x1f
+
+	if major == cborMajorSimple {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+
+		return nil, errInvalidCBOR
+	}
+
+	argument, err := d.argument(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborMajorUnsigned:
+		if argument > 1<<63-1 {
+			return nil, errInvalidCBOR
+		}
+
+		return int64(argument), nil
+	case cborMajorNegative:
+		if argument > 1<<63-1 {
+			return nil, errInvalidCBOR
+		}
+
+		return -1 - int64(argument), nil
+	case cborMajorBytes, cborMajorText:
+		if argument > cborMaxLength || d.offset+int(argument) > len(d.data) {
+			return nil, errInvalidCBOR
+		}
+
+		value := d.data[d.offset : d.offset+int(argument)]
+		d.offset += int(argument)
+
+		if major == cborMajorText {
+			return string(value), nil
+		}
+
+		return append([]byte(nil), value...), nil
+	case cborMajorArray:
+		if argument > cborMaxLength {
+			return nil, errInvalidCBOR
+		}
+
+		values := make([]interface{}, 0, argument)
+
+		for i := uint64(0); i < argument; i++ {
+			value, err := d.decode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+	case cborMajorMap:
+		if argument > cborMaxLength {
+			return nil, errInvalidCBOR
+		}
+
+		values := make(map[interface{}]interface{}, argument)
+
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.decode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errInvalidCBOR
+			}
+
+			value, err := d.decode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[key] = value
+		}
+
+		return values, nil
+	case cborMajorTag:
+		return d.decode()
+	}
+
+	return nil, errInvalidCBOR
+}
+
+// argument reads the value that follows the initial byte. Indefinite lengths are not
+// supported.
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	size := 0
+
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, errInvalidCBOR
+	}
+
+	if d.offset+size > len(d.data) {
+		return 0, errInvalidCBOR
+	}
+
+	buf := make([]byte, 8)
+	copy(buf[8-size:], d.data[d.offset:d.offset+size])
+	d.offset += size
+
+	return binary.BigEndian.Uint64(buf), nil
+}
@@ -0,0 +1,200 @@
+package security
+
+import (
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	csrfCookieName     = "authgo_csrf"
+	headerCSRFToken    = "X-CSRF-Token"
+	headerOrigin       = "Origin"
+	headerReferer      = "Referer"
+	formKeyCSRFToken   = "csrf_token"
+	ctxKeyCSRFToken    = contextKeyCSRFToken("ctxKeyCSRFToken")
+	csrfCookieLifetime = 365 * 24 * time.Hour
+)
+
+var (
+	errCrossOrigin        = errors.New("authgo: cross-origin request")
+	errInvalidCSRFToken   = errors.New("authgo: invalid csrf token")
+	credentialCookieNames = []string{jwtCookieName, refreshTokenCookieName, mfaPendingCookieName}
+)
+
+type contextKeyCSRFToken contextKey
+
+// CSRF protects the state changing requests of browsers against cross-site request forgery.
+// Every response carries a random token in a cookie, which the page has to send back in the
+// X-CSRF-Token header or the csrf_token field. Requests from other origins are refused, and
+// requests with a Bearer token skip the checks, since browsers never add one on their own.
+// It belongs on the routes of the pages and of the cookies, not on the endpoints that clients
+// of other origins call with their own credentials, such as /oauth/token.
+func (s *security) CSRF(next http.Handler) http.Handler {
+	return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		token := csrfCookieToken(r)
+
+		if !safeMethod(r.Method) {
+			err := s.checkCSRF(r, token)
+
+			if err != nil {
+				return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, err))
+			}
+		}
+
+		if token == "" {
+			value, err := generateOpaqueToken()
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			token = value
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				Expires:  TimeFunc().Add(csrfCookieLifetime),
+				HttpOnly: false,
+				Secure:   false,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyCSRFToken, token)))
+
+		return nil
+	})
+}
+
+// checkCookieCSRF makes the checks of CSRF for a handler outside of it, when the request
+// authenticates with a cookie.
+func (s *security) checkCookieCSRF(r *http.Request) error {
+	err := s.checkCSRF(r, csrfCookieToken(r))
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, err))
+	}
+
+	return nil
+}
+
+func csrfCookieToken(r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// CSRFTokenFromContext returns the token that the pages of the request must send back.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(ctxKeyCSRFToken).(string)
+	return token
+}
+
+func (s *security) checkCSRF(r *http.Request, token string) error {
+	if _, ok := bearerToken(r); ok {
+		return nil
+	}
+
+	err := s.checkOrigin(r)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Without any of our cookies the request carries no credentials that could be abused.
+	if token == "" && !hasCredentialCookie(r) {
+		return nil
+	}
+
+	submitted := r.Header.Get(headerCSRFToken)
+
+	if submitted == "" {
+		submitted = r.PostFormValue(formKeyCSRFToken)
+	}
+
+	if token == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return errInvalidCSRFToken
+	}
+
+	return nil
+}
+
+// checkOrigin refuses requests that browsers mark as coming from another origin. Clients
+// that send neither header are no browsers.
+func (s *security) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get(headerOrigin)
+
+	if origin == "" {
+		referer, err := url.Parse(r.Header.Get(headerReferer))
+
+		if err != nil || referer.Host == "" {
+			return nil
+		}
+
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	u, err := url.Parse(origin)
+
+	if err != nil {
+		return errCrossOrigin
+	}
+
+	if origin != s.webAuthn.origin && u.Host != r.Host {
+		return errCrossOrigin
+	}
+
+	return nil
+}
+
+func hasCredentialCookie(r *http.Request) bool {
+	for _, name := range credentialCookieNames {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// ParseTemplate parses a page that can send the csrf token of the request, with the
+// csrfToken function. The impersonation function tells whether someone acts on behalf of
+// the user, so that the page can show it.
+func ParseTemplate(r *http.Request, path string) (*template.Template, error) {
+	token := CSRFTokenFromContext(r.Context())
+	acting := impersonationFromContext(r.Context())
+	tmpl, err := template.New(filepath.Base(path)).Funcs(template.FuncMap{
+		"csrfToken": func() string {
+			return token
+		},
+		"impersonation": func() *impersonation {
+			return acting
+		},
+	}).ParseFiles(path)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return tmpl, nil
+}
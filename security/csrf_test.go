@@ -0,0 +1,97 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("CSRF", func() {
+
+	var (
+		csrfCookie *http.Cookie
+		handler    http.Handler
+	)
+
+	request := func(method string, form url.Values, cookies ...*http.Cookie) *http.Request {
+		r := httptest.NewRequest(method, "http://localhost:3000/graphql", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		return r
+	}
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	BeforeEach(func() {
+		security := New(subjects{})
+		handler = security.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Token", CSRFTokenFromContext(r.Context()))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		w := serve(request(http.MethodGet, nil))
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		csrfCookie = findCookie(w, "authgo_csrf")
+
+		Expect(csrfCookie).NotTo(BeNil())
+		Expect(csrfCookie.SameSite).To(Equal(http.SameSiteLaxMode))
+		Expect(w.Header().Get("X-Token")).To(Equal(csrfCookie.Value))
+	})
+
+	It("should require the token once the browser has the cookie", func() {
+		Expect(serve(request(http.MethodPost, nil, csrfCookie)).Code).To(Equal(http.StatusForbidden))
+		Expect(serve(request(http.MethodPost, url.Values{"csrf_token": {"forged"}}, csrfCookie)).Code).To(Equal(http.StatusForbidden))
+		Expect(serve(request(http.MethodPost, url.Values{"csrf_token": {csrfCookie.Value}}, csrfCookie)).Code).To(Equal(http.StatusNoContent))
+
+		r := request(http.MethodPost, nil, csrfCookie)
+		r.Header.Set("X-CSRF-Token", csrfCookie.Value)
+
+		Expect(serve(r).Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should require the token for requests with a token cookie", func() {
+		Expect(serve(request(http.MethodPost, nil, &http.Cookie{Name: "authgo_token", Value: "token"})).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should refuse requests from other origins", func() {
+		r := request(http.MethodPost, url.Values{"csrf_token": {csrfCookie.Value}}, csrfCookie)
+		r.Header.Set("Origin", "https://evil.example")
+
+		Expect(serve(r).Code).To(Equal(http.StatusForbidden))
+
+		r = request(http.MethodPost, nil)
+		r.Header.Set("Referer", "https://evil.example/login")
+
+		Expect(serve(r).Code).To(Equal(http.StatusForbidden))
+
+		r = request(http.MethodPost, url.Values{"csrf_token": {csrfCookie.Value}}, csrfCookie)
+		r.Header.Set("Origin", "http://localhost:3000")
+
+		Expect(serve(r).Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should skip the checks for bearer tokens and clients without cookies", func() {
+		r := request(http.MethodPost, nil, csrfCookie)
+		r.Header.Set("Origin", "https://evil.example")
+		r.Header.Set("Authorization", "Bearer token")
+
+		Expect(serve(r).Code).To(Equal(http.StatusNoContent))
+		Expect(serve(request(http.MethodPost, nil)).Code).To(Equal(http.StatusNoContent))
+	})
+})
@@ -0,0 +1,151 @@
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxDecisionQuestions = 100
+	resourceSeparator    = ":"
+)
+
+var (
+	errIncompleteDecisionQuestion = errors.New("authgo: subject and authority are required")
+	errTooManyDecisionQuestions   = errors.New("authgo: too many questions")
+)
+
+// DecisionQuestion asks whether the subject, a user ID, has the authority. An authority can
+// also be granted for a single resource, under the name AUTHORITY:resource.
+type DecisionQuestion struct {
+	Subject   string `json:"subject"`
+	Authority string `json:"authority"`
+	Resource  string `json:"resource,omitempty"`
+}
+
+// DecisionAnswer answers a question, which it repeats.
+type DecisionAnswer struct {
+	DecisionQuestion
+	Allowed bool `json:"allowed"`
+}
+
+// decisionRequest holds either a single question, or a batch of them in checks.
+type decisionRequest struct {
+	DecisionQuestion
+	Checks []*DecisionQuestion `json:"checks"`
+}
+
+type decisionResponse struct {
+	Results []*DecisionAnswer `json:"results"`
+}
+
+// PostAuthorizeCheck is the decision endpoint, which tells other services what a user may do.
+// The answers come from the roles of the user as they are now, not from their tokens. The
+// caller must authenticate as a confidential client.
+func (s *security) PostAuthorizeCheck(w http.ResponseWriter, r *http.Request) error {
+	err := r.ParseForm()
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	_, err = s.authenticateConfidentialClient(r)
+
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	request := &decisionRequest{}
+
+	err = json.NewDecoder(r.Body).Decode(request)
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	batched := len(request.Checks) > 0
+	questions := request.Checks
+
+	if !batched {
+		questions = []*DecisionQuestion{&request.DecisionQuestion}
+	}
+
+	if len(questions) > maxDecisionQuestions {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, errTooManyDecisionQuestions))
+	}
+
+	answers, err := s.decide(questions)
+
+	if errors.Cause(err) == errIncompleteDecisionQuestion {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !batched {
+		return httpgo.WriteJSON(w, http.StatusOK, answers[0])
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, &decisionResponse{answers})
+}
+
+// decide answers the questions in order. Inactive and unknown users are allowed nothing.
+func (s *security) decide(questions []*DecisionQuestion) ([]*DecisionAnswer, error) {
+	granted := make(map[string][]string)
+	answers := make([]*DecisionAnswer, 0, len(questions))
+
+	for _, question := range questions {
+		if question == nil || question.Subject == "" || question.Authority == "" {
+			return nil, errIncompleteDecisionQuestion
+		}
+
+		authorities, ok := granted[question.Subject]
+
+		if !ok {
+			var err error
+
+			authorities, err = s.activeUserAuthorities(question.Subject)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			granted[question.Subject] = authorities
+		}
+
+		wanted := []string{question.Authority}
+
+		if question.Resource != "" {
+			wanted = append(wanted, question.Authority+resourceSeparator+question.Resource)
+		}
+
+		answers = append(answers, &DecisionAnswer{*question, containsAny(authorities, wanted)})
+	}
+
+	return answers, nil
+}
+
+func (s *security) activeUserAuthorities(userID string) ([]string, error) {
+	subj, err := s.FindSubjectByID(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, nil
+	}
+
+	authorities, err := s.authorities.FindUserAuthorities(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return authorities.Authorities, nil
+}
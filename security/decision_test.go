@@ -0,0 +1,112 @@
+package security_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Decision", func() {
+
+	const (
+		adminID        = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		userID         = "0c2a4ab6-5804-11e8-8879-afa0dd22785d"
+		serverClientID = "ce274fd4-5803-11e8-8879-afa0dd227870"
+	)
+
+	var (
+		security interface {
+			PostAuthorizeCheck(w http.ResponseWriter, r *http.Request) error
+		}
+		clientSecret string
+	)
+
+	check := func(body, clientSecret string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/authorize/check", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.SetBasicAuth(serverClientID, clientSecret)
+
+		httpgo.ErrorHandlerFunc(security.PostAuthorizeCheck).ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		var (
+			secretHash string
+			err        error
+		)
+
+		clientSecret, secretHash, err = GenerateClientSecret()
+
+		Expect(err).To(BeNil())
+
+		security = New(
+			subjects{newSubject(adminID, "erik@eies.land", "secret"), newSubject(userID, "ola@eies.land", "secret")},
+			WithClientFinder(clients{serverClientID: {ID: serverClientID, Name: "api", SecretHash: secretHash}}),
+			WithAuthorityFinder(grants{
+				adminID: {Authorities: []string{"READ_USERS"}},
+				userID:  {Authorities: []string{"WRITE_DOCUMENTS:42"}},
+			}),
+		)
+	})
+
+	It("should answer a single question", func() {
+		w := check(`{"subject": "`+adminID+`", "authority": "READ_USERS"}`, clientSecret)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		answer := &DecisionAnswer{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), answer)).To(Succeed())
+		Expect(answer.Allowed).To(BeTrue())
+		Expect(answer.Authority).To(Equal("READ_USERS"))
+	})
+
+	It("should answer batched questions in order", func() {
+		w := check(`{"checks": [
+			{"subject": "`+userID+`", "authority": "READ_USERS"},
+			{"subject": "`+userID+`", "authority": "WRITE_DOCUMENTS", "resource": "42"},
+			{"subject": "`+userID+`", "authority": "WRITE_DOCUMENTS", "resource": "43"},
+			{"subject": "3c2a4ab6-5804-11e8-8879-afa0dd22785d", "authority": "READ_USERS"}
+		]}`, clientSecret)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var response struct {
+			Results []*DecisionAnswer
+		}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Results).To(HaveLen(4))
+
+		var allowed []bool
+
+		for _, answer := range response.Results {
+			allowed = append(allowed, answer.Allowed)
+		}
+
+		Expect(allowed).To(Equal([]bool{false, true, false, false}))
+	})
+
+	It("should reject incomplete questions", func() {
+		w := check(`{"subject": "`+adminID+`"}`, clientSecret)
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should require client authentication", func() {
+		w := check(`{"subject": "`+adminID+`", "authority": "READ_USERS"}`, "wrong")
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,447 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	environmentEmailVerificationRequired = "AUTHGO_EMAIL_VERIFICATION_REQUIRED"
+	emailVerificationTokenLifetime       = 24 * time.Hour
+	emailVerificationResendInterval      = 10 * time.Minute
+	emailVerificationPath                = "/email/verify"
+	queryKeyEmailVerificationToken       = "token"
+	eventTypeEmailVerified               = "EMAIL_VERIFIED"
+	eventTypeEmailChanged                = "EMAIL_CHANGED"
+	emailVerificationMailSubject         = "Verify your email"
+	emailVerificationMailBody            = `Hello %s,
+
+Follow the link below to verify that this is your email. The link works once, and only
+within the next 24 hours.
+
+%s
+
+If you did not create an account or change its email, ignore this mail.
+`
+	emailChangeNoticeMailSubject = "Your email is about to change"
+	emailChangeNoticeMailBody    = `Hello %s,
+
+Someone asked to change the email of your account to %s. The change takes effect once it is
+confirmed from the new address.
+
+If it was not you, reset your password right away, and tell an administrator.
+`
+)
+
+var (
+	errInvalidEmailVerificationToken = errors.New("authgo: invalid email verification token")
+	errEmailNotVerified              = errors.New("authgo: email not verified")
+	errEmailTaken                    = errors.New("authgo: email belongs to another user")
+	errInvalidEmail                  = errors.New("authgo: invalid email")
+	errEmailsNotUpdatable            = errors.New("authgo: emails cannot be updated")
+)
+
+// EmailVerificationToken is the server-side record of an email verification link. Email is
+// the address the link was sent to, which is a new one when the user changes their email.
+// Only the hash of the token is stored.
+type EmailVerificationToken struct {
+	ID        string
+	UserID    string
+	Email     string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type emailVerificationTokenStore interface {
+	SaveEmailVerificationToken(token *EmailVerificationToken) error
+	FindEmailVerificationToken(tokenHash string) (*EmailVerificationToken, error)
+	// FindLatestEmailVerificationToken returns the token last sent to the user, if any.
+	FindLatestEmailVerificationToken(userID string) (*EmailVerificationToken, error)
+	// UseEmailVerificationToken marks the token as used, and reports false if it already was.
+	UseEmailVerificationToken(id string, usedAt time.Time) (bool, error)
+	DeleteUserEmailVerificationTokens(userID string) error
+}
+
+// emailUpdater stores the verified email of a user, which replaces the current one when it
+// differs.
+type emailUpdater interface {
+	UpdateUserEmail(userID, email string) error
+}
+
+type memoryEmailVerificationTokenStore struct {
+	sync.Mutex
+	tokens map[string]*EmailVerificationToken
+}
+
+func (m *memoryEmailVerificationTokenStore) SaveEmailVerificationToken(token *EmailVerificationToken) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]*EmailVerificationToken)
+	}
+
+	copied := *token
+	m.tokens[token.TokenHash] = &copied
+
+	return nil
+}
+
+func (m *memoryEmailVerificationTokenStore) FindEmailVerificationToken(tokenHash string) (*EmailVerificationToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *token
+
+	return &copied, nil
+}
+
+func (m *memoryEmailVerificationTokenStore) FindLatestEmailVerificationToken(userID string) (*EmailVerificationToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var latest *EmailVerificationToken
+
+	for _, token := range m.tokens {
+		if token.UserID == userID && (latest == nil || token.CreatedAt.After(latest.CreatedAt)) {
+			latest = token
+		}
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	copied := *latest
+
+	return &copied, nil
+}
+
+func (m *memoryEmailVerificationTokenStore) UseEmailVerificationToken(id string, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.ID == id {
+			if token.UsedAt != nil {
+				return false, nil
+			}
+
+			token.UsedAt = &usedAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *memoryEmailVerificationTokenStore) DeleteUserEmailVerificationTokens(userID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for tokenHash, token := range m.tokens {
+		if token.UserID == userID {
+			delete(m.tokens, tokenHash)
+		}
+	}
+
+	return nil
+}
+
+// SendEmailVerification mails a link to verify the current email of the user, unless it is
+// verified already.
+func (s *security) SendEmailVerification(userID string) error {
+	subj, err := s.FindSubjectByID(userID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil || subj.UserEmailVerified() {
+		return nil
+	}
+
+	return s.sendEmailVerification(subj, subj.UserEmail())
+}
+
+// ChangeEmail mails a link to the new email, and a notice to the current one. The email of
+// the user only changes once the link is followed.
+func (s *security) ChangeEmail(userID, email string) error {
+	email = strings.TrimSpace(email)
+
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, " \r\n") {
+		return errInvalidEmail
+	}
+
+	subj, err := s.FindSubjectByID(userID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil {
+		return errors.Errorf("authgo: no user with id %q", userID)
+	}
+
+	if strings.EqualFold(email, subj.UserEmail()) {
+		return s.SendEmailVerification(userID)
+	}
+
+	err = s.checkEmailAvailable(email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.sendEmailVerification(subj, email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.mailer.SendMail(&Mail{
+		To:      subj.UserEmail(),
+		Subject: emailChangeNoticeMailSubject,
+		Body:    fmt.Sprintf(emailChangeNoticeMailBody, subj.UserFirstName(), email),
+	})
+}
+
+// VerifyEmail marks the email the token was sent to as verified, and makes it the email of
+// the user. The token, and any other the user has, can not be used again.
+func (s *security) VerifyEmail(ctx context.Context, token string) error {
+	record, subj, err := s.findEmailVerificationToken(token)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	changed := record.Email != subj.UserEmail()
+
+	if changed {
+		// The email may have been taken since the link was sent.
+		err = s.checkEmailAvailable(record.Email)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if s.emailUpdater == nil {
+		return errEmailsNotUpdatable
+	}
+
+	used, err := s.emailVerificationTokenStore.UseEmailVerificationToken(record.ID, TimeFunc())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !used {
+		return errInvalidEmailVerificationToken
+	}
+
+	err = s.emailUpdater.UpdateUserEmail(subj.UserID(), record.Email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.emailVerificationTokenStore.DeleteUserEmailVerificationTokens(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if changed {
+		return s.events.RecordEvent(ctx, subj.UserID(), eventTypeEmailChanged, fmt.Sprintf("Email changed from %q to %q.", subj.UserEmail(), record.Email))
+	}
+
+	return s.events.RecordEvent(ctx, subj.UserID(), eventTypeEmailVerified, fmt.Sprintf("Email %q verified.", record.Email))
+}
+
+// checkEmailVerified refuses a login with an unverified email, when verification is
+// required, and sends a new link so that the user can complete it. It is always required
+// when anyone may sign up, whatever AUTHGO_EMAIL_VERIFICATION_REQUIRED says. A new link is
+// only sent when the last one is older than the resend interval, so that logins cannot be
+// used to flood the inbox.
+func (s *security) checkEmailVerified(subj Subject) error {
+	if !s.emailVerificationRequired || subj.UserEmailVerified() {
+		return nil
+	}
+
+	latest, err := s.emailVerificationTokenStore.FindLatestEmailVerificationToken(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if latest != nil && TimeFunc().Sub(latest.CreatedAt) < emailVerificationResendInterval {
+		return errEmailNotVerified
+	}
+
+	err = s.sendEmailVerification(subj, subj.UserEmail())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errEmailNotVerified
+}
+
+func (s *security) sendEmailVerification(subj Subject, email string) error {
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	value, err := generateOpaqueToken()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	err = s.emailVerificationTokenStore.SaveEmailVerificationToken(&EmailVerificationToken{
+		ID:        id.String(),
+		UserID:    subj.UserID(),
+		Email:     email,
+		TokenHash: hashOpaqueToken(value),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTokenLifetime),
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	link := s.webAuthn.origin + emailVerificationPath + "?" + url.Values{queryKeyEmailVerificationToken: {value}}.Encode()
+
+	return s.mailer.SendMail(&Mail{
+		To:      email,
+		Subject: emailVerificationMailSubject,
+		Body:    fmt.Sprintf(emailVerificationMailBody, subj.UserFirstName(), link),
+	})
+}
+
+func (s *security) checkEmailAvailable(email string) error {
+	other, err := s.FindSubjectByEmail(email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if other != nil {
+		return errEmailTaken
+	}
+
+	return nil
+}
+
+// findEmailVerificationToken returns the token and its user, as long as the token can still
+// be used. Users that wait for approval can verify their email in the meantime.
+func (s *security) findEmailVerificationToken(token string) (*EmailVerificationToken, Subject, error) {
+	if token == "" {
+		return nil, nil, errInvalidEmailVerificationToken
+	}
+
+	record, err := s.emailVerificationTokenStore.FindEmailVerificationToken(hashOpaqueToken(token))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if record == nil || record.UsedAt != nil || !record.ExpiresAt.After(TimeFunc()) {
+		return nil, nil, errInvalidEmailVerificationToken
+	}
+
+	subj, err := s.FindSubjectByID(record.UserID)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !(subj.UserActive() || subj.UserPendingApproval()) {
+		return nil, nil, errInvalidEmailVerificationToken
+	}
+
+	return record, subj, nil
+}
+
+type emailVerifyData struct {
+	Token   string
+	Email   string
+	Invalid bool
+	Taken   bool
+	Done    bool
+}
+
+// GetEmailVerify asks the user to confirm the verification, so that mail scanners that
+// follow the links do not use them up.
+func (s *security) GetEmailVerify(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get(queryKeyEmailVerificationToken)
+	record, _, err := s.findEmailVerificationToken(token)
+
+	if errors.Cause(err) == errInvalidEmailVerificationToken {
+		w.WriteHeader(http.StatusBadRequest)
+		return renderEmailVerify(w, r, &emailVerifyData{Invalid: true})
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderEmailVerify(w, r, &emailVerifyData{Token: token, Email: record.Email})
+}
+
+func (s *security) PostEmailVerify(w http.ResponseWriter, r *http.Request) error {
+	err := s.VerifyEmail(r.Context(), r.PostFormValue(queryKeyEmailVerificationToken))
+
+	switch errors.Cause(err) {
+	case nil:
+		return renderEmailVerify(w, r, &emailVerifyData{Done: true})
+	case errInvalidEmailVerificationToken:
+		w.WriteHeader(http.StatusBadRequest)
+		return renderEmailVerify(w, r, &emailVerifyData{Invalid: true})
+	case errEmailTaken:
+		w.WriteHeader(http.StatusConflict)
+		return renderEmailVerify(w, r, &emailVerifyData{Taken: true})
+	}
+
+	return errors.WithStack(err)
+}
+
+func renderEmailVerify(w http.ResponseWriter, r *http.Request, data *emailVerifyData) error {
+	tmpl, err := ParseTemplate(r, "./templates/email_verify.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+func resolveEmailVerificationRequired() bool {
+	required, _ := strconv.ParseBool(os.Getenv(environmentEmailVerificationRequired))
+	return required
+}
@@ -0,0 +1,135 @@
+package security_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+func (s subjects) UpdateUserEmail(userID, email string) error {
+	for _, subj := range s {
+		if subj.id == userID {
+			subj.email = email
+			subj.unverified = false
+		}
+	}
+
+	return nil
+}
+
+var _ = Describe("EmailVerification", func() {
+
+	const (
+		userID  = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		otherID = "0c2a4ab6-5804-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		mails  *outbox
+		events *eventLog
+		subj   *subject
+		subjs  subjects
+	)
+
+	tokenFromMail := func(mail *Mail) string {
+		u, err := url.Parse(regexp.MustCompile(`https?://\S+`).FindString(mail.Body))
+
+		Expect(err).To(BeNil())
+		Expect(u.Path).To(Equal("/email/verify"))
+
+		return u.Query().Get("token")
+	}
+
+	BeforeEach(func() {
+		mails = &outbox{}
+		events = &eventLog{}
+		subj = newSubject(userID, "erik@eies.land", "secret")
+		subj.unverified = true
+		subjs = subjects{subj, newSubject(otherID, "taken@eies.land", "secret")}
+	})
+
+	It("should block logins until the email is verified, when it is required", func() {
+		os.Setenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED", "true")
+		defer os.Unsetenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED")
+
+		security := New(subjs, WithMailer(mails), WithEventRecorder(events), WithEmailUpdater(subjs))
+		credentials := url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusForbidden))
+		Expect(*mails).To(HaveLen(1))
+		Expect((*mails)[0].To).To(Equal("erik@eies.land"))
+
+		Expect(security.VerifyEmail(context.Background(), tokenFromMail((*mails)[0]))).To(Succeed())
+		Expect(subj.unverified).To(BeFalse())
+		Expect(*events).To(Equal(eventLog{{userID, "EMAIL_VERIFIED"}}))
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not send a new link on every login", func() {
+		os.Setenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED", "true")
+		defer os.Unsetenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED")
+
+		now := time.Now()
+		TimeFunc = func() time.Time {
+			return now
+		}
+		defer func() { TimeFunc = time.Now }()
+
+		security := New(subjs, WithMailer(mails))
+		credentials := url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusForbidden))
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusForbidden))
+		Expect(*mails).To(HaveLen(1))
+
+		now = now.Add(10 * time.Minute)
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusForbidden))
+		Expect(*mails).To(HaveLen(2))
+	})
+
+	It("should let a user that waits for approval verify the email", func() {
+		subj.pending = true
+		security := New(subjs, WithMailer(mails), WithEmailUpdater(subjs))
+
+		Expect(security.SendEmailVerification(userID)).To(Succeed())
+		Expect(security.VerifyEmail(context.Background(), tokenFromMail((*mails)[0]))).To(Succeed())
+		Expect(subj.unverified).To(BeFalse())
+	})
+
+	It("should not block logins when it is not required", func() {
+		security := New(subjs, WithMailer(mails))
+
+		Expect(postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}).Code).To(Equal(http.StatusOK))
+		Expect(*mails).To(BeEmpty())
+	})
+
+	It("should change the email once the new one is confirmed, and notify the old one", func() {
+		security := New(subjs, WithMailer(mails), WithEventRecorder(events), WithEmailUpdater(subjs))
+
+		Expect(security.ChangeEmail(userID, "taken@eies.land")).NotTo(Succeed())
+		Expect(security.ChangeEmail(userID, "not an email")).NotTo(Succeed())
+		Expect(security.ChangeEmail(userID, "erik@eies.no")).To(Succeed())
+		Expect(*mails).To(HaveLen(2))
+		Expect((*mails)[0].To).To(Equal("erik@eies.no"))
+		Expect((*mails)[1].To).To(Equal("erik@eies.land"))
+		Expect((*mails)[1].Body).To(ContainSubstring("erik@eies.no"))
+		Expect(subj.email).To(Equal("erik@eies.land"))
+
+		token := tokenFromMail((*mails)[0])
+
+		Expect(security.VerifyEmail(context.Background(), token)).To(Succeed())
+		Expect(subj.email).To(Equal("erik@eies.no"))
+		Expect(subj.unverified).To(BeFalse())
+		Expect(*events).To(Equal(eventLog{{userID, "EMAIL_CHANGED"}}))
+		Expect(security.VerifyEmail(context.Background(), token)).NotTo(Succeed())
+	})
+})
@@ -4,34 +4,79 @@ import (
 	"context"
 	"html/template"
 	"net/http"
+	"net/url"
 
 	"github.com/di0nys1us/httpgo"
 	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 )
 
+// Authenticate returns the tokens, or a challenge for the second factor which is answered
+// at AuthenticateMFA.
 func (s *security) Authenticate(w http.ResponseWriter, r *http.Request) error {
-	authN, err := s.authenticateRequest(r)
+	authN, challenge, err := s.authenticateRequest(r)
 
 	if err != nil {
-		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+		return authenticationError(w, err)
+	}
+
+	if challenge != nil {
+		setMFAPendingCookie(w, challenge)
+
+		return httpgo.WriteJSON(w, http.StatusAccepted, &mfaChallengeResponse{
+			MFAToken:   challenge.token,
+			ExpiresAt:  challenge.expiresAt,
+			Enrollment: challenge.enrollment,
+			Methods:    challenge.methods,
+		})
 	}
 
 	setAuthenticationCookie(w, authN)
 
-	return httpgo.WriteJSON(w, http.StatusOK, nil)
+	return httpgo.WriteJSON(w, http.StatusOK, newTokenResponse(authN))
 }
 
-func Authorize(next http.Handler) http.Handler {
+func (s *security) AuthenticateMFA(w http.ResponseWriter, r *http.Request) error {
+	authN, _, err := s.mfaRequest(r)
+
+	if err != nil {
+		http.SetCookie(w, logoutMFAPendingCookie)
+
+		return authenticationError(w, err)
+	}
+
+	http.SetCookie(w, logoutMFAPendingCookie)
+	setAuthenticationCookie(w, authN)
+
+	return httpgo.WriteJSON(w, http.StatusOK, newTokenResponse(authN))
+}
+
+func (s *security) Authorize(next http.Handler) http.Handler {
 	return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
-		authZ, err := authorizeRequest(r)
+		authZ, err := s.authorizeRequest(r)
 
 		if err != nil {
-			return errors.WithStack(err) // TODO 403
+			w.Header().Set(headerWWWAuthenticate, bearerChallenge)
+
+			return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
 		}
 
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, ctxKeyUserID, authZ.jwtClaims.UserID)
 		ctx = context.WithValue(ctx, ctxKeyUserEmail, authZ.jwtClaims.Subject)
+		ctx = context.WithValue(ctx, ctxKeyTokenID, authZ.jwtClaims.Id)
+		ctx = context.WithValue(ctx, ctxKeyPrincipalType, authZ.jwtClaims.PrincipalType)
+		ctx = context.WithValue(ctx, ctxKeySessionID, authZ.jwtClaims.SessionID)
+		ctx = context.WithValue(ctx, ctxKeyRoles, authZ.jwtClaims.Roles)
+		ctx = context.WithValue(ctx, ctxKeyAuthorities, authZ.jwtClaims.Authorities)
+		ctx = context.WithValue(ctx, ctxKeyAuthTime, authZ.jwtClaims.AuthTime)
+		ctx = context.WithValue(ctx, ctxKeyAuthenticationLevel, authZ.jwtClaims.ACR)
+		ctx = context.WithValue(ctx, ctxKeyAuthMethods, authZ.jwtClaims.AMR)
+
+		if actor := authZ.jwtClaims.Actor; actor != nil {
+			ctx = context.WithValue(ctx, ctxKeyActorID, actor.UserID)
+			ctx = context.WithValue(ctx, ctxKeyActorEmail, actor.Subject)
+		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 
@@ -39,32 +84,263 @@ func Authorize(next http.Handler) http.Handler {
 	})
 }
 
-func GetLogin(w http.ResponseWriter, r *http.Request) error {
-	tmpl, err := template.ParseFiles("./templates/login.html")
+type loginData struct {
+	Redirect string
+}
+
+func (s *security) GetLogin(w http.ResponseWriter, r *http.Request) error {
+	tmpl, err := ParseTemplate(r, "./templates/login.html")
 
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	return tmpl.Execute(w, nil)
+	return tmpl.Execute(w, &loginData{Redirect: s.safeRedirect(r.URL.Query().Get(queryKeyRedirect))})
 }
 
 func (s *security) PostLogin(w http.ResponseWriter, r *http.Request) error {
-	authN, err := s.authenticateRequest(r)
+	authN, challenge, err := s.authenticateRequest(r)
+
+	if err != nil {
+		return authenticationError(w, err)
+	}
+
+	if challenge != nil {
+		setMFAPendingCookie(w, challenge)
+
+		query := url.Values{queryKeyRedirect: {s.safeRedirect(r.PostFormValue(queryKeyRedirect))}}
+		http.Redirect(w, r, mfaLoginPath+"?"+query.Encode(), http.StatusSeeOther)
+
+		return nil
+	}
+
+	setAuthenticationCookie(w, authN)
+
+	http.Redirect(w, r, s.safeRedirect(r.PostFormValue(queryKeyRedirect)), http.StatusSeeOther)
+
+	return nil
+}
+
+type loginMFAData struct {
+	Redirect      string
+	Enrollment    *TOTPEnrollment
+	RecoveryCodes []string
+	Failed        bool
+	WebAuthn      bool
+}
+
+// ProvisioningURI marks the otpauth URI as safe, html/template only lets web URLs through.
+func (d *loginMFAData) ProvisioningURI() template.URL {
+	return template.URL(d.Enrollment.ProvisioningURI)
+}
+
+func (s *security) GetLoginMFA(w http.ResponseWriter, r *http.Request) error {
+	claims, err := s.parseMFAPending(r)
+
+	if err != nil {
+		http.Redirect(w, r, loginPath, http.StatusSeeOther)
+		return nil
+	}
+
+	data := &loginMFAData{Redirect: s.safeRedirect(r.URL.Query().Get(queryKeyRedirect))}
+
+	factor, err := s.mfaStore.FindTOTPFactor(claims.UserID)
 
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if factor != nil && factor.ConfirmedAt == nil {
+		subj, err := s.FindSubjectByID(claims.UserID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if subj != nil {
+			data.Enrollment = newTOTPEnrollment(subj, factor)
+		}
+	}
+
+	credentials, err := s.webAuthnCredentialStore.FindWebAuthnCredentials(claims.UserID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	data.WebAuthn = len(credentials) > 0
+
+	return renderLoginMFA(w, r, data)
+}
+
+// PostLoginMFA completes the login. When it also completed the enrollment of the factor,
+// the recovery codes are shown before continuing.
+func (s *security) PostLoginMFA(w http.ResponseWriter, r *http.Request) error {
+	redirect := s.safeRedirect(r.PostFormValue(queryKeyRedirect))
+	authN, recoveryCodes, err := s.mfaRequest(r)
+
+	if errors.Cause(err) == errInvalidMFAPending {
+		http.Redirect(w, r, loginPath, http.StatusSeeOther)
+		return nil
+	}
+
+	if err != nil {
+		// The pending login was used up by the attempt, so the user has to sign in again.
+		http.SetCookie(w, logoutMFAPendingCookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return renderLoginMFA(w, r, &loginMFAData{Redirect: redirect, Failed: true})
+	}
+
+	http.SetCookie(w, logoutMFAPendingCookie)
 	setAuthenticationCookie(w, authN)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	if len(recoveryCodes) > 0 {
+		return renderLoginMFA(w, r, &loginMFAData{Redirect: redirect, RecoveryCodes: recoveryCodes})
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
 
 	return nil
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) error {
+func renderLoginMFA(w http.ResponseWriter, r *http.Request, data *loginMFAData) error {
+	tmpl, err := ParseTemplate(r, "./templates/login_mfa.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+type passkeysData struct {
+	Credentials []*WebAuthnCredential
+}
+
+// GetPasskeys lists the WebAuthn credentials of the current user, and lets them add more.
+func (s *security) GetPasskeys(w http.ResponseWriter, r *http.Request) error {
+	credentials, err := s.webAuthnCredentialStore.FindWebAuthnCredentials(UserIDFromContext(r.Context()))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmpl, err := ParseTemplate(r, "./templates/passkeys.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, &passkeysData{credentials})
+}
+
+func (s *security) GetJWKS(w http.ResponseWriter, r *http.Request) error {
+	// Make sure the current signing key exists, so it is published before it is used.
+	_, err := s.keys.signingKey()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys, err := s.keys.verificationKeys()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	set := &jwks{Keys: []*jwk{}}
+
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+
+	w.Header().Set(headerCacheControl, jwksCacheControl)
+
+	return httpgo.WriteJSON(w, http.StatusOK, set)
+}
+
+func (s *security) RefreshToken(w http.ResponseWriter, r *http.Request) error {
+	// Clients of other origins send the refresh token in the body, but the cookie of a browser
+	// needs the same protection as the other cookie routes.
+	if r.PostFormValue(formKeyRefreshToken) == "" {
+		err := s.checkCookieCSRF(r)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	authN, err := s.refreshRequest(r, "")
+
+	if err != nil {
+		http.SetCookie(w, logoutRefreshCookie)
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+	}
+
+	setAuthenticationCookie(w, authN)
+
+	return httpgo.WriteJSON(w, http.StatusOK, newTokenResponse(authN))
+}
+
+// RevokeTokens revokes the token given by token_id, or every token of the user given by
+// user_id. Without either, the token of the current request is revoked. Ids that are not
+// UUIDs are bad requests.
+func (s *security) RevokeTokens(w http.ResponseWriter, r *http.Request) error {
+	tokenID := r.PostFormValue(formKeyTokenID)
+	userID := r.PostFormValue(formKeyUserID)
+
+	if _, err := uuid.FromString(tokenID); tokenID != "" && err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, errInvalidTokenID))
+	}
+
+	if _, err := uuid.FromString(userID); userID != "" && err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, errInvalidUserID))
+	}
+
+	if tokenID == "" && userID == "" {
+		tokenID = tokenIDFromContext(r.Context())
+	}
+
+	if tokenID != "" {
+		err := s.RevokeToken(tokenID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if userID != "" {
+		err := s.RevokeUserTokens(userID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, nil)
+}
+
+// Logout ends the session of the request. It only answers POST, so that no other site can
+// log the user out with a link or an image.
+func (s *security) Logout(w http.ResponseWriter, r *http.Request) error {
+	err := s.revokeRefreshRequest(r)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if authZ, err := s.authorizeRequest(r); err == nil {
+		err = s.RevokeToken(authZ.jwtClaims.Id)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	http.SetCookie(w, logoutCookie)
+	http.SetCookie(w, logoutRefreshCookie)
+	http.SetCookie(w, logoutMFAPendingCookie)
 
 	return nil
 }
@@ -1,8 +1,13 @@
 package security_test
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/di0nys1us/httpgo"
 
@@ -12,16 +17,128 @@ import (
 	. "github.com/di0nys1us/authgo/security"
 )
 
-type findSubjectByEmailFunc func(email string) (Subject, error)
+type subject struct {
+	id         string
+	email      string
+	password   string
+	unverified bool
+	pending    bool
+}
+
+func (s *subject) UserID() string {
+	return s.id
+}
+
+func (s *subject) UserEmail() string {
+	return s.email
+}
+
+func (s *subject) UserPassword() string {
+	return s.password
+}
+
+func (s *subject) UserActive() bool {
+	return !s.pending
+}
+
+func (s *subject) UserEmailVerified() bool {
+	return !s.unverified
+}
+
+func (s *subject) UserPendingApproval() bool {
+	return s.pending
+}
+
+func (s *subject) UserFirstName() string {
+	return "Erik"
+}
+
+func (s *subject) UserLastName() string {
+	return "Eies"
+}
+
+type subjects []*subject
+
+// countingKeyStore keeps the signing keys in memory, and counts how often they are loaded.
+type countingKeyStore struct {
+	keys  []*SigningKey
+	loads int
+}
+
+func (c *countingKeyStore) FindSigningKeys(createdSince time.Time) ([]*SigningKey, error) {
+	c.loads++
+	return c.keys, nil
+}
+
+func (c *countingKeyStore) SaveSigningKey(key *SigningKey) error {
+	c.keys = append(c.keys, key)
+	return nil
+}
+
+// The csrf cookie and field that a page sends along with the refresh token cookie.
+var (
+	csrfCookie = &http.Cookie{Name: "authgo_csrf", Value: "csrf"}
+	csrfForm   = url.Values{"csrf_token": {"csrf"}}
+)
+
+func (s subjects) FindSubjectByEmail(email string) (Subject, error) {
+	for _, subj := range s {
+		if subj.email == email {
+			return subj, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s subjects) FindSubjectByID(id string) (Subject, error) {
+	for _, subj := range s {
+		if subj.id == id {
+			return subj, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func newSubject(id, email, password string) *subject {
+	hashedPassword, err := GenerateHashedPassword(password)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &subject{id: id, email: email, password: hashedPassword}
+}
+
+func postForm(handler httpgo.ErrorHandlerFunc, target string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-func (fn findSubjectByEmailFunc) FindSubjectByEmail(email string) (Subject, error) {
-	return fn(email)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func findCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+
+	return nil
 }
 
 var _ = Describe("Handler", func() {
 
 	var (
-		security = New(findSubjectByEmailFunc(func(email string) (subj Subject, err error) { return }))
+		security = New(subjects{newSubject("ce274fd4-5803-11e8-8879-afa0dd22785d", "erik@eies.land", "secret")})
 	)
 
 	Describe("Authenticate", func() {
@@ -34,4 +151,261 @@ var _ = Describe("Handler", func() {
 			Expect(w.Code).To(Equal(http.StatusUnauthorized))
 		})
 	})
+
+	Describe("Authorize", func() {
+		var tokenCookie *http.Cookie
+
+		authorize := func() int {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(tokenCookie)
+
+			security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			return w.Code
+		}
+
+		BeforeEach(func() {
+			w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			tokenCookie = findCookie(w, "authgo_token")
+		})
+
+		It("should accept a valid token", func() {
+			Expect(authorize()).To(Equal(http.StatusNoContent))
+		})
+
+		It("should accept a bearer token from the response body", func() {
+			w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+			var body struct {
+				AccessToken string `json:"accessToken"`
+				TokenType   string `json:"tokenType"`
+			}
+
+			Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+			Expect(body.TokenType).To(Equal("Bearer"))
+
+			w = httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+body.AccessToken)
+
+			security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("should reject a token revoked by logout", func() {
+			postForm(security.Logout, "/logout", nil, tokenCookie)
+
+			Expect(authorize()).ToNot(Equal(http.StatusNoContent))
+		})
+
+		It("should not reload the keys for every unknown key id", func() {
+			store := &countingKeyStore{}
+			security := New(subjects{}, WithSigningKeyStore(store))
+			now := time.Now()
+			defer func() { TimeFunc = time.Now }()
+
+			TimeFunc = func() time.Time { return now }
+
+			authorizeWith := func(kid string) int {
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT","kid":"` + kid + `"}`))
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("Authorization", "Bearer "+header+".e30.c2lnbmF0dXJl")
+
+				security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})).ServeHTTP(w, r)
+
+				return w.Code
+			}
+
+			Expect(authorizeWith("first")).To(Equal(http.StatusUnauthorized))
+
+			loads := store.loads
+
+			for _, kid := range []string{"second", "third", "fourth"} {
+				Expect(authorizeWith(kid)).To(Equal(http.StatusUnauthorized))
+			}
+
+			Expect(store.loads).To(Equal(loads))
+
+			now = now.Add(10 * time.Second)
+
+			Expect(authorizeWith("fifth")).To(Equal(http.StatusUnauthorized))
+			Expect(store.loads).To(Equal(loads + 1))
+		})
+
+		It("should reject the tokens of a user whose tokens were revoked", func() {
+			Expect(security.RevokeUserTokens("ce274fd4-5803-11e8-8879-afa0dd22785d")).To(Succeed())
+			Expect(authorize()).ToNot(Equal(http.StatusNoContent))
+		})
+
+		It("should accept a token issued in the same second, but after the revocation", func() {
+			security := New(subjects{newSubject("ce274fd4-5803-11e8-8879-afa0dd22785d", "erik@eies.land", "secret")})
+			second := time.Now().Truncate(time.Second).Add(-time.Second)
+			defer func() { TimeFunc = time.Now }()
+
+			TimeFunc = func() time.Time { return second.Add(100 * time.Millisecond) }
+			Expect(security.RevokeUserTokens("ce274fd4-5803-11e8-8879-afa0dd22785d")).To(Succeed())
+
+			TimeFunc = func() time.Time { return second.Add(500 * time.Millisecond) }
+			w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(findCookie(w, "authgo_token"))
+			w = httptest.NewRecorder()
+
+			security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusNoContent))
+		})
+	})
+
+	Describe("RefreshToken", func() {
+		var refreshCookie *http.Cookie
+
+		BeforeEach(func() {
+			w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			refreshCookie = findCookie(w, "authgo_refresh_token")
+
+			Expect(refreshCookie).ToNot(BeNil())
+		})
+
+		It("should rotate the refresh token", func() {
+			w := postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(findCookie(w, "authgo_token")).ToNot(BeNil())
+			Expect(findCookie(w, "authgo_refresh_token").Value).ToNot(Equal(refreshCookie.Value))
+		})
+
+		It("should scope the cookies to the whole site", func() {
+			w := postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(findCookie(w, "authgo_token").Path).To(Equal("/"))
+			Expect(findCookie(w, "authgo_refresh_token").Path).To(Equal("/"))
+
+			w = postForm(security.Logout, "/logout", nil, refreshCookie)
+
+			Expect(findCookie(w, "authgo_token").Path).To(Equal("/"))
+			Expect(findCookie(w, "authgo_refresh_token").Path).To(Equal("/"))
+		})
+
+		It("should revoke the token family when a refresh token is reused", func() {
+			w := postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			rotated := findCookie(w, "authgo_refresh_token")
+
+			w = postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie)
+
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+			w = postForm(security.RefreshToken, "/token/refresh", url.Values{"refresh_token": {rotated.Value}})
+
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should protect the cookie, but not the body, against cross-site requests", func() {
+			Expect(postForm(security.RefreshToken, "/token/refresh", nil, refreshCookie).Code).To(Equal(http.StatusForbidden))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(csrfForm.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Origin", "https://app.eies.land")
+			r.AddCookie(refreshCookie)
+			r.AddCookie(csrfCookie)
+
+			httpgo.ErrorHandlerFunc(security.RefreshToken).ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(url.Values{"refresh_token": {refreshCookie.Value}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Origin", "https://app.eies.land")
+
+			httpgo.ErrorHandlerFunc(security.RefreshToken).ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("RevokeTokens", func() {
+		It("should refuse ids that are not UUIDs", func() {
+			Expect(postForm(security.RevokeTokens, "/tokens/revoke", url.Values{"token_id": {"mfa_pending:abc"}}).Code).To(Equal(http.StatusBadRequest))
+			Expect(postForm(security.RevokeTokens, "/tokens/revoke", url.Values{"user_id": {"1; drop"}}).Code).To(Equal(http.StatusBadRequest))
+			Expect(postForm(security.RevokeTokens, "/tokens/revoke", url.Values{"token_id": {"0c2a4ab6-5804-11e8-8879-afa0dd22785d"}}).Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("GetJWKS", func() {
+		var security = New(subjects{})
+
+		getJWKS := func() []map[string]string {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+
+			httpgo.ErrorHandlerFunc(security.GetJWKS).ServeHTTP(w, r)
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var set struct {
+				Keys []map[string]string `json:"keys"`
+			}
+
+			Expect(json.Unmarshal(w.Body.Bytes(), &set)).To(Succeed())
+
+			return set.Keys
+		}
+
+		BeforeEach(func() {
+			security = New(subjects{})
+		})
+
+		AfterEach(func() {
+			TimeFunc = time.Now
+		})
+
+		It("should publish the current public key", func() {
+			keys := getJWKS()
+
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0]).To(HaveKeyWithValue("alg", "RS256"))
+			Expect(keys[0]).To(HaveKeyWithValue("kty", "RSA"))
+			Expect(keys[0]).To(HaveKey("kid"))
+			Expect(keys[0]).ToNot(HaveKey("d"))
+		})
+
+		It("should keep the previous key after rotation", func() {
+			previous := getJWKS()
+
+			TimeFunc = func() time.Time {
+				return time.Now().AddDate(0, 0, 7).Add(5 * time.Minute)
+			}
+
+			keys := getJWKS()
+
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0]["kid"]).To(Equal(previous[len(previous)-1]["kid"]))
+		})
+	})
 })
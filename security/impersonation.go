@@ -0,0 +1,215 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// impersonationLifetime is short, and impersonation tokens cannot be refreshed. Support
+	// staff start over when it runs out.
+	impersonationLifetime         = 10 * time.Minute
+	eventTypeImpersonationStarted = "IMPERSONATION_STARTED"
+	eventTypeImpersonationEnded   = "IMPERSONATION_ENDED"
+	ctxKeyActorID                 = contextKeyActorID("ctxKeyActorID")
+	ctxKeyActorEmail              = contextKeyActorEmail("ctxKeyActorEmail")
+)
+
+var (
+	errImpersonationNotAllowed = errors.New("authgo: impersonation requires a login of the user")
+	errImpersonationTarget     = errors.New("authgo: user cannot be impersonated")
+	errNotImpersonating        = errors.New("authgo: not impersonating")
+)
+
+type contextKeyActorID contextKey
+type contextKeyActorEmail contextKey
+
+// actorClaim is the act claim of RFC 8693, naming who acts on behalf of the subject.
+type actorClaim struct {
+	Subject string `json:"sub"`
+	UserID  string `json:"uid"`
+}
+
+// impersonation tells the pages to show that someone acts on behalf of the user.
+type impersonation struct {
+	UserEmail  string
+	ActorEmail string
+}
+
+type impersonationResponse struct {
+	AccessToken string    `json:"accessToken"`
+	TokenType   string    `json:"tokenType"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ActorIDFromContext returns who really makes the request. It is the user of the request,
+// unless someone impersonates them.
+func ActorIDFromContext(ctx context.Context) string {
+	if actorID, ok := ctx.Value(ctxKeyActorID).(string); ok && actorID != "" {
+		return actorID
+	}
+
+	return UserIDFromContext(ctx)
+}
+
+// ActorEmailFromContext returns the email of who really makes the request.
+func ActorEmailFromContext(ctx context.Context) string {
+	if actorEmail, ok := ctx.Value(ctxKeyActorEmail).(string); ok && actorEmail != "" {
+		return actorEmail
+	}
+
+	return UserEmailFromContext(ctx)
+}
+
+// ImpersonatingFromContext reports whether someone impersonates the user of the request.
+func ImpersonatingFromContext(ctx context.Context) bool {
+	actorID, _ := ctx.Value(ctxKeyActorID).(string)
+	return actorID != ""
+}
+
+func impersonationFromContext(ctx context.Context) *impersonation {
+	if !ImpersonatingFromContext(ctx) {
+		return nil
+	}
+
+	return &impersonation{UserEmailFromContext(ctx), ActorEmailFromContext(ctx)}
+}
+
+// RefuseImpersonation only lets requests through whose user is not impersonated. It guards
+// what only the user should do, such as registering passkeys. It must come after Authorize.
+func RefuseImpersonation(next http.Handler) http.Handler {
+	return require(func(ctx context.Context) bool {
+		return !ImpersonatingFromContext(ctx)
+	})(next)
+}
+
+// Impersonate issues a token for the user given by user_id, in which the current user is the
+// actor, and puts it in the token cookie. The refresh token cookie is left alone, so that a
+// refresh brings back the token of the actor. Only users can impersonate, and only users
+// that have no authority the actor lacks.
+func (s *security) Impersonate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	principalType, _ := ctx.Value(ctxKeyPrincipalType).(string)
+
+	if ImpersonatingFromContext(ctx) || principalType != "" {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, errImpersonationNotAllowed))
+	}
+
+	actorID := UserIDFromContext(ctx)
+	subj, err := s.FindSubjectByID(r.PostFormValue(formKeyUserID))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() || subj.UserID() == actorID {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, errImpersonationTarget))
+	}
+
+	allowed, err := s.coversAuthorities(actorID, subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !allowed {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, errImpersonationTarget))
+	}
+
+	actorEmail := UserEmailFromContext(ctx)
+	jwtToken, err := s.createToken(subj, &grant{
+		authTime: TimeFunc(),
+		actor:    &actorClaim{actorEmail, actorID},
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(ctx, subj.UserID(), eventTypeImpersonationStarted, fmt.Sprintf("Impersonated by %q.", actorEmail))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(ctx, actorID, eventTypeImpersonationStarted, fmt.Sprintf("Started impersonating %q.", subj.UserEmail()))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     jwtCookieName,
+		Value:    jwtToken.signedToken,
+		Path:     "/",
+		Expires:  jwtToken.expiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return httpgo.WriteJSON(w, http.StatusOK, &impersonationResponse{jwtToken.signedToken, tokenTypeBearer, jwtToken.expiresAt})
+}
+
+// EndImpersonation revokes the impersonation token of the request, and gives the actor back
+// their own token when their refresh token is at hand.
+func (s *security) EndImpersonation(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	if !ImpersonatingFromContext(ctx) {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, errNotImpersonating))
+	}
+
+	err := s.RevokeToken(tokenIDFromContext(ctx))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(ctx, ActorIDFromContext(ctx), eventTypeImpersonationEnded, fmt.Sprintf("Stopped impersonating %q.", UserEmailFromContext(ctx)))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	authN, err := s.refreshRequest(r, "")
+
+	if err != nil {
+		http.SetCookie(w, logoutCookie)
+	} else {
+		setAuthenticationCookie(w, authN)
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
+
+// coversAuthorities reports whether the actor has every authority of the user, so that
+// impersonation grants nothing new.
+func (s *security) coversAuthorities(actorID, userID string) (bool, error) {
+	actor, err := s.authorities.FindUserAuthorities(actorID)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	user, err := s.authorities.FindUserAuthorities(userID)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, authority := range user.Authorities {
+		if !containsAny(actor.Authorities, []string{authority}) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,194 @@
+package security_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+// actedEvent is a recorded event with who acted, as the actor of the context.
+type actedEvent struct {
+	userID    string
+	eventType string
+	actorID   string
+}
+
+type actorLog []actedEvent
+
+func (l *actorLog) RecordEvent(ctx context.Context, userID, eventType, description string) error {
+	*l = append(*l, actedEvent{userID, eventType, ActorIDFromContext(ctx)})
+	return nil
+}
+
+var _ = Describe("Impersonation", func() {
+
+	const (
+		adminID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		userID  = "0c2a4ab6-5804-11e8-8879-afa0dd22785d"
+		ownerID = "3c2a4ab6-5804-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			Authorize(next http.Handler) http.Handler
+			Impersonate(w http.ResponseWriter, r *http.Request) error
+			EndImpersonation(w http.ResponseWriter, r *http.Request) error
+			CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error)
+		}
+		events *actorLog
+	)
+
+	// login returns the access token of the user.
+	login := func(email string) string {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {email}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		return findCookie(w, "authgo_token").Value
+	}
+
+	// serve makes an authorized request with the token as a Bearer credential.
+	serve := func(handler httpgo.ErrorHandlerFunc, token string, form url.Values) (*httptest.ResponseRecorder, *http.Request) {
+		var served *http.Request
+
+		authorized := security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+			handler.ServeHTTP(w, r)
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/impersonate", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		authorized.ServeHTTP(w, r)
+
+		return w, served
+	}
+
+	impersonate := func(token, userID string) (*httptest.ResponseRecorder, string) {
+		w, _ := serve(security.Impersonate, token, url.Values{"user_id": {userID}})
+
+		var response struct {
+			AccessToken string
+		}
+
+		if w.Code == http.StatusOK {
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		}
+
+		return w, response.AccessToken
+	}
+
+	noContent := func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	BeforeEach(func() {
+		events = &actorLog{}
+		security = New(
+			subjects{
+				newSubject(adminID, "erik@eies.land", "secret"),
+				newSubject(userID, "ola@eies.land", "secret"),
+				newSubject(ownerID, "kari@eies.land", "secret"),
+			},
+			WithAuthorityFinder(grants{
+				adminID: {Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS", "IMPERSONATE_USERS"}},
+				userID:  {Authorities: []string{"READ_USERS"}},
+				ownerID: {Authorities: []string{"WRITE_CLIENTS"}},
+			}),
+			WithEventRecorder(events),
+		)
+	})
+
+	It("should act as the user on behalf of the actor", func() {
+		w, token := impersonate(login("erik@eies.land"), userID)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(token).NotTo(BeEmpty())
+
+		w, r := serve(noContent, token, nil)
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(UserIDFromContext(r.Context())).To(Equal(userID))
+		Expect(ActorIDFromContext(r.Context())).To(Equal(adminID))
+		Expect(ActorEmailFromContext(r.Context())).To(Equal("erik@eies.land"))
+		Expect(ImpersonatingFromContext(r.Context())).To(BeTrue())
+		Expect(AuthoritiesFromContext(r.Context())).To(Equal([]string{"READ_USERS"}))
+		Expect(*events).To(ContainElement(actedEvent{userID, "IMPERSONATION_STARTED", adminID}))
+		Expect(*events).To(ContainElement(actedEvent{adminID, "IMPERSONATION_STARTED", adminID}))
+	})
+
+	It("should not pass the step-up of the user", func() {
+		token := login("erik@eies.land")
+		_, r := serve(noContent, token, nil)
+
+		Expect(AuthenticatedRecently(r.Context(), 10*time.Minute, "")).To(BeTrue())
+
+		_, token = impersonate(token, userID)
+		_, r = serve(noContent, token, nil)
+
+		Expect(AuthenticatedRecently(r.Context(), 10*time.Minute, "")).To(BeFalse())
+	})
+
+	It("should record the actor as the one who made the changes", func() {
+		_, token := impersonate(login("erik@eies.land"), userID)
+
+		w, _ := serve(func(w http.ResponseWriter, r *http.Request) error {
+			_, _, err := security.CreatePersonalAccessToken(r.Context(), UserIDFromContext(r.Context()), "scripts", nil, nil)
+
+			if err != nil {
+				return err
+			}
+
+			return noContent(w, r)
+		}, token, nil)
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(*events).To(ContainElement(actedEvent{userID, "PERSONAL_ACCESS_TOKEN_CREATED", adminID}))
+	})
+
+	It("should not impersonate users with authorities the actor lacks", func() {
+		w, _ := impersonate(login("erik@eies.land"), ownerID)
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w, _ = impersonate(login("erik@eies.land"), adminID)
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should not impersonate while impersonating", func() {
+		_, token := impersonate(login("erik@eies.land"), userID)
+
+		w, _ := impersonate(token, ownerID)
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should revoke the token when impersonation ends", func() {
+		_, token := impersonate(login("erik@eies.land"), userID)
+
+		w, _ := serve(security.EndImpersonation, token, nil)
+
+		Expect(w.Code).To(Equal(http.StatusSeeOther))
+		Expect(*events).To(ContainElement(actedEvent{adminID, "IMPERSONATION_ENDED", adminID}))
+
+		w, _ = serve(noContent, token, nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
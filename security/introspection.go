@@ -0,0 +1,105 @@
+package security
+
+import (
+	"net/http"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	introspectionPath = "/oauth/introspect"
+	paramToken        = "token"
+)
+
+// introspectionResponse describes an access token as in RFC 7662. Inactive tokens are
+// described by active alone.
+type introspectionResponse struct {
+	Active        bool        `json:"active"`
+	Scope         string      `json:"scope,omitempty"`
+	ClientID      string      `json:"client_id,omitempty"`
+	Username      string      `json:"username,omitempty"`
+	TokenType     string      `json:"token_type,omitempty"`
+	ExpiresAt     int64       `json:"exp,omitempty"`
+	IssuedAt      int64       `json:"iat,omitempty"`
+	NotBefore     int64       `json:"nbf,omitempty"`
+	Subject       string      `json:"sub,omitempty"`
+	Audience      string      `json:"aud,omitempty"`
+	Issuer        string      `json:"iss,omitempty"`
+	ID            string      `json:"jti,omitempty"`
+	UserID        string      `json:"uid,omitempty"`
+	AuthTime      int64       `json:"auth_time,omitempty"`
+	PrincipalType string      `json:"principal_type,omitempty"`
+	SessionID     string      `json:"sid,omitempty"`
+	Roles         []string    `json:"roles,omitempty"`
+	Authorities   []string    `json:"authorities,omitempty"`
+	Actor         *actorClaim `json:"act,omitempty"`
+	AMR           []string    `json:"amr,omitempty"`
+	ACR           string      `json:"acr,omitempty"`
+}
+
+// PostIntrospect is the introspection endpoint, which lets resource servers ask whether an
+// access token is active without verifying it themselves. The caller must authenticate as a
+// confidential client. Tokens that are expired, revoked or not ours are simply inactive.
+func (s *security) PostIntrospect(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set(headerCacheControl, "no-store")
+	w.Header().Set(headerPragma, "no-cache")
+
+	response, err := s.introspectionRequest(r)
+
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, response)
+}
+
+func (s *security) introspectionRequest(r *http.Request) (*introspectionResponse, error) {
+	err := r.ParseForm()
+
+	if err != nil {
+		return nil, newOAuthError(oauthErrorInvalidRequest, "malformed request")
+	}
+
+	_, err = s.authenticateConfidentialClient(r)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	token := r.PostForm.Get(paramToken)
+
+	if token == "" {
+		return nil, newOAuthError(oauthErrorInvalidRequest, "token is required")
+	}
+
+	claims, err := s.validateToken(r.Context(), token)
+
+	if err != nil {
+		return &introspectionResponse{Active: false}, nil
+	}
+
+	return &introspectionResponse{
+		Active:        true,
+		Scope:         claims.Scope,
+		ClientID:      claims.ClientID,
+		Username:      claims.Subject,
+		TokenType:     tokenTypeBearer,
+		ExpiresAt:     claims.ExpiresAt,
+		IssuedAt:      claims.IssuedAt,
+		NotBefore:     claims.NotBefore,
+		Subject:       claims.Subject,
+		Audience:      claims.Audience,
+		Issuer:        claims.Issuer,
+		ID:            claims.Id,
+		UserID:        claims.UserID,
+		AuthTime:      claims.AuthTime,
+		PrincipalType: claims.PrincipalType,
+		SessionID:     claims.SessionID,
+		Roles:         claims.Roles,
+		Authorities:   claims.Authorities,
+		Actor:         claims.Actor,
+		AMR:           claims.AMR,
+		ACR:           claims.ACR,
+	}, nil
+}
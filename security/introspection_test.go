@@ -0,0 +1,101 @@
+package security_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Introspection", func() {
+
+	const (
+		userID         = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		serverClientID = "ce274fd4-5803-11e8-8879-afa0dd227870"
+		publicClientID = "ce274fd4-5803-11e8-8879-afa0dd227871"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			PostIntrospect(w http.ResponseWriter, r *http.Request) error
+		}
+		clientSecret string
+	)
+
+	introspect := func(token, clientID, clientSecret string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(clientID, clientSecret)
+
+		httpgo.ErrorHandlerFunc(security.PostIntrospect).ServeHTTP(w, r)
+
+		var response map[string]interface{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+
+		return w.Code, response
+	}
+
+	BeforeEach(func() {
+		var (
+			secretHash string
+			err        error
+		)
+
+		clientSecret, secretHash, err = GenerateClientSecret()
+
+		Expect(err).To(BeNil())
+
+		security = New(
+			subjects{newSubject(userID, "erik@eies.land", "secret")},
+			WithClientFinder(clients{
+				serverClientID: {ID: serverClientID, Name: "api", SecretHash: secretHash},
+				publicClientID: {ID: publicClientID, Name: "spa"},
+			}),
+			WithAuthorityFinder(grants{userID: {Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS"}}}),
+		)
+	})
+
+	It("should describe an active token", func() {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		code, response := introspect(findCookie(w, "authgo_token").Value, serverClientID, clientSecret)
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response["active"]).To(BeTrue())
+		Expect(response["sub"]).To(Equal("erik@eies.land"))
+		Expect(response["uid"]).To(Equal(userID))
+		Expect(response["token_type"]).To(Equal("Bearer"))
+		Expect(response["authorities"]).To(Equal([]interface{}{"READ_USERS"}))
+	})
+
+	It("should describe invalid tokens as inactive", func() {
+		code, response := introspect("invalid", serverClientID, clientSecret)
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response).To(Equal(map[string]interface{}{"active": false}))
+	})
+
+	It("should require client authentication", func() {
+		code, response := introspect("invalid", serverClientID, "wrong")
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+		Expect(response["error"]).To(Equal("invalid_client"))
+
+		code, _ = introspect("invalid", publicClientID, "")
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+	})
+})
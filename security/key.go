@@ -0,0 +1,449 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	environmentSigningAlgorithm    = "AUTHGO_SIGNING_ALGORITHM"
+	environmentKeyRotationInterval = "AUTHGO_KEY_ROTATION_INTERVAL"
+	defaultSigningAlgorithm        = "RS256"
+	defaultKeyRotationInterval     = 7 * 24 * time.Hour
+	rsaKeySize                     = 2048
+	pemTypePrivateKey              = "PRIVATE KEY"
+	signingAlgorithmRS256          = "RS256"
+	signingAlgorithmES256          = "ES256"
+	signingAlgorithmEdDSA          = "EdDSA"
+	jwtHeaderKeyID                 = "kid"
+	jwkKeyTypeRSA                  = "RSA"
+	jwkKeyTypeEC                   = "EC"
+	jwkKeyTypeOKP                  = "OKP"
+	jwkCurveP256                   = "P-256"
+	jwkCurveEd25519                = "Ed25519"
+	jwkUseSignature                = "sig"
+	headerCacheControl             = "Cache-Control"
+	jwksCacheControl               = "public, max-age=300"
+	// keyReloadInterval limits how often tokens with an unknown kid make the key set reload
+	// from the store.
+	keyReloadInterval = 10 * time.Second
+)
+
+var (
+	errUnsupportedSigningAlgorithm = errors.New("authgo: unsupported signing algorithm")
+	errUnknownKeyID                = errors.New("authgo: unknown key id")
+	errInvalidPrivateKey           = errors.New("authgo: invalid private key")
+)
+
+// SigningKey is a private key used to sign tokens, persisted as a PKCS #8 PEM block.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+type signingKeyStore interface {
+	FindSigningKeys(createdSince time.Time) ([]*SigningKey, error)
+	SaveSigningKey(key *SigningKey) error
+}
+
+type memorySigningKeyStore struct {
+	sync.Mutex
+	keys []*SigningKey
+}
+
+func (m *memorySigningKeyStore) FindSigningKeys(createdSince time.Time) ([]*SigningKey, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var keys []*SigningKey
+
+	for _, key := range m.keys {
+		if key.CreatedAt.After(createdSince) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (m *memorySigningKeyStore) SaveSigningKey(key *SigningKey) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.keys = append(m.keys, key)
+
+	return nil
+}
+
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// keySet keeps the signing keys in memory. The newest key signs, while older keys stay
+// available for verification until every token they could have signed has expired.
+type keySet struct {
+	sync.Mutex
+	store            signingKeyStore
+	algorithm        string
+	rotationInterval time.Duration
+	keys             []*key
+	loadedAt         time.Time
+}
+
+func newKeySet(store signingKeyStore) *keySet {
+	algorithm := defaultSigningAlgorithm
+
+	if value, ok := os.LookupEnv(environmentSigningAlgorithm); ok {
+		algorithm = value
+	}
+
+	rotationInterval := defaultKeyRotationInterval
+
+	if value, ok := os.LookupEnv(environmentKeyRotationInterval); ok {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			rotationInterval = d
+		}
+	}
+
+	return &keySet{store: store, algorithm: algorithm, rotationInterval: rotationInterval}
+}
+
+// signingKey returns the current signing key, rotating in a new one when it is due.
+func (ks *keySet) signingKey() (*key, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	now := TimeFunc()
+
+	if k := ks.newest(now); k != nil {
+		return k, nil
+	}
+
+	err := ks.load(now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if k := ks.newest(now); k != nil {
+		return k, nil
+	}
+
+	k, err := generateKey(ks.algorithm, now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signingKey, err := k.signingKey()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = ks.store.SaveSigningKey(signingKey)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	ks.keys = append(ks.keys, k)
+
+	return k, nil
+}
+
+// verificationKey implements jwt.Keyfunc.
+func (ks *keySet) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header[jwtHeaderKeyID].(string)
+
+	if kid == "" {
+		return nil, errUnknownKeyID
+	}
+
+	k, err := ks.find(kid)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, errInvalidSigningMethod
+	}
+
+	return k.private.Public(), nil
+}
+
+// verificationKeys returns every key that may still have valid tokens in circulation.
+func (ks *keySet) verificationKeys() ([]*key, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	err := ks.load(TimeFunc())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ks.keys, nil
+}
+
+func (ks *keySet) find(kid string) (*key, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	now := TimeFunc()
+
+	for _, k := range ks.keys {
+		if k.id == kid && ks.verifiable(k, now) {
+			return k, nil
+		}
+	}
+
+	// Another instance may have rotated in a key we have not seen yet. Made up kids must not
+	// hit the store on every request, though.
+	if !ks.loadedAt.IsZero() && now.Before(ks.loadedAt.Add(keyReloadInterval)) {
+		return nil, errUnknownKeyID
+	}
+
+	err := ks.load(now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, k := range ks.keys {
+		if k.id == kid {
+			return k, nil
+		}
+	}
+
+	return nil, errUnknownKeyID
+}
+
+func (ks *keySet) load(now time.Time) error {
+	signingKeys, err := ks.store.FindSigningKeys(now.Add(-ks.verificationWindow()))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys := make([]*key, 0, len(signingKeys))
+
+	for _, signingKey := range signingKeys {
+		k, err := parseSigningKey(signingKey)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.Before(keys[j].createdAt)
+	})
+
+	ks.keys = keys
+	ks.loadedAt = now
+
+	return nil
+}
+
+func (ks *keySet) newest(now time.Time) *key {
+	if len(ks.keys) == 0 {
+		return nil
+	}
+
+	k := ks.keys[len(ks.keys)-1]
+
+	if k.createdAt.Add(ks.rotationInterval).Before(now) || k.method.Alg() != ks.algorithm {
+		return nil
+	}
+
+	return k
+}
+
+func (ks *keySet) verifiable(k *key, now time.Time) bool {
+	return k.createdAt.Add(ks.verificationWindow()).After(now)
+}
+
+func (ks *keySet) verificationWindow() time.Duration {
+	return ks.rotationInterval + accessTokenLifetime
+}
+
+func generateKey(algorithm string, now time.Time) (*key, error) {
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var private crypto.Signer
+
+	switch algorithm {
+	case signingAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case signingAlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case signingAlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errUnsupportedSigningAlgorithm
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &key{id.String(), jwt.GetSigningMethod(algorithm), private, now}, nil
+}
+
+func parseSigningKey(signingKey *SigningKey) (*key, error) {
+	block, _ := pem.Decode([]byte(signingKey.PrivateKey))
+
+	if block == nil || block.Type != pemTypePrivateKey {
+		return nil, errInvalidPrivateKey
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	private, ok := parsed.(crypto.Signer)
+
+	if !ok {
+		return nil, errInvalidPrivateKey
+	}
+
+	method := jwt.GetSigningMethod(signingKey.Algorithm)
+
+	if method == nil {
+		return nil, errUnsupportedSigningAlgorithm
+	}
+
+	return &key{signingKey.ID, method, private, signingKey.CreatedAt}, nil
+}
+
+func (k *key) signingKey() (*SigningKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &SigningKey{
+		ID:         k.id,
+		Algorithm:  k.method.Alg(),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der})),
+		CreatedAt:  k.createdAt,
+	}, nil
+}
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type jwks struct {
+	Keys []*jwk `json:"keys"`
+}
+
+func (k *key) jwk() *jwk {
+	j := &jwk{KeyID: k.id, Use: jwkUseSignature, Algorithm: k.method.Alg()}
+
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		j.KeyType = jwkKeyTypeRSA
+		j.N = encodeJWKInt(public.N.Bytes())
+		j.E = encodeJWKInt(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		j.KeyType = jwkKeyTypeEC
+		j.Curve = jwkCurveP256
+		j.X = encodeJWKInt(public.X.FillBytes(make([]byte, size)))
+		j.Y = encodeJWKInt(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		j.KeyType = jwkKeyTypeOKP
+		j.Curve = jwkCurveEd25519
+		j.X = encodeJWKInt(public)
+	}
+
+	return j
+}
+
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signingMethodEdDSA adds Ed25519 support, which jwt-go does not ship with.
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingAlgorithmEdDSA, func() jwt.SigningMethod {
+		return &signingMethodEdDSA{}
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return signingAlgorithmEdDSA
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, k interface{}) error {
+	public, ok := k.(ed25519.PublicKey)
+
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, k interface{}) (string, error) {
+	private, ok := k.(ed25519.PrivateKey)
+
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
@@ -0,0 +1,486 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	environmentLockoutThreshold    = "AUTHGO_LOCKOUT_THRESHOLD"
+	environmentLockoutDuration     = "AUTHGO_LOCKOUT_DURATION"
+	environmentIPThrottleThreshold = "AUTHGO_IP_THROTTLE_THRESHOLD"
+	defaultLockoutThreshold        = 5
+	defaultLockoutDuration         = 15 * time.Minute
+	defaultIPThrottleThreshold     = 20
+	loginFailureWindow             = 15 * time.Minute
+	maxLoginDelay                  = 30 * time.Second
+	loginAttemptKeyUserPrefix      = "user:"
+	loginAttemptKeyIPPrefix        = "ip:"
+	loginAttemptKeyMFAPrefix       = "mfa:"
+	eventTypeLoginFailed           = "LOGIN_FAILED"
+	eventTypeAccountLocked         = "ACCOUNT_LOCKED"
+	headerRetryAfter               = "Retry-After"
+	headerForwardedFor             = "X-Forwarded-For"
+	// environmentTrustedProxies is the comma separated list of the addresses, such as
+	// "10.0.0.1" or "10.0.0.0/8", of the reverse proxies in front of authgo. The client IP of
+	// the requests they pass on is taken from X-Forwarded-For.
+	environmentTrustedProxies = "AUTHGO_TRUSTED_PROXIES"
+)
+
+var (
+	errInvalidCredentials = errors.New("authgo: invalid credentials")
+)
+
+// LoginAttempts counts the failed logins for an account or a client IP. Failures older
+// than the window are forgotten.
+type LoginAttempts struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+type loginAttemptStore interface {
+	FindLoginAttempts(key string) (*LoginAttempts, error)
+	// RecordLoginFailure counts a failed login, starting over when the last failure was
+	// before since.
+	RecordLoginFailure(key string, failedAt, since time.Time) (*LoginAttempts, error)
+	// LockLogin locks the key until the given time, and starts counting over.
+	LockLogin(key string, until time.Time) error
+	DeleteLoginAttempts(key string) error
+}
+
+// eventRecorder adds security events, such as failed logins, to the event log of a user. The
+// event is recorded as made by the actor of the context, and by the user itself when nobody
+// is signed in.
+type eventRecorder interface {
+	RecordEvent(ctx context.Context, userID, eventType, description string) error
+}
+
+type memoryLoginAttemptStore struct {
+	sync.Mutex
+	attempts map[string]*LoginAttempts
+}
+
+func (m *memoryLoginAttemptStore) FindLoginAttempts(key string) (*LoginAttempts, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	attempts, ok := m.attempts[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *attempts
+
+	return &copied, nil
+}
+
+func (m *memoryLoginAttemptStore) RecordLoginFailure(key string, failedAt, since time.Time) (*LoginAttempts, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.attempts == nil {
+		m.attempts = make(map[string]*LoginAttempts)
+	}
+
+	attempts, ok := m.attempts[key]
+
+	if !ok {
+		attempts = &LoginAttempts{Key: key}
+		m.attempts[key] = attempts
+	}
+
+	if attempts.LastFailedAt.Before(since) {
+		attempts.Failures = 0
+	}
+
+	attempts.Failures++
+	attempts.LastFailedAt = failedAt
+	copied := *attempts
+
+	return &copied, nil
+}
+
+func (m *memoryLoginAttemptStore) LockLogin(key string, until time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if attempts, ok := m.attempts[key]; ok {
+		attempts.Failures = 0
+		attempts.LockedUntil = &until
+	}
+
+	return nil
+}
+
+func (m *memoryLoginAttemptStore) DeleteLoginAttempts(key string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.attempts, key)
+
+	return nil
+}
+
+type noopEventRecorder struct{}
+
+func (noopEventRecorder) RecordEvent(ctx context.Context, userID, eventType, description string) error {
+	return nil
+}
+
+// lockoutPolicy locks an account after threshold failed logins in a row. Client IPs are
+// never locked, as many users may share one, but are slowed down after ipThreshold failures.
+type lockoutPolicy struct {
+	threshold   int
+	duration    time.Duration
+	ipThreshold int
+}
+
+// loginThrottledError is returned while an account is locked, or while the delay after the
+// last failed login has not passed.
+type loginThrottledError struct {
+	retryAfter time.Duration
+	locked     bool
+}
+
+func (e *loginThrottledError) Error() string {
+	if e.locked {
+		return fmt.Sprintf("authgo: account is locked, retry after %s", e.retryAfter)
+	}
+
+	return fmt.Sprintf("authgo: too many failed logins, retry after %s", e.retryAfter)
+}
+
+// UnlockUser lifts the lockout of an account and forgets its failed logins and second
+// factors.
+func (s *security) UnlockUser(userID string) error {
+	err := s.loginAttemptStore.DeleteLoginAttempts(loginAttemptKeyUserPrefix + userID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.loginAttemptStore.DeleteLoginAttempts(loginAttemptKeyMFAPrefix + userID)
+}
+
+// resolveSubject checks the credentials of a login. They are not checked at all while the
+// client IP or the account is throttled, and failures are counted for both. The attempt is
+// counted for the account before the password is checked, so that concurrent logins cannot
+// get past the threshold.
+func (s *security) resolveSubject(r *http.Request, email, password string) (Subject, error) {
+	ipKey := loginAttemptKeyIPPrefix + s.clientIP(r)
+	now := TimeFunc()
+
+	err := s.checkLoginAttempts(ipKey, s.lockout.ipThreshold, now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	subj, err := s.FindSubjectByEmail(email)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var attempts *LoginAttempts
+
+	if subj != nil {
+		key := loginAttemptKeyUserPrefix + subj.UserID()
+
+		err = s.checkLoginAttempts(key, 1, now)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		attempts, err = s.reserveAccountAttempt(key, now)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	active := subj != nil && subj.UserActive() && subj.UserPassword() != ""
+	hash := s.passwords.dummyHash()
+
+	if active {
+		hash = subj.UserPassword()
+	}
+
+	// Unknown, inactive and passwordless users are checked against a dummy hash, so that they
+	// take as long as a wrong password. Hashes that cannot be verified fail like one.
+	ok, rehash, _ := s.passwords.verify(hash, password)
+
+	if !ok || !active {
+		err = s.recordLoginFailure(r.Context(), subj, attempts, ipKey, now)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return nil, errInvalidCredentials
+	}
+
+	if rehash {
+		err = s.rehashPassword(subj, password)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	err = s.loginAttemptStore.DeleteLoginAttempts(loginAttemptKeyUserPrefix + subj.UserID())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.checkEmailVerified(subj)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return subj, nil
+}
+
+// checkLoginAttempts refuses a login while the key is locked, or until the delay after its
+// last failure has passed. The delay doubles with every failure after the free ones.
+func (s *security) checkLoginAttempts(key string, freeFailures int, now time.Time) error {
+	attempts, err := s.loginAttemptStore.FindLoginAttempts(key)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if attempts == nil {
+		return nil
+	}
+
+	if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+		return &loginThrottledError{attempts.LockedUntil.Sub(now), true}
+	}
+
+	if attempts.LastFailedAt.Before(now.Add(-loginFailureWindow)) {
+		return nil
+	}
+
+	retryAt := attempts.LastFailedAt.Add(loginDelay(attempts.Failures, freeFailures))
+
+	if now.Before(retryAt) {
+		return &loginThrottledError{retryAt.Sub(now), false}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts the failure for the client IP and, when the email belongs to a
+// user, records the attempt reserved for the account, which is locked when it reaches the
+// threshold.
+func (s *security) recordLoginFailure(ctx context.Context, subj Subject, attempts *LoginAttempts, ipKey string, now time.Time) error {
+	since := now.Add(-loginFailureWindow)
+
+	_, err := s.loginAttemptStore.RecordLoginFailure(ipKey, now, since)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil {
+		return nil
+	}
+
+	return s.recordAccountFailure(ctx, subj.UserID(), loginAttemptKeyUserPrefix+subj.UserID(), "Login", attempts, now)
+}
+
+// reserveAccountAttempt counts an attempt under the key before it is checked, and refuses it
+// when the attempts before it already used up the threshold. A successful attempt deletes
+// the key.
+func (s *security) reserveAccountAttempt(key string, now time.Time) (*LoginAttempts, error) {
+	attempts, err := s.loginAttemptStore.RecordLoginFailure(key, now, now.Add(-loginFailureWindow))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if attempts.Failures > s.lockout.threshold {
+		return nil, &loginThrottledError{s.lockout.duration, true}
+	}
+
+	return attempts, nil
+}
+
+// recordAccountFailure records a failed step of the login of a user, whose attempt was
+// reserved under the key, and locks the key when it reaches the threshold.
+func (s *security) recordAccountFailure(ctx context.Context, userID, key, step string, attempts *LoginAttempts, now time.Time) error {
+	err := s.events.RecordEvent(ctx, userID, eventTypeLoginFailed, fmt.Sprintf("%s failed (%d in a row).", step, attempts.Failures))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if attempts.Failures < s.lockout.threshold {
+		return nil
+	}
+
+	lockedUntil := now.Add(s.lockout.duration)
+
+	err = s.loginAttemptStore.LockLogin(key, lockedUntil)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.events.RecordEvent(ctx, userID, eventTypeAccountLocked, fmt.Sprintf("Account locked until %s after %d failures.", lockedUntil.Format(time.RFC3339), attempts.Failures))
+}
+
+// loginDelay is the time to wait after the last of the failures before the next login.
+func loginDelay(failures, freeFailures int) time.Duration {
+	if failures <= freeFailures {
+		return 0
+	}
+
+	shift := uint(failures - freeFailures - 1)
+
+	if shift > 5 {
+		return maxLoginDelay
+	}
+
+	delay := time.Second << shift
+
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+
+	return delay
+}
+
+// authenticationError maps a failed login to its status code, and tells throttled clients
+// when to retry. A correct password of a user whose email is not verified is forbidden.
+func authenticationError(w http.ResponseWriter, err error) error {
+	if throttled, ok := errors.Cause(err).(*loginThrottledError); ok {
+		w.Header().Set(headerRetryAfter, strconv.Itoa(int((throttled.retryAfter+time.Second-1)/time.Second)))
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusTooManyRequests, err))
+	}
+
+	if errors.Cause(err) == errEmailNotVerified {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, err))
+	}
+
+	return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+}
+
+// clientIP is the address of the client of the request. Behind trusted proxies, it is the
+// last address in X-Forwarded-For that is not one of them, since clients can make up the
+// addresses before it.
+func (s *security) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !s.trustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header[headerForwardedFor], ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+
+		if net.ParseIP(address) == nil {
+			break
+		}
+
+		ip = address
+
+		if !s.trustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (s *security) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range s.trustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveTrustedProxies reads the addresses and networks of the trusted proxies from the
+// environment.
+func resolveTrustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+
+	for _, value := range strings.Split(os.Getenv(environmentTrustedProxies), ",") {
+		value = strings.TrimSpace(value)
+
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		if _, proxy, err := net.ParseCIDR(value); err == nil {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+func resolveLockoutPolicy() *lockoutPolicy {
+	policy := &lockoutPolicy{defaultLockoutThreshold, defaultLockoutDuration, defaultIPThrottleThreshold}
+
+	if value, ok := os.LookupEnv(environmentLockoutThreshold); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			policy.threshold = n
+		}
+	}
+
+	if value, ok := os.LookupEnv(environmentLockoutDuration); ok {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			policy.duration = d
+		}
+	}
+
+	if value, ok := os.LookupEnv(environmentIPThrottleThreshold); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			policy.ipThreshold = n
+		}
+	}
+
+	return policy
+}
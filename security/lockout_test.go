@@ -0,0 +1,250 @@
+package security_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type recordedEvent struct {
+	userID    string
+	eventType string
+}
+
+type eventLog []recordedEvent
+
+func (l *eventLog) RecordEvent(ctx context.Context, userID, eventType, description string) error {
+	*l = append(*l, recordedEvent{userID, eventType})
+	return nil
+}
+
+var _ = Describe("Lockout", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		now     time.Time
+		wrong   = url.Values{"email": {"erik@eies.land"}, "password": {"wrong"}}
+		correct = url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+	)
+
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		TimeFunc = func() time.Time {
+			return now
+		}
+	})
+
+	AfterEach(func() {
+		TimeFunc = time.Now
+	})
+
+	It("should slow down and lock an account after failed logins", func() {
+		events := &eventLog{}
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithEventRecorder(events))
+		loginAs := func(form url.Values) *httptest.ResponseRecorder {
+			return postForm(security.Authenticate, "/authenticate", form)
+		}
+
+		Expect(loginAs(wrong).Code).To(Equal(http.StatusUnauthorized))
+		Expect(loginAs(wrong).Code).To(Equal(http.StatusUnauthorized))
+
+		By("delaying the next attempt")
+
+		w := loginAs(correct)
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+
+		advance(time.Second)
+		Expect(loginAs(wrong).Code).To(Equal(http.StatusUnauthorized))
+		advance(2 * time.Second)
+		Expect(loginAs(wrong).Code).To(Equal(http.StatusUnauthorized))
+		advance(4 * time.Second)
+		Expect(loginAs(wrong).Code).To(Equal(http.StatusUnauthorized))
+
+		By("locking the account at the threshold")
+
+		advance(time.Minute)
+		w = loginAs(correct)
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("840"))
+		Expect(*events).To(HaveLen(6))
+		Expect((*events)[4]).To(Equal(recordedEvent{userID, "LOGIN_FAILED"}))
+		Expect((*events)[5]).To(Equal(recordedEvent{userID, "ACCOUNT_LOCKED"}))
+
+		By("accepting the password once an administrator unlocked it")
+
+		Expect(security.UnlockUser(userID)).To(Succeed())
+		Expect(loginAs(correct).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should lock the account at the configured threshold for the configured duration", func() {
+		os.Setenv("AUTHGO_LOCKOUT_THRESHOLD", "2")
+		os.Setenv("AUTHGO_LOCKOUT_DURATION", "1h")
+		defer os.Unsetenv("AUTHGO_LOCKOUT_THRESHOLD")
+		defer os.Unsetenv("AUTHGO_LOCKOUT_DURATION")
+
+		events := &eventLog{}
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithEventRecorder(events))
+
+		Expect(postForm(security.Authenticate, "/authenticate", wrong).Code).To(Equal(http.StatusUnauthorized))
+		advance(time.Second)
+		Expect(postForm(security.Authenticate, "/authenticate", wrong).Code).To(Equal(http.StatusUnauthorized))
+		Expect(*events).To(Equal(eventLog{{userID, "LOGIN_FAILED"}, {userID, "LOGIN_FAILED"}, {userID, "ACCOUNT_LOCKED"}}))
+
+		advance(59 * time.Minute)
+		w := postForm(security.Authenticate, "/authenticate", correct)
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("60"))
+
+		advance(time.Minute)
+		Expect(postForm(security.Authenticate, "/authenticate", correct).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not check more passwords than the threshold allows when logins run concurrently", func() {
+		os.Setenv("AUTHGO_PASSWORD_HASHER", "bcrypt:cost=4")
+		defer os.Unsetenv("AUTHGO_PASSWORD_HASHER")
+
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+		codes := make(chan int, 20)
+		wg := sync.WaitGroup{}
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				codes <- postForm(security.Authenticate, "/authenticate", wrong).Code
+			}()
+		}
+
+		wg.Wait()
+		close(codes)
+
+		checked := 0
+
+		for code := range codes {
+			if code == http.StatusUnauthorized {
+				checked++
+			}
+		}
+
+		Expect(checked).To(BeNumerically(">=", 1))
+		Expect(checked).To(BeNumerically("<=", 5))
+	})
+
+	It("should tell a throttled client IP when to retry", func() {
+		os.Setenv("AUTHGO_IP_THROTTLE_THRESHOLD", "3")
+		defer os.Unsetenv("AUTHGO_IP_THROTTLE_THRESHOLD")
+
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+		unknown := url.Values{"email": {"nobody@eies.land"}, "password": {"wrong"}}
+
+		for i := 0; i < 4; i++ {
+			Expect(postForm(security.Authenticate, "/authenticate", unknown).Code).To(Equal(http.StatusUnauthorized))
+		}
+
+		w := postForm(security.Authenticate, "/authenticate", correct)
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+
+		advance(time.Second)
+		Expect(postForm(security.Authenticate, "/authenticate", unknown).Code).To(Equal(http.StatusUnauthorized))
+
+		w = postForm(security.Authenticate, "/authenticate", correct)
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("2"))
+
+		advance(2 * time.Second)
+		Expect(postForm(security.Authenticate, "/authenticate", correct).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should throttle a client IP that fails too often", func() {
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+		unknown := url.Values{"email": {"nobody@eies.land"}, "password": {"wrong"}}
+		loginFrom := func(remoteAddr string, form url.Values) int {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.RemoteAddr = remoteAddr
+
+			httpgo.ErrorHandlerFunc(security.Authenticate).ServeHTTP(w, r)
+
+			return w.Code
+		}
+
+		for i := 0; i <= 20; i++ {
+			Expect(loginFrom("192.0.2.1:1234", unknown)).To(Equal(http.StatusUnauthorized))
+		}
+
+		Expect(loginFrom("192.0.2.1:4321", correct)).To(Equal(http.StatusTooManyRequests))
+		Expect(loginFrom("192.0.2.2:1234", correct)).To(Equal(http.StatusOK))
+
+		advance(time.Second)
+
+		Expect(loginFrom("192.0.2.1:1234", correct)).To(Equal(http.StatusOK))
+	})
+
+	It("should throttle the clients behind a trusted proxy by their forwarded address", func() {
+		os.Setenv("AUTHGO_IP_THROTTLE_THRESHOLD", "3")
+		defer os.Unsetenv("AUTHGO_IP_THROTTLE_THRESHOLD")
+		os.Setenv("AUTHGO_TRUSTED_PROXIES", "10.0.0.0/8")
+		defer os.Unsetenv("AUTHGO_TRUSTED_PROXIES")
+
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+		unknown := url.Values{"email": {"nobody@eies.land"}, "password": {"wrong"}}
+		loginVia := func(remoteAddr, forwardedFor string, form url.Values) int {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+			r.RemoteAddr = remoteAddr
+
+			httpgo.ErrorHandlerFunc(security.Authenticate).ServeHTTP(w, r)
+
+			return w.Code
+		}
+
+		for i := 0; i < 4; i++ {
+			Expect(loginVia("10.0.0.1:1234", "192.0.2.1, 10.0.0.2", unknown)).To(Equal(http.StatusUnauthorized))
+		}
+
+		By("throttling the client, even when it makes up an address")
+
+		Expect(loginVia("10.0.0.1:1234", "192.0.2.1", correct)).To(Equal(http.StatusTooManyRequests))
+		Expect(loginVia("10.0.0.1:1234", "203.0.113.9, 192.0.2.1", correct)).To(Equal(http.StatusTooManyRequests))
+
+		By("not throttling the other clients of the proxy")
+
+		Expect(loginVia("10.0.0.1:1234", "192.0.2.2", correct)).To(Equal(http.StatusOK))
+
+		By("ignoring the header from clients that are not proxies")
+
+		Expect(loginVia("192.0.2.3:1234", "192.0.2.1", correct)).To(Equal(http.StatusOK))
+	})
+})
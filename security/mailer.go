@@ -0,0 +1,142 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	environmentSMTPAddr     = "AUTHGO_SMTP_ADDR"
+	environmentSMTPUsername = "AUTHGO_SMTP_USERNAME"
+	environmentSMTPPassword = "AUTHGO_SMTP_PASSWORD"
+	environmentMailFrom     = "AUTHGO_MAIL_FROM"
+	environmentMailFile     = "AUTHGO_MAIL_FILE"
+	defaultMailFrom         = "authgo@localhost"
+)
+
+var (
+	mailHeaderReplacer = strings.NewReplacer("\r", "", "\n", "")
+)
+
+// Mail is a plain text message to a single recipient.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mail to the users, such as the links to reset their passwords.
+type Mailer interface {
+	SendMail(mail *Mail) error
+}
+
+// SMTPMailer sends mail through an SMTP server at Addr, as host:port. Auth may be nil when
+// the server does not require authentication.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m *SMTPMailer) SendMail(mail *Mail) error {
+	err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{mail.To}, formatMail(m.From, mail))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// FileMailer appends mail to the file at Path, or writes it to the standard logger when
+// Path is empty. It is meant for development and tests, where no mail should leave the host.
+type FileMailer struct {
+	sync.Mutex
+	Path string
+	From string
+}
+
+func (m *FileMailer) SendMail(mail *Mail) error {
+	message := formatMail(m.From, mail)
+
+	if m.Path == "" {
+		log.Printf("authgo: mail to %s:\n%s", mail.To, message)
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(message, '\n'))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func formatMail(from string, mail *Mail) []byte {
+	var b bytes.Buffer
+
+	// Line breaks in the addresses would start new headers.
+	fmt.Fprintf(&b, "From: %s\r\n", mailHeaderReplacer.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", mailHeaderReplacer.Replace(mail.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", TimeFunc().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n")
+	b.WriteString(strings.Replace(mail.Body, "\n", "\r\n", -1))
+
+	return b.Bytes()
+}
+
+// resolveMailer sends mail through the SMTP server configured by AUTHGO_SMTP_ADDR, with
+// plain authentication when a username is set. Without a server, mail is written to the file
+// configured by AUTHGO_MAIL_FILE, or to the log.
+func resolveMailer() Mailer {
+	from := os.Getenv(environmentMailFrom)
+
+	if from == "" {
+		from = defaultMailFrom
+	}
+
+	addr := os.Getenv(environmentSMTPAddr)
+
+	if addr == "" {
+		return &FileMailer{Path: os.Getenv(environmentMailFile), From: from}
+	}
+
+	mailer := &SMTPMailer{Addr: addr, From: from}
+
+	if username := os.Getenv(environmentSMTPUsername); username != "" {
+		host, _, err := net.SplitHostPort(addr)
+
+		if err != nil {
+			host = addr
+		}
+
+		mailer.Auth = smtp.PlainAuth("", username, os.Getenv(environmentSMTPPassword), host)
+	}
+
+	return mailer
+}
@@ -0,0 +1,662 @@
+package security
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	totpIssuer           = "authgo"
+	totpDigits           = 6
+	totpPeriod           = 30
+	totpSkew             = 1
+	totpSecretSize       = 20
+	recoveryCodeCount    = 10
+	recoveryCodeLength   = 10
+	mfaPendingLifetime   = 5 * time.Minute
+	mfaPendingAudience   = "authgo:mfa"
+	mfaPendingCookieName = "authgo_mfa_pending"
+	mfaLoginPath         = "/login/mfa"
+	formKeyCode          = "code"
+	formKeyMFAToken      = "mfa_token"
+	mfaMethodTOTP        = "totp"
+	mfaMethodWebAuthn    = "webauthn"
+	mfaPendingIDPrefix   = "mfa_pending:"
+)
+
+var (
+	errInvalidMFACode      = errors.New("authgo: invalid second factor code")
+	errInvalidMFAPending   = errors.New("authgo: invalid or expired second factor login")
+	totpEncoding           = base32.StdEncoding.WithPadding(base32.NoPadding)
+	logoutMFAPendingCookie = &http.Cookie{
+		Name:     mfaPendingCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	}
+)
+
+// TOTPFactor is the TOTP (RFC 6238) second factor of a user. It only protects logins once
+// the user has confirmed it with a valid code.
+type TOTPFactor struct {
+	UserID       string
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+type mfaStore interface {
+	FindTOTPFactor(userID string) (*TOTPFactor, error)
+	// SaveTOTPFactor inserts the factor, or replaces the one of the user.
+	SaveTOTPFactor(factor *TOTPFactor) error
+	// UseTOTPStep records the time step of an accepted code, and reports false if that step,
+	// or a later one, was already used.
+	UseTOTPStep(userID string, step int64) (bool, error)
+	// DeleteTOTPFactor removes the factor and the recovery codes of the user.
+	DeleteTOTPFactor(userID string) error
+	// SaveRecoveryCodes replaces the recovery codes of the user. Only hashes are stored.
+	SaveRecoveryCodes(userID string, codeHashes []string) error
+	// UseRecoveryCode marks the code as used, and reports false if it is unknown or was used.
+	UseRecoveryCode(userID, codeHash string, usedAt time.Time) (bool, error)
+}
+
+// mfaPolicy decides whether users must use a second factor, even before they enrolled one.
+type mfaPolicy interface {
+	MFARequired(userID string) (bool, error)
+}
+
+type memoryMFAStore struct {
+	sync.Mutex
+	factors       map[string]*TOTPFactor
+	recoveryCodes map[string]map[string]bool
+}
+
+func (m *memoryMFAStore) FindTOTPFactor(userID string) (*TOTPFactor, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	factor, ok := m.factors[userID]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *factor
+
+	return &copied, nil
+}
+
+func (m *memoryMFAStore) SaveTOTPFactor(factor *TOTPFactor) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.factors == nil {
+		m.factors = make(map[string]*TOTPFactor)
+	}
+
+	copied := *factor
+	m.factors[factor.UserID] = &copied
+
+	return nil
+}
+
+func (m *memoryMFAStore) UseTOTPStep(userID string, step int64) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	factor, ok := m.factors[userID]
+
+	if !ok || factor.LastUsedStep >= step {
+		return false, nil
+	}
+
+	factor.LastUsedStep = step
+
+	return true, nil
+}
+
+func (m *memoryMFAStore) DeleteTOTPFactor(userID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.factors, userID)
+	delete(m.recoveryCodes, userID)
+
+	return nil
+}
+
+func (m *memoryMFAStore) SaveRecoveryCodes(userID string, codeHashes []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.recoveryCodes == nil {
+		m.recoveryCodes = make(map[string]map[string]bool)
+	}
+
+	m.recoveryCodes[userID] = make(map[string]bool)
+
+	for _, codeHash := range codeHashes {
+		m.recoveryCodes[userID][codeHash] = false
+	}
+
+	return nil
+}
+
+func (m *memoryMFAStore) UseRecoveryCode(userID, codeHash string, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	used, ok := m.recoveryCodes[userID][codeHash]
+
+	if !ok || used {
+		return false, nil
+	}
+
+	m.recoveryCodes[userID][codeHash] = true
+
+	return true, nil
+}
+
+// optionalMFAPolicy leaves the second factor up to the users.
+type optionalMFAPolicy struct{}
+
+func (optionalMFAPolicy) MFARequired(userID string) (bool, error) {
+	return false, nil
+}
+
+// TOTPEnrollment is what a user needs to add the factor to an authenticator app. The
+// provisioning URI is usually shown as a QR code.
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type mfaPendingClaims struct {
+	*jwt.StandardClaims
+	UserID string `json:"uid"`
+}
+
+// mfaChallenge is handed out instead of the authentication when the password was correct,
+// but a second factor is still missing.
+type mfaChallenge struct {
+	token      string
+	expiresAt  time.Time
+	enrollment *TOTPEnrollment
+	methods    []string
+}
+
+type mfaChallengeResponse struct {
+	MFAToken   string          `json:"mfaToken"`
+	ExpiresAt  time.Time       `json:"expiresAt"`
+	Enrollment *TOTPEnrollment `json:"enrollment,omitempty"`
+	Methods    []string        `json:"methods"`
+}
+
+// EnrollTOTP starts the enrollment of a TOTP factor for the user, which protects the logins
+// once it is confirmed with ConfirmTOTP. A confirmed factor has to be reset first.
+func (s *security) EnrollTOTP(userID string) (*TOTPEnrollment, error) {
+	subj, err := s.FindSubjectByID(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil {
+		return nil, errors.New("authgo: subject is nil")
+	}
+
+	factor, err := s.mfaStore.FindTOTPFactor(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if factor != nil && factor.ConfirmedAt != nil {
+		return nil, errors.New("authgo: second factor already enrolled, reset it first")
+	}
+
+	return s.enrollTOTP(subj)
+}
+
+// ConfirmTOTP confirms the enrolled factor with a code from the authenticator app, and
+// returns the recovery codes. They are never shown again.
+func (s *security) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	factor, err := s.mfaStore.FindTOTPFactor(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if factor == nil || factor.ConfirmedAt != nil {
+		return nil, errors.New("authgo: no second factor enrollment")
+	}
+
+	return s.verifySecondFactor(ctx, factor, code)
+}
+
+// ResetMFA removes the second factor of the user, for example when the device was lost.
+func (s *security) ResetMFA(userID string) error {
+	return s.mfaStore.DeleteTOTPFactor(userID)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with a confirmed factor.
+func (s *security) RegenerateRecoveryCodes(userID string) ([]string, error) {
+	factor, err := s.mfaStore.FindTOTPFactor(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if factor == nil || factor.ConfirmedAt == nil {
+		return nil, errors.New("authgo: no second factor enrolled")
+	}
+
+	return s.createRecoveryCodes(userID)
+}
+
+func (s *security) enrollTOTP(subj Subject) (*TOTPEnrollment, error) {
+	secret := make([]byte, totpSecretSize)
+
+	_, err := rand.Read(secret)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	factor := &TOTPFactor{
+		UserID:    subj.UserID(),
+		Secret:    totpEncoding.EncodeToString(secret),
+		CreatedAt: TimeFunc(),
+	}
+
+	err = s.mfaStore.SaveTOTPFactor(factor)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return newTOTPEnrollment(subj, factor), nil
+}
+
+// challengeMFA returns a challenge when the subject has to pass a second factor, and nil
+// when the password is enough. Users that are required to use a second factor, but have
+// not confirmed one, enroll one as part of the challenge. A registered WebAuthn credential
+// counts as a second factor too.
+func (s *security) challengeMFA(subj Subject) (*mfaChallenge, error) {
+	factor, err := s.mfaStore.FindTOTPFactor(subj.UserID())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	credentials, err := s.webAuthnCredentialStore.FindWebAuthnCredentials(subj.UserID())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var enrollment *TOTPEnrollment
+	methods := []string{}
+
+	if factor != nil && factor.ConfirmedAt != nil {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	if len(credentials) > 0 {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+
+	if len(methods) == 0 {
+		required, err := s.mfaPolicy.MFARequired(subj.UserID())
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !required {
+			return nil, nil
+		}
+
+		if factor != nil {
+			enrollment = newTOTPEnrollment(subj, factor)
+		} else {
+			enrollment, err = s.enrollTOTP(subj)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	id, err := generateOpaqueToken()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+	expiresAt := now.Add(mfaPendingLifetime)
+
+	token, err := s.signToken(&mfaPendingClaims{
+		&jwt.StandardClaims{
+			Audience:  mfaPendingAudience,
+			ExpiresAt: expiresAt.Unix(),
+			Id:        id,
+			IssuedAt:  now.Unix(),
+			Issuer:    s.issuer,
+			Subject:   subj.UserEmail(),
+		},
+		subj.UserID(),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &mfaChallenge{token, expiresAt, enrollment, methods}, nil
+}
+
+// mfaRequest completes a login that is waiting for its second factor. Recovery codes are
+// returned when the request confirmed the enrollment of a factor. A pending login only gets
+// one try, after a wrong code the password has to be entered again.
+func (s *security) mfaRequest(r *http.Request) (*authentication, []string, error) {
+	err := r.ParseForm()
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	claims, err := s.parseMFAPending(r)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	first, err := s.singleUseStore.UseOnce(mfaPendingIDPrefix+claims.Id, time.Unix(claims.ExpiresAt, 0))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !first {
+		return nil, nil, errInvalidMFAPending
+	}
+
+	factor, err := s.mfaStore.FindTOTPFactor(claims.UserID)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if factor == nil {
+		return nil, nil, errInvalidMFACode
+	}
+
+	recoveryCodes, err := s.verifySecondFactor(r.Context(), factor, r.Form.Get(formKeyCode))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	subj, err := s.FindSubjectByID(claims.UserID)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, nil, errors.New("authgo: user is not active")
+	}
+
+	authN, err := s.createAuthentication(subj, s.loginGrant(r, amrPassword, amrOTP))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return authN, recoveryCodes, nil
+}
+
+// parseMFAPending reads the pending login from the form, or from its cookie.
+func (s *security) parseMFAPending(r *http.Request) (*mfaPendingClaims, error) {
+	signedToken := r.Form.Get(formKeyMFAToken)
+
+	if signedToken == "" {
+		cookie, err := r.Cookie(mfaPendingCookieName)
+
+		if err != nil {
+			return nil, errInvalidMFAPending
+		}
+
+		signedToken = cookie.Value
+	}
+
+	claims := &mfaPendingClaims{}
+	token, err := jwt.ParseWithClaims(signedToken, claims, s.keys.verificationKey)
+
+	if err != nil || !token.Valid {
+		return nil, errInvalidMFAPending
+	}
+
+	if claims.Valid() != nil || !claims.VerifyAudience(mfaPendingAudience, true) {
+		return nil, errInvalidMFAPending
+	}
+
+	return claims, nil
+}
+
+// verifySecondFactor checks the code of the second factor. Wrong codes are counted for the
+// user like failed logins, but apart from them, as the password was already correct.
+func (s *security) verifySecondFactor(ctx context.Context, factor *TOTPFactor, code string) ([]string, error) {
+	key := loginAttemptKeyMFAPrefix + factor.UserID
+	now := TimeFunc()
+
+	err := s.checkLoginAttempts(key, 1, now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	attempts, err := s.reserveAccountAttempt(key, now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	recoveryCodes, err := s.verifySecondFactorCode(factor, strings.TrimSpace(code), now)
+
+	if errors.Cause(err) == errInvalidMFACode {
+		failureErr := s.recordAccountFailure(ctx, factor.UserID, key, "Second factor", attempts, now)
+
+		if failureErr != nil {
+			return nil, errors.WithStack(failureErr)
+		}
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.loginAttemptStore.DeleteLoginAttempts(key)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return recoveryCodes, nil
+}
+
+// verifySecondFactorCode accepts a TOTP code, or one of the recovery codes once the factor is
+// confirmed. A valid code for an unconfirmed factor confirms it.
+func (s *security) verifySecondFactorCode(factor *TOTPFactor, code string, now time.Time) ([]string, error) {
+	if step, ok := validateTOTP(factor.Secret, code, now); ok {
+		used, err := s.mfaStore.UseTOTPStep(factor.UserID, step)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !used {
+			return nil, errInvalidMFACode
+		}
+
+		if factor.ConfirmedAt != nil {
+			return nil, nil
+		}
+
+		factor.ConfirmedAt = &now
+		factor.LastUsedStep = step
+
+		err = s.mfaStore.SaveTOTPFactor(factor)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return s.createRecoveryCodes(factor.UserID)
+	}
+
+	if factor.ConfirmedAt == nil {
+		return nil, errInvalidMFACode
+	}
+
+	used, err := s.mfaStore.UseRecoveryCode(factor.UserID, hashOpaqueToken(normalizeRecoveryCode(code)), now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !used {
+		return nil, errInvalidMFACode
+	}
+
+	return nil, nil
+}
+
+func (s *security) createRecoveryCodes(userID string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	codeHashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		random := make([]byte, recoveryCodeLength)
+
+		_, err := rand.Read(random)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:recoveryCodeLength]
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		codeHashes[i] = hashOpaqueToken(code)
+	}
+
+	err := s.mfaStore.SaveRecoveryCodes(userID, codeHashes)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.Replace(code, "-", "", -1), " ", "", -1))
+}
+
+func newTOTPEnrollment(subj Subject, factor *TOTPFactor) *TOTPEnrollment {
+	query := url.Values{
+		"secret":    {factor.Secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(totpIssuer + ":" + subj.UserEmail())
+
+	return &TOTPEnrollment{
+		Secret:          factor.Secret,
+		ProvisioningURI: "otpauth://totp/" + label + "?" + query.Encode(),
+	}
+}
+
+// TOTPCode computes the code of the secret at the given time, like an authenticator app.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// validateTOTP accepts the codes of the current time step and its neighbours, to allow for
+// clock drift, and returns the step that matched.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func totpCode(key []byte, step int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func setMFAPendingCookie(w http.ResponseWriter, challenge *mfaChallenge) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaPendingCookieName,
+		Value:    challenge.token,
+		Path:     "/",
+		Expires:  challenge.expiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
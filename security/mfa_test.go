@@ -0,0 +1,210 @@
+package security_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type requiredMFAPolicy struct{}
+
+func (requiredMFAPolicy) MFARequired(userID string) (bool, error) {
+	return true, nil
+}
+
+var _ = Describe("MFA", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		credentials = url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+		now         time.Time
+		events      *eventLog
+		security    interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			AuthenticateMFA(w http.ResponseWriter, r *http.Request) error
+			Authorize(next http.Handler) http.Handler
+			EnrollTOTP(userID string) (*TOTPEnrollment, error)
+			ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+			ResetMFA(userID string) error
+			UnlockUser(userID string) error
+		}
+	)
+
+	// Every spec starts with its own failed attempts, at the start of a time step, so that the
+	// codes and delays do not depend on the clock.
+	BeforeEach(func() {
+		now = time.Now().Truncate(30 * time.Second)
+		TimeFunc = func() time.Time {
+			return now
+		}
+
+		events = &eventLog{}
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithEventRecorder(events))
+	})
+
+	AfterEach(func() {
+		TimeFunc = time.Now
+	})
+
+	type challenge struct {
+		MFAToken   string `json:"mfaToken"`
+		Enrollment *struct {
+			Secret string `json:"secret"`
+		} `json:"enrollment"`
+	}
+
+	authenticate := func(handler httpgo.ErrorHandlerFunc) *challenge {
+		w := postForm(handler, "/authenticate", credentials)
+
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		Expect(findCookie(w, "authgo_token")).To(BeNil())
+
+		c := &challenge{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), c)).To(Succeed())
+
+		return c
+	}
+
+	code := func(secret string, t time.Time) string {
+		code, err := TOTPCode(secret, t)
+
+		Expect(err).To(BeNil())
+
+		return code
+	}
+
+	It("should require the second factor once it is confirmed", func() {
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+
+		enrollment, err := security.EnrollTOTP(userID)
+
+		Expect(err).To(BeNil())
+		Expect(enrollment.ProvisioningURI).To(HavePrefix("otpauth://totp/authgo:erik@eies.land?"))
+
+		recoveryCodes, err := security.ConfirmTOTP(context.Background(), userID, code(enrollment.Secret, now))
+
+		Expect(err).To(BeNil())
+		Expect(recoveryCodes).To(HaveLen(10))
+
+		c := authenticate(security.Authenticate)
+
+		By("rejecting a code that was already used")
+
+		w := postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(enrollment.Secret, now)}})
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("accepting the next code in a new login")
+
+		c = authenticate(security.Authenticate)
+		w = postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(enrollment.Secret, now.Add(30*time.Second))}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(findCookie(w, "authgo_token")).NotTo(BeNil())
+
+		By("accepting a recovery code once")
+
+		c = authenticate(security.Authenticate)
+		w = postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"code": {recoveryCodes[0]}}, &http.Cookie{Name: "authgo_mfa_pending", Value: c.MFAToken})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		c = authenticate(security.Authenticate)
+		w = postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {recoveryCodes[0]}})
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("no longer requiring it after a reset")
+
+		Expect(security.ResetMFA(userID)).To(Succeed())
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should enroll users that are required to use a second factor", func() {
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithMFAPolicy(requiredMFAPolicy{}))
+
+		c := authenticate(security.Authenticate)
+
+		Expect(c.Enrollment).NotTo(BeNil())
+
+		w := postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(c.Enrollment.Secret, now)}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		c = authenticate(security.Authenticate)
+
+		Expect(c.Enrollment).To(BeNil())
+	})
+
+	It("should give each pending login one try and lock the second factor after failures", func() {
+		enrollment, err := security.EnrollTOTP(userID)
+
+		Expect(err).To(BeNil())
+
+		_, err = security.ConfirmTOTP(context.Background(), userID, code(enrollment.Secret, now))
+
+		Expect(err).To(BeNil())
+
+		By("refusing to replay a pending login")
+
+		c := authenticate(security.Authenticate)
+
+		Expect(postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {"000000"}}).Code).To(Equal(http.StatusUnauthorized))
+
+		now = now.Add(time.Minute)
+		w := postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(enrollment.Secret, now)}})
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("locking the second factor at the threshold")
+
+		for i := 0; i < 4; i++ {
+			now = now.Add(time.Minute)
+			c = authenticate(security.Authenticate)
+
+			Expect(postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {"000000"}}).Code).To(Equal(http.StatusUnauthorized))
+		}
+
+		now = now.Add(time.Minute)
+		c = authenticate(security.Authenticate)
+		w = postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(enrollment.Secret, now)}})
+
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(*events).To(ContainElement(recordedEvent{userID, "ACCOUNT_LOCKED"}))
+
+		By("accepting the code once an administrator unlocked it")
+
+		Expect(security.UnlockUser(userID)).To(Succeed())
+
+		c = authenticate(security.Authenticate)
+
+		Expect(postForm(security.AuthenticateMFA, "/authenticate/mfa", url.Values{"mfa_token": {c.MFAToken}, "code": {code(enrollment.Secret, now)}}).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not accept the pending login as an access token", func() {
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithMFAPolicy(requiredMFAPolicy{}))
+
+		c := authenticate(security.Authenticate)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		r.Header.Set("Authorization", "Bearer "+c.MFAToken)
+
+		security.Authorize(http.NotFoundHandler()).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,583 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	authorizationCodeLifetime         = time.Minute
+	loginPath                         = "/login"
+	tokenPath                         = "/oauth/token"
+	queryKeyRedirect                  = "rd"
+	paramResponseType                 = "response_type"
+	paramClientID                     = "client_id"
+	paramClientSecret                 = "client_secret"
+	paramRedirectURI                  = "redirect_uri"
+	paramScope                        = "scope"
+	paramState                        = "state"
+	paramCode                         = "code"
+	paramCodeChallenge                = "code_challenge"
+	paramCodeChallengeMethod          = "code_challenge_method"
+	paramCodeVerifier                 = "code_verifier"
+	paramGrantType                    = "grant_type"
+	paramError                        = "error"
+	paramErrorDescription             = "error_description"
+	responseTypeCode                  = "code"
+	grantTypeAuthorizationCode        = "authorization_code"
+	grantTypeRefreshToken             = "refresh_token"
+	codeChallengeMethodS256           = "S256"
+	minCodeVerifierLength             = 43
+	maxCodeVerifierLength             = 128
+	oauthErrorInvalidRequest          = "invalid_request"
+	oauthErrorInvalidClient           = "invalid_client"
+	oauthErrorInvalidGrant            = "invalid_grant"
+	oauthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	oauthErrorUnsupportedResponseType = "unsupported_response_type"
+	headerPragma                      = "Pragma"
+)
+
+// Client is an application registered to obtain tokens through OAuth 2.0. Public clients,
+// such as single page applications, have neither a secret nor a public key. Clients with a
+// service account may obtain tokens for it with the client credentials grant.
+type Client struct {
+	ID               string
+	Name             string
+	SecretHash       string
+	PublicKey        string
+	RedirectURIs     []string
+	ServiceAccountID string
+}
+
+func (c *Client) public() bool {
+	return c.SecretHash == "" && c.PublicKey == ""
+}
+
+func (c *Client) hasRedirectURI(redirectURI string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+type clientFinder interface {
+	FindClientByID(id string) (*Client, error)
+}
+
+type memoryClientFinder map[string]*Client
+
+func (m memoryClientFinder) FindClientByID(id string) (*Client, error) {
+	return m[id], nil
+}
+
+// AuthorizationCode is the server-side record of an issued authorization code. Only the hash
+// of the code is stored.
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+	AMR           []string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+}
+
+type authorizationCodeStore interface {
+	SaveAuthorizationCode(code *AuthorizationCode) error
+	// ConsumeAuthorizationCode removes the code, so that it can only be used once.
+	// It returns nil if the code is unknown or has already been used.
+	ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error)
+}
+
+type memoryAuthorizationCodeStore struct {
+	sync.Mutex
+	codes map[string]*AuthorizationCode
+}
+
+func (m *memoryAuthorizationCodeStore) SaveAuthorizationCode(code *AuthorizationCode) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.codes == nil {
+		m.codes = make(map[string]*AuthorizationCode)
+	}
+
+	m.codes[code.CodeHash] = code
+
+	return nil
+}
+
+func (m *memoryAuthorizationCodeStore) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	code := m.codes[codeHash]
+	delete(m.codes, codeHash)
+
+	return code, nil
+}
+
+// GenerateClientSecret returns a new client secret and the hash to store for it.
+func GenerateClientSecret() (string, string, error) {
+	secret, err := generateOpaqueToken()
+
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	hashedSecret, err := GenerateHashedPassword(secret)
+
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	return secret, hashedSecret, nil
+}
+
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	statusCode  int
+}
+
+func (e *oauthError) Error() string {
+	return "authgo: " + e.Code + ": " + e.Description
+}
+
+func newOAuthError(code, description string) *oauthError {
+	statusCode := http.StatusBadRequest
+
+	if code == oauthErrorInvalidClient {
+		statusCode = http.StatusUnauthorized
+	}
+
+	return &oauthError{code, description, statusCode}
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+type authorizationRequest struct {
+	client        *Client
+	redirectURI   string
+	responseURI   string
+	scope         string
+	state         string
+	codeChallenge string
+	nonce         string
+}
+
+// GetAuthorize is the authorization endpoint. Users without a session are sent through
+// the login page first, and come back here afterwards.
+func (s *security) GetAuthorize(w http.ResponseWriter, r *http.Request) error {
+	authReq, err := s.parseAuthorizationRequest(r)
+
+	if err != nil {
+		// Without a verified redirect URI, the error can only be shown to the user.
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	if oauthErr := validateAuthorizationRequest(r); oauthErr != nil {
+		redirectWithParams(w, r, authReq.responseURI, url.Values{
+			paramError:            {oauthErr.Code},
+			paramErrorDescription: {oauthErr.Description},
+			paramState:            {authReq.state},
+		})
+
+		return nil
+	}
+
+	authZ, err := s.authorizeRequest(r)
+
+	// Only the users themselves may authorize clients, not tokens they handed out or those
+	// who impersonate them.
+	if err != nil || authZ.jwtClaims.ClientID != "" || authZ.jwtClaims.PrincipalType != "" || authZ.jwtClaims.Actor != nil {
+		http.Redirect(w, r, loginPath+"?"+url.Values{queryKeyRedirect: {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+
+		return nil
+	}
+
+	code, err := s.createAuthorizationCode(authZ.jwtClaims, authReq)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	redirectWithParams(w, r, authReq.responseURI, url.Values{
+		paramCode:  {code},
+		paramState: {authReq.state},
+	})
+
+	return nil
+}
+
+// PostToken is the token endpoint.
+func (s *security) PostToken(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set(headerCacheControl, "no-store")
+	w.Header().Set(headerPragma, "no-cache")
+
+	response, err := s.tokenRequest(r)
+
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, response)
+}
+
+// writeOAuthError responds with the error as described in RFC 6749. Other errors are
+// returned as they are.
+func writeOAuthError(w http.ResponseWriter, err error) error {
+	oauthErr, ok := errors.Cause(err).(*oauthError)
+
+	if !ok {
+		return errors.WithStack(err)
+	}
+
+	if oauthErr.Code == oauthErrorInvalidClient {
+		w.Header().Set(headerWWWAuthenticate, `Basic realm="authgo"`)
+	}
+
+	return httpgo.WriteJSON(w, oauthErr.statusCode, oauthErr)
+}
+
+func (s *security) parseAuthorizationRequest(r *http.Request) (*authorizationRequest, error) {
+	query := r.URL.Query()
+
+	client, err := s.clients.FindClientByID(query.Get(paramClientID))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if client == nil {
+		return nil, errors.New("authgo: unknown client")
+	}
+
+	redirectURI := query.Get(paramRedirectURI)
+	responseURI := redirectURI
+
+	switch {
+	case redirectURI == "" && len(client.RedirectURIs) == 1:
+		responseURI = client.RedirectURIs[0]
+	case !client.hasRedirectURI(redirectURI):
+		return nil, errors.New("authgo: invalid redirect uri")
+	}
+
+	return &authorizationRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		responseURI:   responseURI,
+		scope:         query.Get(paramScope),
+		state:         query.Get(paramState),
+		codeChallenge: query.Get(paramCodeChallenge),
+		nonce:         query.Get(paramNonce),
+	}, nil
+}
+
+func validateAuthorizationRequest(r *http.Request) *oauthError {
+	query := r.URL.Query()
+
+	if query.Get(paramResponseType) != responseTypeCode {
+		return newOAuthError(oauthErrorUnsupportedResponseType, "response_type must be code")
+	}
+
+	if query.Get(paramCodeChallenge) == "" {
+		return newOAuthError(oauthErrorInvalidRequest, "code_challenge is required")
+	}
+
+	if query.Get(paramCodeChallengeMethod) != codeChallengeMethodS256 {
+		return newOAuthError(oauthErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+
+	return nil
+}
+
+func (s *security) createAuthorizationCode(claims *jwtClaims, authReq *authorizationRequest) (string, error) {
+	code, err := generateOpaqueToken()
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	err = s.authorizationCodeStore.SaveAuthorizationCode(&AuthorizationCode{
+		CodeHash:      hashOpaqueToken(code),
+		ClientID:      authReq.client.ID,
+		UserID:        claims.UserID,
+		RedirectURI:   authReq.redirectURI,
+		Scope:         authReq.scope,
+		CodeChallenge: authReq.codeChallenge,
+		Nonce:         authReq.nonce,
+		AuthTime:      time.Unix(claims.AuthTime, 0),
+		AMR:           claims.AMR,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(authorizationCodeLifetime),
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return code, nil
+}
+
+func (s *security) tokenRequest(r *http.Request) (*oauthTokenResponse, error) {
+	err := r.ParseForm()
+
+	if err != nil {
+		return nil, newOAuthError(oauthErrorInvalidRequest, "malformed request")
+	}
+
+	client, err := s.authenticateClient(r)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var authN *authentication
+
+	switch r.PostForm.Get(paramGrantType) {
+	case grantTypeAuthorizationCode:
+		authN, err = s.exchangeAuthorizationCode(r, client)
+	case grantTypeRefreshToken:
+		authN, err = s.refreshRequest(r, client.ID)
+
+		if err != nil {
+			err = newOAuthError(oauthErrorInvalidGrant, "invalid refresh token")
+		}
+	case grantTypeClientCredentials:
+		authN, err = s.clientCredentialsRequest(r, client)
+	default:
+		err = newOAuthError(oauthErrorUnsupportedGrantType, "")
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	response := &oauthTokenResponse{
+		AccessToken: authN.jwtToken.signedToken,
+		TokenType:   tokenTypeBearer,
+		ExpiresIn:   int64(accessTokenLifetime.Seconds()),
+		Scope:       authN.jwtToken.claims.Scope,
+		IDToken:     authN.idToken,
+	}
+
+	if authN.refreshToken != nil {
+		response.RefreshToken = authN.refreshToken.value
+	}
+
+	return response, nil
+}
+
+// authenticateClient accepts HTTP Basic authentication, credentials in the form or a
+// signed client assertion. Public clients only identify themselves.
+func (s *security) authenticateClient(r *http.Request) (*Client, error) {
+	clientID, secret, ok := r.BasicAuth()
+	assertion := r.PostForm.Get(paramClientAssertion)
+
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get(paramClientID)
+		secret = r.PostForm.Get(paramClientSecret)
+	}
+
+	if assertion != "" {
+		return s.authenticateClientAssertion(r, clientID, assertion)
+	}
+
+	client, err := s.clients.FindClientByID(clientID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if client == nil {
+		return nil, newOAuthError(oauthErrorInvalidClient, "unknown client")
+	}
+
+	if client.public() {
+		if secret != "" {
+			return nil, newOAuthError(oauthErrorInvalidClient, "public clients have no secret")
+		}
+
+		return client, nil
+	}
+
+	if client.SecretHash == "" {
+		return nil, newOAuthError(oauthErrorInvalidClient, "invalid client credentials")
+	}
+
+	ok, _, err = s.passwords.verify(client.SecretHash, secret)
+
+	if err != nil || !ok {
+		return nil, newOAuthError(oauthErrorInvalidClient, "invalid client credentials")
+	}
+
+	return client, nil
+}
+
+// authenticateConfidentialClient only accepts clients that can prove who they are, which
+// leaves out public clients.
+func (s *security) authenticateConfidentialClient(r *http.Request) (*Client, error) {
+	client, err := s.authenticateClient(r)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if client.public() {
+		return nil, newOAuthError(oauthErrorInvalidClient, "client authentication is required")
+	}
+
+	return client, nil
+}
+
+// authenticateClientAssertion identifies the client by the subject of the assertion when
+// the client_id is left out.
+func (s *security) authenticateClientAssertion(r *http.Request, clientID, assertion string) (*Client, error) {
+	if r.PostForm.Get(paramClientAssertionType) != clientAssertionTypeJWTBearer {
+		return nil, newOAuthError(oauthErrorInvalidClient, "unsupported client_assertion_type")
+	}
+
+	if clientID == "" {
+		claims := &jwt.StandardClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(assertion, claims)
+
+		if err != nil {
+			return nil, newOAuthError(oauthErrorInvalidClient, "malformed client assertion")
+		}
+
+		clientID = claims.Subject
+	}
+
+	client, err := s.clients.FindClientByID(clientID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if client == nil || client.PublicKey == "" {
+		return nil, newOAuthError(oauthErrorInvalidClient, "unknown client")
+	}
+
+	if s.verifyClientAssertion(client, assertion) != nil {
+		return nil, newOAuthError(oauthErrorInvalidClient, "invalid client assertion")
+	}
+
+	return client, nil
+}
+
+func (s *security) exchangeAuthorizationCode(r *http.Request, client *Client) (*authentication, error) {
+	code, err := s.authorizationCodeStore.ConsumeAuthorizationCode(hashOpaqueToken(r.PostForm.Get(paramCode)))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	switch {
+	case code == nil, !code.ExpiresAt.After(TimeFunc()):
+		return nil, newOAuthError(oauthErrorInvalidGrant, "invalid authorization code")
+	case code.ClientID != client.ID:
+		return nil, newOAuthError(oauthErrorInvalidGrant, "authorization code was issued to another client")
+	case code.RedirectURI != r.PostForm.Get(paramRedirectURI):
+		return nil, newOAuthError(oauthErrorInvalidGrant, "redirect_uri does not match")
+	case !verifyCodeChallenge(code.CodeChallenge, r.PostForm.Get(paramCodeVerifier)):
+		return nil, newOAuthError(oauthErrorInvalidGrant, "invalid code_verifier")
+	}
+
+	subj, err := s.FindSubjectByID(code.UserID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, newOAuthError(oauthErrorInvalidGrant, "user is not active")
+	}
+
+	return s.createAuthentication(subj, &grant{
+		clientID:  client.ID,
+		scope:     code.Scope,
+		authTime:  code.AuthTime,
+		amr:       code.AMR,
+		nonce:     code.Nonce,
+		userAgent: r.UserAgent(),
+		ipAddress: s.clientIP(r),
+	})
+}
+
+func verifyCodeChallenge(codeChallenge, codeVerifier string) bool {
+	if len(codeVerifier) < minCodeVerifierLength || len(codeVerifier) > maxCodeVerifierLength {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(codeChallenge)) == 1
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, target string, params url.Values) {
+	u, err := url.Parse(target)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// safeRedirect only allows redirects to paths on this server, and to the web URLs of the
+// hosts in AUTHGO_REDIRECT_HOSTS.
+func (s *security) safeRedirect(target string) string {
+	if len(target) > 0 && target[0] == '/' && (len(target) == 1 || (target[1] != '/' && target[1] != '\\')) {
+		return target
+	}
+
+	u, err := url.Parse(target)
+
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil || !containsAny(s.redirectHosts, []string{strings.ToLower(u.Host)}) {
+		return "/"
+	}
+
+	return u.String()
+}
@@ -0,0 +1,230 @@
+package security_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type clients map[string]*Client
+
+func (c clients) FindClientByID(id string) (*Client, error) {
+	return c[id], nil
+}
+
+var _ = Describe("OAuth", func() {
+
+	const (
+		clientID     = "ce274fd4-5803-11e8-8879-afa0dd22785e"
+		redirectURI  = "https://app.eies.land/callback"
+		codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	)
+
+	var (
+		security = New(
+			subjects{newSubject("ce274fd4-5803-11e8-8879-afa0dd22785d", "erik@eies.land", "secret")},
+			WithClientFinder(clients{clientID: {ID: clientID, Name: "app", RedirectURIs: []string{redirectURI}}}),
+		)
+		tokenCookie *http.Cookie
+	)
+
+	codeChallenge := func(verifier string) string {
+		sum := sha256.Sum256([]byte(verifier))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	authorizeWith := func(params url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientID},
+			"redirect_uri":          {redirectURI},
+			"state":                 {"xyz"},
+			"code_challenge":        {codeChallenge(codeVerifier)},
+			"code_challenge_method": {"S256"},
+		}
+
+		for key, values := range params {
+			query[key] = values
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		httpgo.ErrorHandlerFunc(security.GetAuthorize).ServeHTTP(w, r)
+
+		return w
+	}
+
+	authorize := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return authorizeWith(nil, cookies...)
+	}
+
+	exchange := func(code, verifier string) *httptest.ResponseRecorder {
+		return postForm(security.PostToken, "/oauth/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"redirect_uri":  {redirectURI},
+			"code":          {code},
+			"code_verifier": {verifier},
+		})
+	}
+
+	BeforeEach(func() {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		tokenCookie = findCookie(w, "authgo_token")
+	})
+
+	It("should send users without a session to the login page", func() {
+		w := authorize()
+
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(HavePrefix("/login?rd="))
+	})
+
+	It("should exchange an authorization code once", func() {
+		w := authorize(tokenCookie)
+
+		Expect(w.Code).To(Equal(http.StatusFound))
+
+		location, err := url.Parse(w.Header().Get("Location"))
+
+		Expect(err).To(BeNil())
+		Expect(location.Query().Get("state")).To(Equal("xyz"))
+
+		code := location.Query().Get("code")
+
+		w = exchange(code, codeVerifier)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var response map[string]interface{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response).To(HaveKeyWithValue("token_type", "Bearer"))
+		Expect(response).To(HaveKey("access_token"))
+		Expect(response).To(HaveKey("refresh_token"))
+
+		w = exchange(code, codeVerifier)
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("invalid_grant"))
+	})
+
+	It("should issue an ID token for the openid scope", func() {
+		w := authorizeWith(url.Values{"scope": {"openid email"}, "nonce": {"n-0S6_WzA2Mj"}}, tokenCookie)
+		location, err := url.Parse(w.Header().Get("Location"))
+
+		Expect(err).To(BeNil())
+
+		w = exchange(location.Query().Get("code"), codeVerifier)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var response struct {
+			AccessToken string `json:"access_token"`
+			IDToken     string `json:"id_token"`
+		}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+
+		parts := strings.Split(response.IDToken, ".")
+
+		Expect(parts).To(HaveLen(3))
+
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+		Expect(err).To(BeNil())
+
+		var claims map[string]interface{}
+
+		Expect(json.Unmarshal(payload, &claims)).To(Succeed())
+		Expect(claims).To(HaveKeyWithValue("iss", "http://localhost:3000"))
+		Expect(claims).To(HaveKeyWithValue("aud", clientID))
+		Expect(claims).To(HaveKeyWithValue("nonce", "n-0S6_WzA2Mj"))
+		Expect(claims).To(HaveKeyWithValue("email", "erik@eies.land"))
+		Expect(claims).To(HaveKey("auth_time"))
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		r.Header.Set("Authorization", "Bearer "+response.AccessToken)
+
+		httpgo.ErrorHandlerFunc(security.GetUserInfo).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`"sub":"ce274fd4-5803-11e8-8879-afa0dd22785d"`))
+
+		r = httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+		r.Header.Set("Authorization", "Bearer "+response.IDToken)
+		w = httptest.NewRecorder()
+
+		httpgo.ErrorHandlerFunc(security.GetUserInfo).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should require an absolute https issuer", func() {
+		defer os.Unsetenv("AUTHGO_ISSUER")
+
+		Expect(security.CheckIssuer()).NotTo(Succeed())
+
+		for _, issuer := range []string{"authgo", "http://auth.eies.land", "https://", "https://auth.eies.land?x=1"} {
+			os.Setenv("AUTHGO_ISSUER", issuer)
+			Expect(security.CheckIssuer()).NotTo(Succeed())
+		}
+
+		os.Setenv("AUTHGO_ISSUER", "https://auth.eies.land")
+		Expect(security.CheckIssuer()).To(Succeed())
+	})
+
+	It("should exchange a code for a public client of another origin", func() {
+		location, err := url.Parse(authorize(tokenCookie).Header().Get("Location"))
+
+		Expect(err).To(BeNil())
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"redirect_uri":  {redirectURI},
+			"code":          {location.Query().Get("code")},
+			"code_verifier": {codeVerifier},
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Origin", "https://app.eies.land")
+
+		httpgo.ErrorHandlerFunc(security.PostToken).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("refresh_token"))
+	})
+
+	It("should reject a wrong code verifier", func() {
+		location, err := url.Parse(authorize(tokenCookie).Header().Get("Location"))
+
+		Expect(err).To(BeNil())
+
+		w := exchange(location.Query().Get("code"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,190 @@
+package security
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	environmentIssuer = "AUTHGO_ISSUER"
+	defaultIssuer     = "http://localhost:3000"
+	scopeOpenID       = "openid"
+	scopeProfile      = "profile"
+	scopeEmail        = "email"
+	paramNonce        = "nonce"
+)
+
+type idTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Audience  string   `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	AuthTime  int64    `json:"auth_time,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	ACR       string   `json:"acr,omitempty"`
+	Nonce     string   `json:"nonce,omitempty"`
+	*userInfo
+}
+
+// Valid implements jwt.Claims. ID tokens are only issued here, never accepted.
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+type userInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func (s *security) GetOpenIDConfiguration(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set(headerCacheControl, jwksCacheControl)
+
+	return httpgo.WriteJSON(w, http.StatusOK, &openIDConfiguration{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/oauth/authorize",
+		TokenEndpoint:                     s.issuer + tokenPath,
+		UserInfoEndpoint:                  s.issuer + "/userinfo",
+		IntrospectionEndpoint:             s.issuer + introspectionPath,
+		JWKSURI:                           s.issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{scopeOpenID, scopeProfile, scopeEmail},
+		ResponseTypesSupported:            []string{responseTypeCode},
+		GrantTypesSupported:               []string{grantTypeAuthorizationCode, grantTypeRefreshToken, grantTypeClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.keys.algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "name", "given_name", "family_name"},
+	})
+}
+
+// GetUserInfo returns the claims of the user the access token was issued for, limited to
+// the scopes the token was granted.
+func (s *security) GetUserInfo(w http.ResponseWriter, r *http.Request) error {
+	authZ, err := s.authorizeRequest(r)
+
+	if err != nil {
+		w.Header().Set(headerWWWAuthenticate, bearerChallenge+`, error="invalid_token"`)
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+	}
+
+	if !hasScope(authZ.jwtClaims.Scope, scopeOpenID) {
+		w.Header().Set(headerWWWAuthenticate, bearerChallenge+`, error="insufficient_scope"`)
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, errors.New("authgo: openid scope required")))
+	}
+
+	subj, err := s.FindSubjectByID(authZ.jwtClaims.UserID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, errors.New("authgo: user is not active")))
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, newUserInfo(subj, authZ.jwtClaims.Scope))
+}
+
+func (s *security) createIDToken(subj Subject, g *grant) (string, error) {
+	now := TimeFunc()
+	claims := &idTokenClaims{
+		Issuer:    s.issuer,
+		Audience:  g.clientID,
+		ExpiresAt: now.Add(accessTokenLifetime).Unix(),
+		IssuedAt:  now.Unix(),
+		AuthTime:  g.authTime.Unix(),
+		AMR:       g.amr,
+		ACR:       acrOf(g.amr),
+		Nonce:     g.nonce,
+		userInfo:  newUserInfo(subj, g.scope),
+	}
+
+	signedToken, err := s.signToken(claims)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return signedToken, nil
+}
+
+func newUserInfo(subj Subject, scope string) *userInfo {
+	info := &userInfo{Subject: subj.UserID()}
+
+	if hasScope(scope, scopeEmail) {
+		emailVerified := subj.UserEmailVerified()
+		info.Email = subj.UserEmail()
+		info.EmailVerified = &emailVerified
+	}
+
+	if hasScope(scope, scopeProfile) {
+		info.GivenName = subj.UserFirstName()
+		info.FamilyName = subj.UserLastName()
+		info.Name = strings.TrimSpace(subj.UserFirstName() + " " + subj.UserLastName())
+	}
+
+	return info
+}
+
+func hasScope(scope, wanted string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == wanted {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	errInvalidIssuer = errors.New("authgo: AUTHGO_ISSUER must be an absolute https URL")
+)
+
+// CheckIssuer fails unless AUTHGO_ISSUER is an absolute https URL. The issuer is the base of
+// every endpoint in the discovery document, so the server should not start without it. The
+// local default is only meant for development and tests.
+func (s *security) CheckIssuer() error {
+	u, err := url.Parse(os.Getenv(environmentIssuer))
+
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return errInvalidIssuer
+	}
+
+	return nil
+}
+
+func resolveIssuer() string {
+	if issuer, ok := os.LookupEnv(environmentIssuer); ok && issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+
+	return defaultIssuer
+}
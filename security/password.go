@@ -1,16 +1,461 @@
 package security
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	environmentPasswordHasher = "AUTHGO_PASSWORD_HASHER"
+	environmentPasswordPepper = "AUTHGO_PASSWORD_PEPPER"
+	passwordHasherArgon2id    = "argon2id"
+	passwordHasherScrypt      = "scrypt"
+	passwordHasherBcrypt      = "bcrypt"
+	pepperPrefix              = "$pepper$"
+	passwordSaltSize          = 16
+	passwordKeySize           = 32
+	defaultArgon2idMemory     = 19 * 1024
+	defaultArgon2idTime       = 2
+	defaultArgon2idThreads    = 1
+	defaultScryptLogN         = 17
+	defaultScryptR            = 8
+	defaultScryptP            = 1
+	defaultBcryptCost         = 12
+)
+
+var (
+	errUnsupportedPasswordHash = errors.New("authgo: unsupported password hash")
+	passwordHashEncoding       = base64.RawStdEncoding
 )
 
-func validateHashedPassword(hashedPassword, password string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return errors.WithStack(err)
+// PasswordHasher hashes passwords into a self-describing format, which holds the algorithm
+// and its parameters, so that hashes made with other parameters can still be verified.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// Identifies reports whether the hash is in the format of the hasher.
+	Identifies(hash string) bool
+	// NeedsRehash reports whether the hash was made with other parameters than the hasher's.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idHasher hashes in the PHC string format, $argon2id$v=19$m=<KiB>,t=<time>,p=<threads>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := newPasswordSalt()
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, passwordKeySize)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", passwordHasherArgon2id, argon2.Version, h.Memory, h.Time, h.Threads,
+		passwordHashEncoding.EncodeToString(salt), passwordHashEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := h.parse(hash)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (h *Argon2idHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$"+passwordHasherArgon2id+"$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := h.parse(hash)
+	return err != nil || *params != *h
+}
+
+func (h *Argon2idHasher) parse(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 || parts[1] != passwordHasherArgon2id || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, nil, nil, errUnsupportedPasswordHash
+	}
+
+	params := &Argon2idHasher{}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+
+	if err != nil || params.Time == 0 || params.Threads == 0 {
+		return nil, nil, nil, errUnsupportedPasswordHash
+	}
+
+	salt, key, err := decodeSaltAndKey(parts[4], parts[5])
+
+	if err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	return params, salt, key, nil
+}
+
+// ScryptHasher hashes in the format $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key>.
+type ScryptHasher struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := newPasswordSalt()
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, passwordKeySize)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", passwordHasherScrypt, h.LogN, h.R, h.P,
+		passwordHashEncoding.EncodeToString(salt), passwordHashEncoding.EncodeToString(key)), nil
+}
+
+func (h *ScryptHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := h.parse(hash)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	computed, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, len(key))
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (h *ScryptHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$"+passwordHasherScrypt+"$")
+}
+
+func (h *ScryptHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := h.parse(hash)
+	return err != nil || *params != *h
+}
+
+func (h *ScryptHasher) parse(hash string) (*ScryptHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 5 || parts[1] != passwordHasherScrypt {
+		return nil, nil, nil, errUnsupportedPasswordHash
+	}
+
+	params := &ScryptHasher{}
+
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P)
+
+	if err != nil || params.LogN == 0 || params.LogN > 30 || params.R <= 0 || params.P <= 0 {
+		return nil, nil, nil, errUnsupportedPasswordHash
+	}
+
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+
+	if err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes in the modular crypt format of bcrypt, $2a$<cost>$<salt and key>.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+func (h *BcryptHasher) Identifies(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
 }
 
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// passwordHashing makes new hashes with the configured hasher, and verifies hashes of any
+// of the supported hashers. With a pepper, the password is replaced by its HMAC before it is
+// hashed, and the hash is marked with an id of the pepper.
+type passwordHashing struct {
+	hasher    PasswordHasher
+	pepper    []byte
+	pepperID  string
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func (p *passwordHashing) hash(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("authgo: password is empty")
+	}
+
+	if p.pepper == nil {
+		return p.hasher.Hash(password)
+	}
+
+	hash, err := p.hasher.Hash(p.peppered(password))
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return pepperPrefix + "k=" + p.pepperID + hash, nil
+}
+
+// verify reports whether the password matches the hash, and whether the hash should be
+// replaced, because it was made with another hasher, other parameters or another pepper.
+func (p *passwordHashing) verify(hash, password string) (bool, bool, error) {
+	inner := hash
+	peppered := strings.HasPrefix(hash, pepperPrefix)
+
+	if peppered {
+		i := strings.Index(hash[len(pepperPrefix):], "$")
+
+		if i < 0 {
+			return false, false, errUnsupportedPasswordHash
+		}
+
+		if p.pepper == nil || hash[len(pepperPrefix):len(pepperPrefix)+i] != "k="+p.pepperID {
+			return false, false, errors.New("authgo: password hash was made with an unknown pepper")
+		}
+
+		inner = hash[len(pepperPrefix)+i:]
+		password = p.peppered(password)
+	}
+
+	hasher := p.hasherFor(inner)
+
+	if hasher == nil {
+		return false, false, errUnsupportedPasswordHash
+	}
+
+	ok, err := hasher.Verify(inner, password)
+
+	if err != nil || !ok {
+		return false, false, errors.WithStack(err)
+	}
+
+	rehash := !p.hasher.Identifies(inner) || p.hasher.NeedsRehash(inner) || peppered != (p.pepper != nil)
+
+	return true, rehash, nil
+}
+
+// dummyHash is a hash of a random password, made like the hashes of new passwords. Logins
+// of unknown users are verified against it, so that they take as long as the others.
+func (p *passwordHashing) dummyHash() string {
+	p.dummyOnce.Do(func() {
+		password, err := generateOpaqueToken()
+
+		if err == nil {
+			p.dummy, _ = p.hash(password)
+		}
+	})
+
+	return p.dummy
+}
+
+func (p *passwordHashing) hasherFor(hash string) PasswordHasher {
+	for _, hasher := range []PasswordHasher{p.hasher, &Argon2idHasher{}, &ScryptHasher{}, &BcryptHasher{}} {
+		if hasher.Identifies(hash) {
+			return hasher
+		}
+	}
+
+	return nil
+}
+
+func (p *passwordHashing) peppered(password string) string {
+	mac := hmac.New(sha256.New, p.pepper)
+	mac.Write([]byte(password))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rehashPassword replaces the stored hash of the subject with one made by the current hasher
+// and pepper. Nothing is stored without a password updater.
+func (s *security) rehashPassword(subj Subject, password string) error {
+	if s.passwordUpdater == nil {
+		return nil
+	}
+
+	hashedPassword, err := s.passwords.hash(password)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.passwordUpdater.UpdateUserPassword(subj.UserID(), hashedPassword)
+}
+
+// GenerateHashedPassword hashes the password with the hasher configured by
+// AUTHGO_PASSWORD_HASHER, and the pepper configured by AUTHGO_PASSWORD_PEPPER.
 func GenerateHashedPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(hashedPassword), errors.WithStack(err)
+	return resolvePasswordHashing().hash(password)
+}
+
+// resolvePasswordHashing reads the hasher from the environment, as its name, optionally
+// followed by parameters, such as "argon2id:m=65536,t=3,p=2" or "bcrypt:cost=14". It falls
+// back to argon2id when the value is not valid.
+func resolvePasswordHashing() *passwordHashing {
+	p := &passwordHashing{}
+	p.hasher, _ = parsePasswordHasher(os.Getenv(environmentPasswordHasher))
+
+	if p.hasher == nil {
+		p.hasher, _ = parsePasswordHasher(passwordHasherArgon2id)
+	}
+
+	if pepper, ok := os.LookupEnv(environmentPasswordPepper); ok && pepper != "" {
+		sum := sha256.Sum256([]byte(pepper))
+		p.pepper = []byte(pepper)
+		p.pepperID = hex.EncodeToString(sum[:4])
+	}
+
+	return p
+}
+
+func parsePasswordHasher(value string) (PasswordHasher, error) {
+	name, rawParams := value, ""
+
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		name, rawParams = value[:i], value[i+1:]
+	}
+
+	params := map[string]int{}
+
+	for _, param := range strings.Split(rawParams, ",") {
+		if param == "" {
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+
+		if len(kv) != 2 {
+			return nil, errors.Errorf("authgo: invalid password hasher parameter %q", param)
+		}
+
+		n, err := strconv.Atoi(kv[1])
+
+		if err != nil || n <= 0 {
+			return nil, errors.Errorf("authgo: invalid password hasher parameter %q", param)
+		}
+
+		params[kv[0]] = n
+	}
+
+	param := func(key string, value int) int {
+		if n, ok := params[key]; ok {
+			return n
+		}
+
+		return value
+	}
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case passwordHasherArgon2id:
+		return &Argon2idHasher{
+			Memory:  uint32(param("m", defaultArgon2idMemory)),
+			Time:    uint32(param("t", defaultArgon2idTime)),
+			Threads: uint8(param("p", defaultArgon2idThreads)),
+		}, nil
+	case passwordHasherScrypt:
+		return &ScryptHasher{
+			LogN: uint8(param("ln", defaultScryptLogN)),
+			R:    param("r", defaultScryptR),
+			P:    param("p", defaultScryptP),
+		}, nil
+	case passwordHasherBcrypt:
+		cost := param("cost", defaultBcryptCost)
+
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, errors.Errorf("authgo: invalid bcrypt cost %d", cost)
+		}
+
+		return &BcryptHasher{cost}, nil
+	}
+
+	return nil, errors.Errorf("authgo: unsupported password hasher %q", name)
+}
+
+func newPasswordSalt() ([]byte, error) {
+	salt := make([]byte, passwordSaltSize)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return salt, nil
+}
+
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := passwordHashEncoding.DecodeString(encodedSalt)
+
+	if err != nil {
+		return nil, nil, errUnsupportedPasswordHash
+	}
+
+	key, err := passwordHashEncoding.DecodeString(encodedKey)
+
+	if err != nil || len(key) == 0 {
+		return nil, nil, errUnsupportedPasswordHash
+	}
+
+	return salt, key, nil
 }
@@ -0,0 +1,412 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	environmentPasswordMinLength         = "AUTHGO_PASSWORD_MIN_LENGTH"
+	environmentPasswordMinClasses        = "AUTHGO_PASSWORD_MIN_CLASSES"
+	environmentPasswordMinEntropy        = "AUTHGO_PASSWORD_MIN_ENTROPY"
+	environmentBreachedPasswordsFile     = "AUTHGO_BREACHED_PASSWORDS_FILE"
+	defaultPasswordMinLength             = 10
+	passwordMaxBytes                     = 72
+	minPersonalInformationLength         = 3
+	breachedPasswordPrefixLength         = 5
+	validationErrorCode                  = "VALIDATION_FAILED"
+	fieldPassword                        = "password"
+	formKeyFirstName                     = "firstName"
+	formKeyLastName                      = "lastName"
+	passwordViolationTooShort            = "TOO_SHORT"
+	passwordViolationTooLong             = "TOO_LONG"
+	passwordViolationTooFewClasses       = "TOO_FEW_CHARACTER_CLASSES"
+	passwordViolationTooPredictable      = "TOO_PREDICTABLE"
+	passwordViolationPersonalInformation = "CONTAINS_PERSONAL_INFORMATION"
+	passwordViolationBreached            = "BREACHED"
+)
+
+// Violation is a rule, such as one of the password policy, that the input breaks.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when input is rejected. It carries the violations, which are
+// returned as the extensions of a GraphQL error, or as the body of a REST response.
+type ValidationError struct {
+	Field      string      `json:"field"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+
+	return "authgo: invalid " + e.Field + ": " + strings.Join(messages, ", ")
+}
+
+// Extensions is read by graphql-go, to return the violations along with the error.
+func (e *ValidationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":       validationErrorCode,
+		"field":      e.Field,
+		"violations": e.Violations,
+	}
+}
+
+type validationErrorResponse struct {
+	Error string `json:"error"`
+	*ValidationError
+}
+
+// PasswordOwner is the user a password is chosen by. Their name and email must not be part
+// of the password.
+type PasswordOwner struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+type breachedPasswordStore interface {
+	// FindBreachedPasswordSuffixes returns the suffixes of the breached SHA-1 hashes with the
+	// prefix, so that the full hash of a password is never looked up.
+	FindBreachedPasswordSuffixes(prefix string) ([]string, error)
+	ReplaceBreachedPasswords(hashes []string) error
+}
+
+type memoryBreachedPasswordStore struct {
+	sync.RWMutex
+	suffixes map[string][]string
+}
+
+func (m *memoryBreachedPasswordStore) FindBreachedPasswordSuffixes(prefix string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.suffixes[prefix], nil
+}
+
+func (m *memoryBreachedPasswordStore) ReplaceBreachedPasswords(hashes []string) error {
+	suffixes := make(map[string][]string)
+
+	for _, hash := range hashes {
+		prefix := hash[:breachedPasswordPrefixLength]
+		suffixes[prefix] = append(suffixes[prefix], hash[breachedPasswordPrefixLength:])
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.suffixes = suffixes
+
+	return nil
+}
+
+// passwordPolicy is configured from the environment. Character classes and entropy are not
+// checked unless a minimum is set.
+type passwordPolicy struct {
+	minLength  int
+	minClasses int
+	minEntropy float64
+}
+
+// ValidatePassword checks a new password against the password policy and the breached
+// passwords. All violations are returned in a *ValidationError.
+func (s *security) ValidatePassword(password string, owner PasswordOwner) error {
+	violations := s.passwordPolicy.violations(password, owner)
+	breached, err := s.passwordBreached(password)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if breached {
+		violations = append(violations, Violation{passwordViolationBreached, "password has appeared in a data breach"})
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{fieldPassword, violations}
+	}
+
+	return nil
+}
+
+// CheckPassword validates the password of the form, so that forms can show the violations
+// before they are submitted. It responds with 204, or with 422 and the violations.
+func (s *security) CheckPassword(w http.ResponseWriter, r *http.Request) error {
+	err := r.ParseForm()
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	err = s.ValidatePassword(r.PostForm.Get(formKeyPassword), PasswordOwner{
+		Email:     r.PostForm.Get(formKeyEmail),
+		FirstName: r.PostForm.Get(formKeyFirstName),
+		LastName:  r.PostForm.Get(formKeyLastName),
+	})
+
+	if err != nil {
+		return validationError(w, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// RefreshBreachedPasswords replaces the breached passwords with the hashes in the file at
+// path, or in the file configured by AUTHGO_BREACHED_PASSWORDS_FILE when path is empty. It
+// returns the number of hashes loaded.
+func (s *security) RefreshBreachedPasswords(path string) (int, error) {
+	if path == "" {
+		path = os.Getenv(environmentBreachedPasswordsFile)
+	}
+
+	if path == "" {
+		return 0, errors.New("authgo: no breached passwords file configured")
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	defer f.Close()
+
+	hashes, err := readBreachedPasswords(f)
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	err = s.breachedPasswordStore.ReplaceBreachedPasswords(hashes)
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return len(hashes), nil
+}
+
+func (s *security) passwordBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := s.breachedPasswordStore.FindBreachedPasswordSuffixes(hash[:breachedPasswordPrefixLength])
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, suffix := range suffixes {
+		if suffix == hash[breachedPasswordPrefixLength:] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (p *passwordPolicy) violations(password string, owner PasswordOwner) []Violation {
+	violations := []Violation{}
+
+	if utf8.RuneCountInString(password) < p.minLength {
+		violations = append(violations, Violation{passwordViolationTooShort, fmt.Sprintf("password must be at least %d characters", p.minLength)})
+	}
+
+	if len(password) > passwordMaxBytes {
+		violations = append(violations, Violation{passwordViolationTooLong, fmt.Sprintf("password must be at most %d bytes", passwordMaxBytes)})
+	}
+
+	if p.minClasses > 0 && characterClasses(password) < p.minClasses {
+		violations = append(violations, Violation{passwordViolationTooFewClasses, fmt.Sprintf("password must use at least %d of lower case, upper case, digits and symbols", p.minClasses)})
+	}
+
+	if p.minEntropy > 0 && estimateEntropy(password) < p.minEntropy {
+		violations = append(violations, Violation{passwordViolationTooPredictable, "password is too predictable"})
+	}
+
+	if containsPersonalInformation(password, owner) {
+		violations = append(violations, Violation{passwordViolationPersonalInformation, "password must not contain your name or email"})
+	}
+
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// estimateEntropy is a rough estimate in bits, from the size of the alphabet the password
+// draws from. Repeated characters only count once.
+func estimateEntropy(password string) float64 {
+	var pool float64
+	var lower, upper, digit, other bool
+	var length float64
+	var previous rune
+
+	for i, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+
+		if i == 0 || r != previous {
+			length++
+		}
+
+		previous = r
+	}
+
+	if lower {
+		pool += 26
+	}
+
+	if upper {
+		pool += 26
+	}
+
+	if digit {
+		pool += 10
+	}
+
+	if other {
+		pool += 33
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return length * math.Log2(pool)
+}
+
+func containsPersonalInformation(password string, owner PasswordOwner) bool {
+	password = strings.ToLower(password)
+	local := owner.Email
+
+	if i := strings.LastIndex(local, "@"); i >= 0 {
+		local = local[:i]
+	}
+
+	for _, value := range []string{owner.Email, local, owner.FirstName, owner.LastName} {
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		if utf8.RuneCountInString(value) >= minPersonalInformationLength && strings.Contains(password, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readBreachedPasswords reads SHA-1 hashes, one per line, in upper or lower case hex. As in
+// the downloads of Have I Been Pwned, a count may follow the hash after a colon.
+func readBreachedPasswords(r io.Reader) ([]string, error) {
+	hashes := []string{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if len(line) != hex.EncodedLen(sha1.Size) {
+			return nil, errors.Errorf("authgo: invalid breached password hash %q", line)
+		}
+
+		if _, err := hex.DecodeString(line); err != nil {
+			return nil, errors.Errorf("authgo: invalid breached password hash %q", line)
+		}
+
+		hashes = append(hashes, strings.ToUpper(line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return hashes, nil
+}
+
+// validationError responds with 422 and the violations, other errors are returned as they
+// are.
+func validationError(w http.ResponseWriter, err error) error {
+	validation, ok := errors.Cause(err).(*ValidationError)
+
+	if !ok {
+		return errors.WithStack(err)
+	}
+
+	return httpgo.WriteJSON(w, http.StatusUnprocessableEntity, &validationErrorResponse{validationErrorCode, validation})
+}
+
+func resolvePasswordPolicy() *passwordPolicy {
+	policy := &passwordPolicy{minLength: defaultPasswordMinLength}
+
+	if value, ok := os.LookupEnv(environmentPasswordMinLength); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			policy.minLength = n
+		}
+	}
+
+	if value, ok := os.LookupEnv(environmentPasswordMinClasses); ok {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 && n <= 4 {
+			policy.minClasses = n
+		}
+	}
+
+	if value, ok := os.LookupEnv(environmentPasswordMinEntropy); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f >= 0 {
+			policy.minEntropy = f
+		}
+	}
+
+	return policy
+}
@@ -0,0 +1,104 @@
+package security_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("PasswordPolicy", func() {
+
+	var (
+		owner = PasswordOwner{Email: "erik@eies.land", FirstName: "Erik", LastName: "Eies"}
+	)
+
+	codes := func(err error) []string {
+		Expect(err).To(BeAssignableToTypeOf(&ValidationError{}))
+
+		var codes []string
+
+		for _, violation := range err.(*ValidationError).Violations {
+			codes = append(codes, violation.Code)
+		}
+
+		return codes
+	}
+
+	It("should reject short passwords and passwords with personal information", func() {
+		security := New(subjects{})
+
+		Expect(codes(security.ValidatePassword("", owner))).To(Equal([]string{"TOO_SHORT"}))
+		Expect(codes(security.ValidatePassword("erik-was-here", owner))).To(Equal([]string{"CONTAINS_PERSONAL_INFORMATION"}))
+		Expect(codes(security.ValidatePassword("short-EIES", owner))).To(Equal([]string{"CONTAINS_PERSONAL_INFORMATION"}))
+		Expect(security.ValidatePassword("correct horse battery staple", owner)).To(Succeed())
+	})
+
+	It("should apply the character class and entropy rules when configured", func() {
+		os.Setenv("AUTHGO_PASSWORD_MIN_CLASSES", "3")
+		os.Setenv("AUTHGO_PASSWORD_MIN_ENTROPY", "50")
+
+		defer os.Unsetenv("AUTHGO_PASSWORD_MIN_CLASSES")
+		defer os.Unsetenv("AUTHGO_PASSWORD_MIN_ENTROPY")
+
+		security := New(subjects{})
+
+		Expect(codes(security.ValidatePassword("aaaaaaaaaaaaaaaa", owner))).To(Equal([]string{"TOO_FEW_CHARACTER_CLASSES", "TOO_PREDICTABLE"}))
+		Expect(security.ValidatePassword("Tr0ub4dor&3-stapl", owner)).To(Succeed())
+	})
+
+	It("should reject breached passwords once the corpus is loaded", func() {
+		dir, err := ioutil.TempDir("", "authgo")
+
+		Expect(err).To(BeNil())
+
+		defer os.RemoveAll(dir)
+
+		// SHA-1 of "correct horse battery staple", as in the downloads of Have I Been Pwned.
+		path := filepath.Join(dir, "breached.txt")
+
+		Expect(ioutil.WriteFile(path, []byte("ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:3\n7c4a8d09ca3762af61e59520943dc26494f8941b\n"), 0600)).To(Succeed())
+
+		security := New(subjects{})
+
+		Expect(security.ValidatePassword("correct horse battery staple", owner)).To(Succeed())
+
+		n, err := security.RefreshBreachedPasswords(path)
+
+		Expect(err).To(BeNil())
+		Expect(n).To(Equal(2))
+		Expect(codes(security.ValidatePassword("correct horse battery staple", owner))).To(Equal([]string{"BREACHED"}))
+	})
+
+	It("should return the violations from the REST endpoint", func() {
+		security := New(subjects{})
+
+		w := postForm(security.CheckPassword, "/password/check", url.Values{"password": {"erik"}, "email": {"erik@eies.land"}})
+
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+
+		body := &struct {
+			Error      string `json:"error"`
+			Field      string `json:"field"`
+			Violations []struct {
+				Code string `json:"code"`
+			} `json:"violations"`
+		}{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), body)).To(Succeed())
+		Expect(body.Error).To(Equal("VALIDATION_FAILED"))
+		Expect(body.Field).To(Equal("password"))
+		Expect(body.Violations).To(HaveLen(2))
+
+		w = postForm(security.CheckPassword, "/password/check", url.Values{"password": {"correct horse battery staple"}})
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+})
@@ -0,0 +1,364 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	passwordResetTokenLifetime  = time.Hour
+	passwordResetPath           = "/password/reset"
+	queryKeyPasswordResetToken  = "token"
+	formKeyPasswordConfirmation = "password_confirmation"
+	eventTypePasswordReset      = "PASSWORD_RESET"
+	passwordResetMailSubject    = "Reset your password"
+	passwordResetMailBody       = `Hello %s,
+
+Someone asked to reset the password of your account. Follow the link below to choose a new
+password. The link works once, and only within the next hour.
+
+%s
+
+If it was not you, ignore this mail. Your password stays the same.
+`
+)
+
+var (
+	errInvalidPasswordResetToken = errors.New("authgo: invalid password reset token")
+	errPasswordsNotUpdatable     = errors.New("authgo: passwords cannot be updated")
+)
+
+// PasswordResetToken is the server-side record of a password reset link. Only the hash of
+// the token is stored.
+type PasswordResetToken struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type passwordResetTokenStore interface {
+	SavePasswordResetToken(token *PasswordResetToken) error
+	FindPasswordResetToken(tokenHash string) (*PasswordResetToken, error)
+	// UsePasswordResetToken marks the token as used, and reports false if it already was.
+	UsePasswordResetToken(id string, usedAt time.Time) (bool, error)
+	DeleteUserPasswordResetTokens(userID string) error
+}
+
+type memoryPasswordResetTokenStore struct {
+	sync.Mutex
+	tokens map[string]*PasswordResetToken
+}
+
+func (m *memoryPasswordResetTokenStore) SavePasswordResetToken(token *PasswordResetToken) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]*PasswordResetToken)
+	}
+
+	copied := *token
+	m.tokens[token.TokenHash] = &copied
+
+	return nil
+}
+
+func (m *memoryPasswordResetTokenStore) FindPasswordResetToken(tokenHash string) (*PasswordResetToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *token
+
+	return &copied, nil
+}
+
+func (m *memoryPasswordResetTokenStore) UsePasswordResetToken(id string, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.ID == id {
+			if token.UsedAt != nil {
+				return false, nil
+			}
+
+			token.UsedAt = &usedAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *memoryPasswordResetTokenStore) DeleteUserPasswordResetTokens(userID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for tokenHash, token := range m.tokens {
+		if token.UserID == userID {
+			delete(m.tokens, tokenHash)
+		}
+	}
+
+	return nil
+}
+
+// RequestPasswordReset mails a link to reset the password to the user with the email. To
+// not reveal which emails belong to users, nothing happens for unknown or inactive users.
+func (s *security) RequestPasswordReset(email string) error {
+	subj, err := s.FindSubjectByEmail(email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Service accounts have no password to reset.
+	if subj == nil || !subj.UserActive() || subj.UserPassword() == "" {
+		return nil
+	}
+
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	value, err := generateOpaqueToken()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	err = s.passwordResetTokenStore.SavePasswordResetToken(&PasswordResetToken{
+		ID:        id.String(),
+		UserID:    subj.UserID(),
+		TokenHash: hashOpaqueToken(value),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTokenLifetime),
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	link := s.webAuthn.origin + passwordResetPath + "?" + url.Values{queryKeyPasswordResetToken: {value}}.Encode()
+
+	return s.mailer.SendMail(&Mail{
+		To:      subj.UserEmail(),
+		Subject: passwordResetMailSubject,
+		Body:    fmt.Sprintf(passwordResetMailBody, subj.UserFirstName(), link),
+	})
+}
+
+// ResetPassword sets the password of the user the token was issued to, and uses the token
+// up. The password must pass the password policy. Every session of the user is revoked, and
+// a lockout of the account is lifted, as the user has proven they own the email.
+func (s *security) ResetPassword(ctx context.Context, token, password string) error {
+	record, subj, err := s.findPasswordResetToken(token)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.ValidatePassword(password, PasswordOwner{
+		Email:     subj.UserEmail(),
+		FirstName: subj.UserFirstName(),
+		LastName:  subj.UserLastName(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if s.passwordUpdater == nil {
+		return errPasswordsNotUpdatable
+	}
+
+	used, err := s.passwordResetTokenStore.UsePasswordResetToken(record.ID, TimeFunc())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !used {
+		return errInvalidPasswordResetToken
+	}
+
+	hashedPassword, err := s.passwords.hash(password)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.passwordUpdater.UpdateUserPassword(subj.UserID(), hashedPassword)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.RevokeUserTokens(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.passwordResetTokenStore.DeleteUserPasswordResetTokens(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.UnlockUser(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.events.RecordEvent(ctx, subj.UserID(), eventTypePasswordReset, "Password reset with a link sent by mail.")
+}
+
+// findPasswordResetToken returns the token and its user, as long as the token can still be
+// used.
+func (s *security) findPasswordResetToken(token string) (*PasswordResetToken, Subject, error) {
+	if token == "" {
+		return nil, nil, errInvalidPasswordResetToken
+	}
+
+	record, err := s.passwordResetTokenStore.FindPasswordResetToken(hashOpaqueToken(token))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if record == nil || record.UsedAt != nil || !record.ExpiresAt.After(TimeFunc()) {
+		return nil, nil, errInvalidPasswordResetToken
+	}
+
+	subj, err := s.FindSubjectByID(record.UserID)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, nil, errInvalidPasswordResetToken
+	}
+
+	return record, subj, nil
+}
+
+type passwordForgotData struct {
+	Sent bool
+}
+
+func GetPasswordForgot(w http.ResponseWriter, r *http.Request) error {
+	return renderPasswordForgot(w, r, &passwordForgotData{})
+}
+
+// PostPasswordForgot mails the reset link. The response is the same whether or not the
+// email belongs to a user.
+func (s *security) PostPasswordForgot(w http.ResponseWriter, r *http.Request) error {
+	err := s.RequestPasswordReset(r.PostFormValue(formKeyEmail))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderPasswordForgot(w, r, &passwordForgotData{Sent: true})
+}
+
+func renderPasswordForgot(w http.ResponseWriter, r *http.Request, data *passwordForgotData) error {
+	tmpl, err := ParseTemplate(r, "./templates/password_forgot.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+type passwordResetData struct {
+	Token      string
+	Invalid    bool
+	Mismatch   bool
+	Violations []Violation
+	Done       bool
+}
+
+func (s *security) GetPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get(queryKeyPasswordResetToken)
+	_, _, err := s.findPasswordResetToken(token)
+
+	if errors.Cause(err) == errInvalidPasswordResetToken {
+		w.WriteHeader(http.StatusBadRequest)
+		return renderPasswordReset(w, r, &passwordResetData{Invalid: true})
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderPasswordReset(w, r, &passwordResetData{Token: token})
+}
+
+// PostPasswordReset sets the new password of the form. The browser is logged out, as the
+// reset revoked its session too.
+func (s *security) PostPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	token := r.PostFormValue(queryKeyPasswordResetToken)
+	password := r.PostFormValue(formKeyPassword)
+
+	if password != r.PostFormValue(formKeyPasswordConfirmation) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return renderPasswordReset(w, r, &passwordResetData{Token: token, Mismatch: true})
+	}
+
+	err := s.ResetPassword(r.Context(), token, password)
+
+	if validation, ok := errors.Cause(err).(*ValidationError); ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return renderPasswordReset(w, r, &passwordResetData{Token: token, Violations: validation.Violations})
+	}
+
+	if errors.Cause(err) == errInvalidPasswordResetToken {
+		w.WriteHeader(http.StatusBadRequest)
+		return renderPasswordReset(w, r, &passwordResetData{Invalid: true})
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	http.SetCookie(w, logoutCookie)
+	http.SetCookie(w, logoutRefreshCookie)
+
+	return renderPasswordReset(w, r, &passwordResetData{Done: true})
+}
+
+func renderPasswordReset(w http.ResponseWriter, r *http.Request, data *passwordResetData) error {
+	tmpl, err := ParseTemplate(r, "./templates/password_reset.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, data)
+}
@@ -0,0 +1,127 @@
+package security_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type outbox []*Mail
+
+func (o *outbox) SendMail(mail *Mail) error {
+	*o = append(*o, mail)
+	return nil
+}
+
+func (s subjects) UpdateUserPassword(userID, hashedPassword string) error {
+	for _, subj := range s {
+		if subj.id == userID {
+			subj.password = hashedPassword
+		}
+	}
+
+	return nil
+}
+
+var _ = Describe("PasswordReset", func() {
+
+	const (
+		userID      = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+		newPassword = "correct horse battery staple"
+	)
+
+	var (
+		now      time.Time
+		mails    *outbox
+		events   *eventLog
+		subjs    subjects
+		security interface {
+			RequestPasswordReset(email string) error
+			ResetPassword(ctx context.Context, token, password string) error
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			RefreshToken(w http.ResponseWriter, r *http.Request) error
+		}
+	)
+
+	tokenFromMail := func(mail *Mail) string {
+		link := regexp.MustCompile(`https?://\S+`).FindString(mail.Body)
+		u, err := url.Parse(link)
+
+		Expect(err).To(BeNil())
+		Expect(u.Path).To(Equal("/password/reset"))
+
+		return u.Query().Get("token")
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		TimeFunc = func() time.Time {
+			return now
+		}
+		mails = &outbox{}
+		events = &eventLog{}
+		subjs = subjects{newSubject(userID, "erik@eies.land", "secret")}
+		security = New(subjs, WithMailer(mails), WithEventRecorder(events), WithPasswordUpdater(subjs))
+	})
+
+	AfterEach(func() {
+		TimeFunc = time.Now
+	})
+
+	It("should reset the password once with the mailed link, and end every session", func() {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		response := &struct {
+			RefreshToken string `json:"refresh_token"`
+		}{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), response)).To(Succeed())
+		Expect(security.RequestPasswordReset("erik@eies.land")).To(Succeed())
+		Expect(*mails).To(HaveLen(1))
+		Expect((*mails)[0].To).To(Equal("erik@eies.land"))
+
+		token := tokenFromMail((*mails)[0])
+
+		By("rejecting a password that breaks the policy")
+
+		Expect(security.ResetPassword(context.Background(), token, "erik")).To(BeAssignableToTypeOf(&ValidationError{}))
+
+		By("accepting a password that passes it")
+
+		Expect(security.ResetPassword(context.Background(), token, newPassword)).To(Succeed())
+		Expect(*events).To(Equal(eventLog{{userID, "PASSWORD_RESET"}}))
+		Expect(security.ResetPassword(context.Background(), token, newPassword+"!")).NotTo(Succeed())
+
+		w = postForm(security.RefreshToken, "/token/refresh", url.Values{"refresh_token": {response.RefreshToken}})
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {newPassword}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not send mail for unknown emails", func() {
+		Expect(security.RequestPasswordReset("nobody@eies.land")).To(Succeed())
+		Expect(*mails).To(BeEmpty())
+	})
+
+	It("should reject expired links", func() {
+		Expect(security.RequestPasswordReset("erik@eies.land")).To(Succeed())
+
+		now = now.Add(time.Hour)
+
+		Expect(security.ResetPassword(context.Background(), tokenFromMail((*mails)[0]), newPassword)).NotTo(Succeed())
+		Expect(security.ResetPassword(context.Background(), "", newPassword)).NotTo(Succeed())
+	})
+})
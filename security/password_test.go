@@ -0,0 +1,105 @@
+package security_test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type passwordUpdates map[string]string
+
+func (u passwordUpdates) UpdateUserPassword(userID, hashedPassword string) error {
+	u[userID] = hashedPassword
+	return nil
+}
+
+var _ = Describe("PasswordHasher", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	hashers := map[string]PasswordHasher{
+		"argon2id": &Argon2idHasher{Memory: 1024, Time: 1, Threads: 1},
+		"scrypt":   &ScryptHasher{LogN: 10, R: 8, P: 1},
+		"bcrypt":   &BcryptHasher{Cost: 4},
+	}
+
+	It("should hash and verify passwords with every hasher", func() {
+		for name, hasher := range hashers {
+			hash, err := hasher.Hash("secret")
+
+			Expect(err).To(BeNil())
+			Expect(hasher.Identifies(hash)).To(BeTrue(), name)
+			Expect(hasher.NeedsRehash(hash)).To(BeFalse(), name)
+			Expect(hasher.Verify(hash, "secret")).To(BeTrue(), name)
+			Expect(hasher.Verify(hash, "wrong")).To(BeFalse(), name)
+
+			for other, otherHasher := range hashers {
+				if other != name {
+					Expect(otherHasher.Identifies(hash)).To(BeFalse(), name)
+				}
+			}
+		}
+	})
+
+	It("should need a rehash when the parameters change", func() {
+		hash, err := (&Argon2idHasher{Memory: 1024, Time: 1, Threads: 1}).Hash("secret")
+
+		Expect(err).To(BeNil())
+		Expect(hash).To(HavePrefix("$argon2id$v=19$m=1024,t=1,p=1$"))
+		Expect((&Argon2idHasher{Memory: 2048, Time: 1, Threads: 1}).NeedsRehash(hash)).To(BeTrue())
+		Expect((&ScryptHasher{LogN: 11, R: 8, P: 1}).NeedsRehash(hash)).To(BeTrue())
+	})
+
+	It("should upgrade the hash of an old algorithm after a successful login", func() {
+		hash, err := (&BcryptHasher{Cost: 4}).Hash("secret")
+
+		Expect(err).To(BeNil())
+
+		subj := &subject{id: userID, email: "erik@eies.land", password: hash}
+		updates := passwordUpdates{}
+		security := New(subjects{subj}, WithPasswordHasher(hashers["argon2id"]), WithPasswordUpdater(updates))
+		credentials := url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+		Expect(updates[userID]).To(HavePrefix("$argon2id$v=19$m=1024,t=1,p=1$"))
+
+		By("not upgrading it again")
+
+		subj.password = updates[userID]
+		delete(updates, userID)
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+		Expect(updates).To(BeEmpty())
+	})
+
+	It("should pepper new hashes, and still accept unpeppered ones", func() {
+		unpeppered := newSubject(userID, "erik@eies.land", "secret")
+
+		os.Setenv("AUTHGO_PASSWORD_PEPPER", "pepper")
+		defer os.Unsetenv("AUTHGO_PASSWORD_PEPPER")
+
+		updates := passwordUpdates{}
+		security := New(subjects{unpeppered}, WithPasswordHasher(hashers["scrypt"]), WithPasswordUpdater(updates))
+		credentials := url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+		Expect(updates[userID]).To(HavePrefix("$pepper$k="))
+		Expect(strings.Contains(updates[userID], "$scrypt$ln=10,r=8,p=1$")).To(BeTrue())
+
+		peppered := &subject{id: userID, email: "erik@eies.land", password: updates[userID]}
+
+		Expect(postForm(New(subjects{peppered}).Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+
+		os.Setenv("AUTHGO_PASSWORD_PEPPER", "another pepper")
+
+		Expect(postForm(New(subjects{peppered}).Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,321 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// personalAccessTokenPrefix tells personal access tokens apart from signed tokens, and
+	// makes them easy to find when they leak.
+	personalAccessTokenPrefix           = "authgo_pat_"
+	personalAccessTokenLifetime         = 90 * 24 * time.Hour
+	maxPersonalAccessTokenLifetime      = 365 * 24 * time.Hour
+	personalAccessTokenTouchInterval    = time.Minute
+	principalTypePersonalAccessToken    = "personal_access_token"
+	eventTypePersonalAccessTokenCreated = "PERSONAL_ACCESS_TOKEN_CREATED"
+	eventTypePersonalAccessTokenUsed    = "PERSONAL_ACCESS_TOKEN_USED"
+)
+
+var (
+	errInvalidPersonalAccessToken = errors.New("authgo: invalid personal access token")
+	errUnknownPersonalAccessToken = errors.New("authgo: unknown personal access token")
+	errPersonalAccessTokenScope   = errors.New("authgo: scopes must be authorities of the user")
+	errPersonalAccessTokenExpiry  = errors.New("authgo: personal access tokens expire within a year")
+	errPersonalAccessTokenName    = errors.New("authgo: personal access tokens need a name")
+)
+
+// PersonalAccessToken is a long-lived token that a user creates for scripts. Only the hash
+// of the token is stored. Its scopes are the authorities it carries, which are a subset of
+// those of the user.
+type PersonalAccessToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+type personalAccessTokenStore interface {
+	SavePersonalAccessToken(token *PersonalAccessToken) error
+	FindPersonalAccessToken(tokenHash string) (*PersonalAccessToken, error)
+	// FindUserPersonalAccessTokens returns the tokens of the user that are neither revoked
+	// nor expired.
+	FindUserPersonalAccessTokens(userID string) ([]*PersonalAccessToken, error)
+	TouchPersonalAccessToken(id string, lastUsedAt time.Time) error
+	// RevokePersonalAccessToken reports whether the user had such a token to revoke.
+	RevokePersonalAccessToken(userID, id string, revokedAt time.Time) (bool, error)
+}
+
+type memoryPersonalAccessTokenStore struct {
+	sync.Mutex
+	tokens map[string]*PersonalAccessToken
+}
+
+func (m *memoryPersonalAccessTokenStore) SavePersonalAccessToken(token *PersonalAccessToken) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]*PersonalAccessToken)
+	}
+
+	copied := *token
+	m.tokens[token.ID] = &copied
+
+	return nil
+}
+
+func (m *memoryPersonalAccessTokenStore) FindPersonalAccessToken(tokenHash string) (*PersonalAccessToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *memoryPersonalAccessTokenStore) FindUserPersonalAccessTokens(userID string) ([]*PersonalAccessToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := TimeFunc()
+	tokens := []*PersonalAccessToken{}
+
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil && token.ExpiresAt.After(now) {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (m *memoryPersonalAccessTokenStore) TouchPersonalAccessToken(id string, lastUsedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if token, ok := m.tokens[id]; ok {
+		token.LastUsedAt = &lastUsedAt
+	}
+
+	return nil
+}
+
+func (m *memoryPersonalAccessTokenStore) RevokePersonalAccessToken(userID, id string, revokedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	token, ok := m.tokens[id]
+
+	if !ok || token.UserID != userID || token.RevokedAt != nil {
+		return false, nil
+	}
+
+	token.RevokedAt = &revokedAt
+
+	return true, nil
+}
+
+// PersonalAccessTokenFromContext reports whether the request was made with a personal
+// access token.
+func PersonalAccessTokenFromContext(ctx context.Context) bool {
+	principalType, _ := ctx.Value(ctxKeyPrincipalType).(string)
+	return principalType == principalTypePersonalAccessToken
+}
+
+// CreatePersonalAccessToken issues a token that carries the scopes, which must be among the
+// authorities of the user. It expires after 90 days unless expiresAt is given, and within a
+// year at the latest. The token is returned only this once.
+func (s *security) CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return "", nil, errPersonalAccessTokenName
+	}
+
+	now := TimeFunc()
+	expires := now.Add(personalAccessTokenLifetime)
+
+	if expiresAt != nil {
+		expires = *expiresAt
+	}
+
+	if !expires.After(now) || expires.Sub(now) > maxPersonalAccessTokenLifetime {
+		return "", nil, errPersonalAccessTokenExpiry
+	}
+
+	authorities, err := s.authorities.FindUserAuthorities(userID)
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	for _, scope := range scopes {
+		if !containsAny(authorities.Authorities, []string{scope}) {
+			return "", nil, errPersonalAccessTokenScope
+		}
+	}
+
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	value, err := generateOpaqueToken()
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	value = personalAccessTokenPrefix + value
+	token := &PersonalAccessToken{
+		ID:        id.String(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashOpaqueToken(value),
+		Scopes:    append([]string{}, scopes...),
+		CreatedAt: now,
+		ExpiresAt: expires,
+	}
+
+	err = s.personalAccessTokenStore.SavePersonalAccessToken(token)
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(ctx, userID, eventTypePersonalAccessTokenCreated, fmt.Sprintf("Personal access token %q created.", name))
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	return value, token, nil
+}
+
+// PersonalAccessTokens returns the active personal access tokens of the user, the newest
+// first.
+func (s *security) PersonalAccessTokens(userID string) ([]*PersonalAccessToken, error) {
+	return s.personalAccessTokenStore.FindUserPersonalAccessTokens(userID)
+}
+
+// RevokePersonalAccessToken revokes a personal access token of the user, which is rejected
+// from then on.
+func (s *security) RevokePersonalAccessToken(userID, id string) error {
+	revoked, err := s.personalAccessTokenStore.RevokePersonalAccessToken(userID, id, TimeFunc())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !revoked {
+		return errUnknownPersonalAccessToken
+	}
+
+	return nil
+}
+
+// validatePersonalAccessToken turns a personal access token into the claims of an access
+// token of its user. The authorities are those of its scopes that the user still has. Its
+// use is recorded at most once a minute.
+func (s *security) validatePersonalAccessToken(ctx context.Context, value string) (*jwtClaims, error) {
+	token, err := s.personalAccessTokenStore.FindPersonalAccessToken(hashOpaqueToken(value))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	if token == nil || token.RevokedAt != nil || !token.ExpiresAt.After(now) {
+		return nil, errInvalidPersonalAccessToken
+	}
+
+	subj, err := s.FindSubjectByID(token.UserID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, errInvalidPersonalAccessToken
+	}
+
+	authorities, err := s.authorities.FindUserAuthorities(token.UserID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var scopes []string
+
+	for _, scope := range token.Scopes {
+		if containsAny(authorities.Authorities, []string{scope}) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	claims := &jwtClaims{
+		StandardClaims: &jwt.StandardClaims{
+			Audience:  jwtAudience,
+			ExpiresAt: token.ExpiresAt.Unix(),
+			Id:        token.ID,
+			IssuedAt:  token.CreatedAt.Unix(),
+			Issuer:    s.issuer,
+			NotBefore: token.CreatedAt.Unix(),
+			Subject:   subj.UserEmail(),
+		},
+		UserID:        token.UserID,
+		PrincipalType: principalTypePersonalAccessToken,
+		Authorities:   scopes,
+		IssuedAtNano:  token.CreatedAt.UnixNano(),
+	}
+
+	err = s.checkRevocation(claims)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < personalAccessTokenTouchInterval {
+		return claims, nil
+	}
+
+	err = s.personalAccessTokenStore.TouchPersonalAccessToken(token.ID, now)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(ctx, token.UserID, eventTypePersonalAccessTokenUsed, fmt.Sprintf("Personal access token %q used.", token.Name))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return claims, nil
+}
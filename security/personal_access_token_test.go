@@ -0,0 +1,113 @@
+package security_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Personal access tokens", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authorize(next http.Handler) http.Handler
+			CreatePersonalAccessToken(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error)
+			PersonalAccessTokens(userID string) ([]*PersonalAccessToken, error)
+			RevokePersonalAccessToken(userID, id string) error
+		}
+		events *eventLog
+	)
+
+	// serve makes a request with the token as a Bearer credential.
+	serve := func(token string) (int, *http.Request) {
+		var served *http.Request
+
+		handler := security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		handler.ServeHTTP(w, r)
+
+		return w.Code, served
+	}
+
+	BeforeEach(func() {
+		events = &eventLog{}
+		security = New(
+			subjects{newSubject(userID, "erik@eies.land", "secret")},
+			WithAuthorityFinder(grants{userID: {Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS", "WRITE_USERS"}}}),
+			WithEventRecorder(events),
+		)
+	})
+
+	It("should authorize requests with the scopes of the token", func() {
+		token, created, err := security.CreatePersonalAccessToken(context.Background(), userID, "scripts", []string{"READ_USERS"}, nil)
+
+		Expect(err).To(BeNil())
+		Expect(token).To(HavePrefix("authgo_pat_"))
+		Expect(created.TokenHash).NotTo(Equal(token))
+
+		code, r := serve(token)
+
+		Expect(code).To(Equal(http.StatusNoContent))
+		Expect(UserIDFromContext(r.Context())).To(Equal(userID))
+		Expect(PersonalAccessTokenFromContext(r.Context())).To(BeTrue())
+		Expect(AuthoritiesFromContext(r.Context())).To(Equal([]string{"READ_USERS"}))
+		Expect(RolesFromContext(r.Context())).To(BeEmpty())
+
+		tokens, err := security.PersonalAccessTokens(userID)
+
+		Expect(err).To(BeNil())
+		Expect(tokens).To(HaveLen(1))
+		Expect(tokens[0].LastUsedAt).NotTo(BeNil())
+		Expect(*events).To(Equal(eventLog{
+			{userID, "PERSONAL_ACCESS_TOKEN_CREATED"},
+			{userID, "PERSONAL_ACCESS_TOKEN_USED"},
+		}))
+	})
+
+	It("should only carry authorities of the user", func() {
+		_, _, err := security.CreatePersonalAccessToken(context.Background(), userID, "scripts", []string{"WRITE_CLIENTS"}, nil)
+
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should expire within a year", func() {
+		expiresAt := time.Now().Add(2 * 365 * 24 * time.Hour)
+
+		_, _, err := security.CreatePersonalAccessToken(context.Background(), userID, "scripts", nil, &expiresAt)
+
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should reject revoked tokens", func() {
+		token, created, err := security.CreatePersonalAccessToken(context.Background(), userID, "scripts", nil, nil)
+
+		Expect(err).To(BeNil())
+		Expect(security.RevokePersonalAccessToken("0c2a4ab6-5804-11e8-8879-afa0dd22785d", created.ID)).NotTo(Succeed())
+		Expect(security.RevokePersonalAccessToken(userID, created.ID)).To(Succeed())
+
+		code, _ := serve(token)
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+
+		code, _ = serve("authgo_pat_unknown")
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+	})
+})
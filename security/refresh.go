@@ -0,0 +1,265 @@
+package security
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	refreshTokenLifetime   = 30 * 24 * time.Hour
+	refreshTokenCookieName = "authgo_refresh_token"
+	formKeyRefreshToken    = "refresh_token"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("authgo: invalid refresh token")
+	errRefreshTokenReused  = errors.New("authgo: refresh token reused, token family revoked")
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Only the hash of the
+// token is stored. Tokens that are rotated from one another share the same FamilyID.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	UserID    string
+	ClientID  string
+	Scope     string
+	AuthTime  time.Time
+	AMR       []string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type refreshTokenStore interface {
+	SaveRefreshToken(token *RefreshToken) error
+	FindRefreshToken(tokenHash string) (*RefreshToken, error)
+	// UseRefreshToken marks the token as used, and reports false if it already was.
+	UseRefreshToken(id string, usedAt time.Time) (bool, error)
+	RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error
+	RevokeUserRefreshTokens(userID string, revokedAt time.Time) error
+}
+
+type memoryRefreshTokenStore struct {
+	sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+func (m *memoryRefreshTokenStore) SaveRefreshToken(token *RefreshToken) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]*RefreshToken)
+	}
+
+	copied := *token
+	m.tokens[token.TokenHash] = &copied
+
+	return nil
+}
+
+func (m *memoryRefreshTokenStore) FindRefreshToken(tokenHash string) (*RefreshToken, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	token, ok := m.tokens[tokenHash]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *token
+
+	return &copied, nil
+}
+
+func (m *memoryRefreshTokenStore) UseRefreshToken(id string, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.ID == id {
+			if token.UsedAt != nil || token.RevokedAt != nil {
+				return false, nil
+			}
+
+			token.UsedAt = &usedAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *memoryRefreshTokenStore) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryRefreshTokenStore) RevokeUserRefreshTokens(userID string, revokedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+
+	return nil
+}
+
+type refreshToken struct {
+	value     string
+	record    *RefreshToken
+	expiresAt time.Time
+}
+
+// createRefreshToken issues a new refresh token in the family of the grant.
+func (s *security) createRefreshToken(subj Subject, g *grant) (*refreshToken, error) {
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	value, err := generateOpaqueToken()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+	record := &RefreshToken{
+		ID:        id.String(),
+		FamilyID:  g.refreshTokenFamilyID,
+		UserID:    subj.UserID(),
+		ClientID:  g.clientID,
+		Scope:     g.scope,
+		AuthTime:  g.authTime,
+		AMR:       g.amr,
+		TokenHash: hashOpaqueToken(value),
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenLifetime),
+	}
+
+	err = s.refreshTokenStore.SaveRefreshToken(record)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &refreshToken{value, record, record.ExpiresAt}, nil
+}
+
+// refreshRequest rotates the presented refresh token of the given client, which is empty
+// for first-party logins. Presenting a token that was already rotated means it has leaked,
+// so the whole family is revoked.
+func (s *security) refreshRequest(r *http.Request, clientID string) (*authentication, error) {
+	value, err := refreshTokenFromRequest(r)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	record, err := s.refreshTokenStore.FindRefreshToken(hashOpaqueToken(value))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	if record == nil || record.ClientID != clientID || record.RevokedAt != nil || !record.ExpiresAt.After(now) {
+		return nil, errInvalidRefreshToken
+	}
+
+	used := false
+
+	if record.UsedAt == nil {
+		used, err = s.refreshTokenStore.UseRefreshToken(record.ID, now)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if !used {
+		err = s.revokeRefreshTokenFamily(record.FamilyID)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return nil, errRefreshTokenReused
+	}
+
+	subj, err := s.FindSubjectByID(record.UserID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, errInvalidRefreshToken
+	}
+
+	return s.createAuthentication(subj, &grant{
+		clientID:             record.ClientID,
+		scope:                record.Scope,
+		refreshTokenFamilyID: record.FamilyID,
+		authTime:             record.AuthTime,
+		amr:                  record.AMR,
+	})
+}
+
+func (s *security) revokeRefreshRequest(r *http.Request) error {
+	value, err := refreshTokenFromRequest(r)
+
+	if err != nil {
+		// Nothing to revoke.
+		return nil
+	}
+
+	record, err := s.refreshTokenStore.FindRefreshToken(hashOpaqueToken(value))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if record == nil {
+		return nil
+	}
+
+	return s.revokeRefreshTokenFamily(record.FamilyID)
+}
+
+func refreshTokenFromRequest(r *http.Request) (string, error) {
+	if value := r.PostFormValue(formKeyRefreshToken); value != "" {
+		return value, nil
+	}
+
+	cookie, err := r.Cookie(refreshTokenCookieName)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return cookie.Value, nil
+}
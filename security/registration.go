@@ -0,0 +1,511 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	environmentRegistrationMode    = "AUTHGO_REGISTRATION_MODE"
+	environmentRegistrationDomains = "AUTHGO_REGISTRATION_DOMAINS"
+	registrationModeClosed         = "closed"
+	registrationModeOpen           = "open"
+	registrationModeInvite         = "invite"
+	registrationModeDomain         = "domain"
+	registrationModeApproval       = "approval"
+	invitationLifetime             = 7 * 24 * time.Hour
+	registerPath                   = "/register"
+	queryKeyInvitation             = "invitation"
+	fieldFirstName                 = "firstName"
+	fieldLastName                  = "lastName"
+	fieldEmail                     = "email"
+	fieldInvitation                = "invitation"
+	violationRequired              = "REQUIRED"
+	violationInvalidEmail          = "INVALID_EMAIL"
+	violationEmailTaken            = "EMAIL_TAKEN"
+	violationDomainNotAllowed      = "DOMAIN_NOT_ALLOWED"
+	violationInvalidInvitation     = "INVALID_INVITATION"
+	eventTypeUserRegistered        = "USER_REGISTERED"
+	headerContentType              = "Content-Type"
+	contentTypeJSON                = "application/json"
+	invitationMailSubject          = "You are invited"
+	invitationMailBody             = `Hello,
+
+You are invited to create an account. Follow the link below to sign up with this email. The
+link works once, and only within the next 7 days.
+
+%s
+`
+)
+
+var (
+	errRegistrationClosed = errors.New("authgo: registration is closed")
+)
+
+// RegistrationInput is the sign up of a new user, from the register form or its JSON
+// endpoint. Invitation is the token of the invitation link, when registration is by
+// invitation only.
+type RegistrationInput struct {
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	Invitation string `json:"invitation"`
+}
+
+// Registration is a user to create for a sign up that was accepted. The password is hashed
+// already. Users that wait for approval are not enabled, and only they can be approved.
+type Registration struct {
+	FirstName       string
+	LastName        string
+	Email           string
+	HashedPassword  string
+	Enabled         bool
+	PendingApproval bool
+	EmailVerified   bool
+}
+
+type userRegistrar interface {
+	// RegisterUser creates the user, with the roles new users get by default, and returns its
+	// id.
+	RegisterUser(registration *Registration) (string, error)
+}
+
+// Invitation is the server-side record of an invitation link. It can only be used to sign
+// up with the email it was sent to. Only the hash of the token is stored.
+type Invitation struct {
+	ID        string
+	Email     string
+	InvitedBy string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type invitationStore interface {
+	SaveInvitation(invitation *Invitation) error
+	FindInvitation(tokenHash string) (*Invitation, error)
+	// UseInvitation marks the invitation as used, and reports false if it already was.
+	UseInvitation(id string, usedAt time.Time) (bool, error)
+}
+
+type memoryInvitationStore struct {
+	sync.Mutex
+	invitations map[string]*Invitation
+}
+
+func (m *memoryInvitationStore) SaveInvitation(invitation *Invitation) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.invitations == nil {
+		m.invitations = make(map[string]*Invitation)
+	}
+
+	copied := *invitation
+	m.invitations[invitation.TokenHash] = &copied
+
+	return nil
+}
+
+func (m *memoryInvitationStore) FindInvitation(tokenHash string) (*Invitation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	invitation, ok := m.invitations[tokenHash]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *invitation
+
+	return &copied, nil
+}
+
+func (m *memoryInvitationStore) UseInvitation(id string, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, invitation := range m.invitations {
+		if invitation.ID == id {
+			if invitation.UsedAt != nil {
+				return false, nil
+			}
+
+			invitation.UsedAt = &usedAt
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// registrationPolicy decides who may sign up. Registration is closed unless a mode is set,
+// and the domain mode only lets emails of the listed domains through.
+type registrationPolicy struct {
+	mode    string
+	domains []string
+}
+
+// Register creates a user for the sign up, when the registration mode lets it through. Any
+// rejected input is returned in a *ValidationError. The new user is asked to verify their
+// email, unless they signed up with an invitation sent to it.
+func (s *security) Register(ctx context.Context, input *RegistrationInput) (string, error) {
+	if s.registration.mode == registrationModeClosed || s.registrar == nil {
+		return "", errRegistrationClosed
+	}
+
+	input.FirstName = strings.TrimSpace(input.FirstName)
+	input.LastName = strings.TrimSpace(input.LastName)
+	input.Email = strings.TrimSpace(input.Email)
+
+	invitation, err := s.checkRegistration(input)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = s.ValidatePassword(input.Password, PasswordOwner{
+		Email:     input.Email,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if invitation != nil {
+		used, err := s.invitationStore.UseInvitation(invitation.ID, TimeFunc())
+
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		if !used {
+			return "", registrationViolation(fieldInvitation, violationInvalidInvitation, "invitation is not valid")
+		}
+	}
+
+	hashedPassword, err := s.passwords.hash(input.Password)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	pending := s.registration.mode == registrationModeApproval
+
+	userID, err := s.registrar.RegisterUser(&Registration{
+		FirstName:       input.FirstName,
+		LastName:        input.LastName,
+		Email:           input.Email,
+		HashedPassword:  hashedPassword,
+		Enabled:         !pending,
+		PendingApproval: pending,
+		EmailVerified:   invitation != nil,
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	description := fmt.Sprintf("User %q registered.", input.Email)
+
+	if pending {
+		description = fmt.Sprintf("User %q registered, waiting for approval.", input.Email)
+	}
+
+	err = s.events.RecordEvent(ctx, userID, eventTypeUserRegistered, description)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	err = s.SendEmailVerification(userID)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return userID, nil
+}
+
+// checkRegistration checks the input against the registration mode, and returns the
+// invitation it uses, if any.
+func (s *security) checkRegistration(input *RegistrationInput) (*Invitation, error) {
+	if input.FirstName == "" {
+		return nil, registrationViolation(fieldFirstName, violationRequired, "first name is required")
+	}
+
+	if input.LastName == "" {
+		return nil, registrationViolation(fieldLastName, violationRequired, "last name is required")
+	}
+
+	at := strings.LastIndex(input.Email, "@")
+
+	if at <= 0 || at == len(input.Email)-1 || strings.ContainsAny(input.Email, " \r\n") {
+		return nil, registrationViolation(fieldEmail, violationInvalidEmail, "email is not valid")
+	}
+
+	var invitation *Invitation
+
+	switch s.registration.mode {
+	case registrationModeDomain:
+		if !s.registration.allowsDomain(input.Email[at+1:]) {
+			return nil, registrationViolation(fieldEmail, violationDomainNotAllowed, "email domain is not allowed")
+		}
+	case registrationModeInvite:
+		var err error
+
+		invitation, err = s.findInvitation(input.Invitation)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if invitation == nil || !strings.EqualFold(invitation.Email, input.Email) {
+			return nil, registrationViolation(fieldInvitation, violationInvalidInvitation, "invitation is not valid for the email")
+		}
+	}
+
+	err := s.checkEmailAvailable(input.Email)
+
+	if errors.Cause(err) == errEmailTaken {
+		return nil, registrationViolation(fieldEmail, violationEmailTaken, "email belongs to another user")
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return invitation, nil
+}
+
+// InviteUser mails an invitation link to the email, to sign up with when registration is
+// by invitation only.
+func (s *security) InviteUser(invitedBy, email string) error {
+	email = strings.TrimSpace(email)
+
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, " \r\n") {
+		return errInvalidEmail
+	}
+
+	err := s.checkEmailAvailable(email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	id, err := uuid.NewV1()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	value, err := generateOpaqueToken()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	err = s.invitationStore.SaveInvitation(&Invitation{
+		ID:        id.String(),
+		Email:     email,
+		InvitedBy: invitedBy,
+		TokenHash: hashOpaqueToken(value),
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationLifetime),
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	link := s.webAuthn.origin + registerPath + "?" + url.Values{queryKeyInvitation: {value}}.Encode()
+
+	return s.mailer.SendMail(&Mail{
+		To:      email,
+		Subject: invitationMailSubject,
+		Body:    fmt.Sprintf(invitationMailBody, link),
+	})
+}
+
+// findInvitation returns the invitation of the token, or nil when it can not be used.
+func (s *security) findInvitation(token string) (*Invitation, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	invitation, err := s.invitationStore.FindInvitation(hashOpaqueToken(token))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if invitation == nil || invitation.UsedAt != nil || !invitation.ExpiresAt.After(TimeFunc()) {
+		return nil, nil
+	}
+
+	return invitation, nil
+}
+
+// requiresVerifiedEmail reports whether anyone can sign up with an email they do not own,
+// so that users must verify it before they can log in.
+func (p *registrationPolicy) requiresVerifiedEmail() bool {
+	return p.mode == registrationModeOpen || p.mode == registrationModeDomain
+}
+
+func (p *registrationPolicy) allowsDomain(domain string) bool {
+	for _, allowed := range p.domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func registrationViolation(field, code, message string) error {
+	return &ValidationError{field, []Violation{{code, message}}}
+}
+
+type registerData struct {
+	Closed     bool
+	Invitation string
+	Input      *RegistrationInput
+	Field      string
+	Violations []Violation
+	Done       bool
+	Pending    bool
+}
+
+type registerResponse struct {
+	ID      string `json:"id"`
+	Pending bool   `json:"pending"`
+}
+
+func (s *security) GetRegister(w http.ResponseWriter, r *http.Request) error {
+	return renderRegister(w, r, &registerData{
+		Closed:     s.registration.mode == registrationModeClosed || s.registrar == nil,
+		Invitation: r.URL.Query().Get(queryKeyInvitation),
+		Input:      &RegistrationInput{},
+	})
+}
+
+// PostRegister signs up a user from the register form, or from a JSON body. JSON clients get
+// 201 with the id of the user, or 422 with the violations.
+func (s *security) PostRegister(w http.ResponseWriter, r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headerContentType))
+
+	if mediaType == contentTypeJSON {
+		return s.postRegisterJSON(w, r)
+	}
+
+	input := &RegistrationInput{
+		FirstName:  r.PostFormValue(formKeyFirstName),
+		LastName:   r.PostFormValue(formKeyLastName),
+		Email:      r.PostFormValue(formKeyEmail),
+		Password:   r.PostFormValue(formKeyPassword),
+		Invitation: r.PostFormValue(queryKeyInvitation),
+	}
+
+	_, err := s.Register(r.Context(), input)
+
+	if errors.Cause(err) == errRegistrationClosed {
+		w.WriteHeader(http.StatusForbidden)
+		return renderRegister(w, r, &registerData{Closed: true})
+	}
+
+	if validation, ok := errors.Cause(err).(*ValidationError); ok {
+		input.Password = ""
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		return renderRegister(w, r, &registerData{
+			Invitation: input.Invitation,
+			Input:      input,
+			Field:      validation.Field,
+			Violations: validation.Violations,
+		})
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderRegister(w, r, &registerData{Done: true, Pending: s.registration.mode == registrationModeApproval})
+}
+
+func (s *security) postRegisterJSON(w http.ResponseWriter, r *http.Request) error {
+	input := &RegistrationInput{}
+
+	err := json.NewDecoder(r.Body).Decode(input)
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	userID, err := s.Register(r.Context(), input)
+
+	if errors.Cause(err) == errRegistrationClosed {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, err))
+	}
+
+	if err != nil {
+		return validationError(w, err)
+	}
+
+	return httpgo.WriteJSON(w, http.StatusCreated, &registerResponse{userID, s.registration.mode == registrationModeApproval})
+}
+
+func renderRegister(w http.ResponseWriter, r *http.Request, data *registerData) error {
+	tmpl, err := ParseTemplate(r, "./templates/register.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, data)
+}
+
+// resolveRegistrationPolicy reads the mode, one of closed, open, invite, domain or approval,
+// and the comma separated domains for the domain mode. It falls back to closed when the mode
+// is not valid, or when no domains are set for the domain mode.
+func resolveRegistrationPolicy() *registrationPolicy {
+	policy := &registrationPolicy{mode: registrationModeClosed}
+
+	for _, domain := range strings.Split(os.Getenv(environmentRegistrationDomains), ",") {
+		domain = strings.TrimPrefix(strings.TrimSpace(domain), "@")
+
+		if domain != "" {
+			policy.domains = append(policy.domains, domain)
+		}
+	}
+
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv(environmentRegistrationMode))); mode {
+	case registrationModeOpen, registrationModeInvite, registrationModeApproval:
+		policy.mode = mode
+	case registrationModeDomain:
+		if len(policy.domains) > 0 {
+			policy.mode = mode
+		}
+	}
+
+	return policy
+}
@@ -0,0 +1,180 @@
+package security_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type directory struct {
+	subjects
+	registrations []*Registration
+}
+
+func (d *directory) RegisterUser(registration *Registration) (string, error) {
+	id := fmt.Sprintf("registered-%d", len(d.registrations))
+	d.subjects = append(d.subjects, &subject{
+		id:         id,
+		email:      registration.Email,
+		password:   registration.HashedPassword,
+		unverified: !registration.EmailVerified,
+		pending:    registration.PendingApproval,
+	})
+	d.registrations = append(d.registrations, registration)
+
+	return id, nil
+}
+
+var _ = Describe("Registration", func() {
+
+	const (
+		password = "correct horse battery staple"
+	)
+
+	var (
+		mails  *outbox
+		events *eventLog
+		users  *directory
+	)
+
+	register := func(handler httpgo.ErrorHandlerFunc, input *RegistrationInput) *httptest.ResponseRecorder {
+		body, err := json.Marshal(input)
+
+		Expect(err).To(BeNil())
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	violation := func(w *httptest.ResponseRecorder) string {
+		body := &struct {
+			Violations []struct {
+				Code string `json:"code"`
+			} `json:"violations"`
+		}{}
+
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(json.Unmarshal(w.Body.Bytes(), body)).To(Succeed())
+		Expect(body.Violations).To(HaveLen(1))
+
+		return body.Violations[0].Code
+	}
+
+	setMode := func(mode string) {
+		os.Setenv("AUTHGO_REGISTRATION_MODE", mode)
+	}
+
+	BeforeEach(func() {
+		mails = &outbox{}
+		events = &eventLog{}
+		users = &directory{subjects: subjects{newSubject("ce274fd4-5803-11e8-8879-afa0dd22785d", "erik@eies.land", "secret")}}
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("AUTHGO_REGISTRATION_MODE")
+		os.Unsetenv("AUTHGO_REGISTRATION_DOMAINS")
+	})
+
+	newSecurity := func() interface {
+		PostRegister(w http.ResponseWriter, r *http.Request) error
+		InviteUser(invitedBy, email string) error
+		Authenticate(w http.ResponseWriter, r *http.Request) error
+	} {
+		return New(users, WithMailer(mails), WithEventRecorder(events), WithUserRegistrar(users))
+	}
+
+	It("should be closed by default", func() {
+		w := register(newSecurity().PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, ""})
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(users.registrations).To(BeEmpty())
+	})
+
+	It("should let anyone register when it is open", func() {
+		setMode("open")
+		security := newSecurity()
+
+		w := register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, ""})
+
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Body.String()).To(MatchJSON(`{"id": "registered-0", "pending": false}`))
+		Expect(users.registrations[0].Enabled).To(BeTrue())
+		Expect(*events).To(Equal(eventLog{{"registered-0", "USER_REGISTERED"}}))
+		Expect(*mails).To(HaveLen(1))
+		Expect((*mails)[0].Body).To(ContainSubstring("/email/verify?token="))
+
+		By("rejecting taken emails and weak passwords")
+
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Erik", "Eies", "erik@eies.land", password, ""}))).To(Equal("EMAIL_TAKEN"))
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Kari", "Nordmann", "kari@eies.land", "secret", ""}))).To(Equal("TOO_SHORT"))
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"", "Nordmann", "kari@eies.land", password, ""}))).To(Equal("REQUIRED"))
+	})
+
+	It("should only let the allowed domains register in the domain mode", func() {
+		setMode("domain")
+		os.Setenv("AUTHGO_REGISTRATION_DOMAINS", "eies.land, @eies.no")
+		security := newSecurity()
+
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@example.com", password, ""}))).To(Equal("DOMAIN_NOT_ALLOWED"))
+		Expect(register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@EIES.no", password, ""}).Code).To(Equal(http.StatusCreated))
+
+		By("refusing the login until the email is verified")
+
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"ola@EIES.no"}, "password": {password}})
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should require an invitation for the email in the invite mode", func() {
+		setMode("invite")
+		security := newSecurity()
+
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, ""}))).To(Equal("INVALID_INVITATION"))
+		Expect(security.InviteUser("ce274fd4-5803-11e8-8879-afa0dd22785d", "ola@eies.land")).To(Succeed())
+		Expect(*mails).To(HaveLen(1))
+
+		link, err := url.Parse(regexp.MustCompile(`https?://\S+`).FindString((*mails)[0].Body))
+
+		Expect(err).To(BeNil())
+		Expect(link.Path).To(Equal("/register"))
+
+		invitation := link.Query().Get("invitation")
+
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Kari", "Nordmann", "kari@eies.land", password, invitation}))).To(Equal("INVALID_INVITATION"))
+		Expect(register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, invitation}).Code).To(Equal(http.StatusCreated))
+		Expect(users.registrations[0].EmailVerified).To(BeTrue())
+		Expect(*mails).To(HaveLen(1))
+
+		By("using the invitation once")
+
+		Expect(violation(register(security.PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, invitation}))).To(Equal("INVALID_INVITATION"))
+	})
+
+	It("should create disabled users in the approval mode", func() {
+		setMode("approval")
+
+		w := register(newSecurity().PostRegister, &RegistrationInput{"Ola", "Nordmann", "ola@eies.land", password, ""})
+
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Body.String()).To(MatchJSON(`{"id": "registered-0", "pending": true}`))
+		Expect(users.registrations[0].Enabled).To(BeFalse())
+		Expect(users.registrations[0].PendingApproval).To(BeTrue())
+	})
+})
@@ -0,0 +1,154 @@
+package security
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	formKeyTokenID = "token_id"
+	formKeyUserID  = "user_id"
+)
+
+var (
+	errTokenRevoked   = errors.New("authgo: token revoked")
+	errInvalidTokenID = errors.New("authgo: token_id must be a UUID")
+	errInvalidUserID  = errors.New("authgo: user_id must be a UUID")
+)
+
+type revocationStore interface {
+	// RevokeToken revokes a single token. The revocation can be forgotten after expiresAt.
+	RevokeToken(tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(tokenID string) (bool, error)
+	// RevokeUserTokens revokes every token of the user issued until revokedAt.
+	RevokeUserTokens(userID string, revokedAt time.Time) error
+	FindUserTokensRevokedAt(userID string) (*time.Time, error)
+}
+
+type memoryRevocationStore struct {
+	sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+func (m *memoryRevocationStore) RevokeToken(tokenID string, expiresAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]time.Time)
+	}
+
+	m.tokens[tokenID] = expiresAt
+
+	return nil
+}
+
+func (m *memoryRevocationStore) IsTokenRevoked(tokenID string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	_, ok := m.tokens[tokenID]
+
+	return ok, nil
+}
+
+func (m *memoryRevocationStore) RevokeUserTokens(userID string, revokedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.users == nil {
+		m.users = make(map[string]time.Time)
+	}
+
+	m.users[userID] = revokedAt
+
+	return nil
+}
+
+func (m *memoryRevocationStore) FindUserTokensRevokedAt(userID string) (*time.Time, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	revokedAt, ok := m.users[userID]
+
+	if !ok {
+		return nil, nil
+	}
+
+	return &revokedAt, nil
+}
+
+// RevokeToken revokes a single access token by its id.
+func (s *security) RevokeToken(tokenID string) error {
+	// Any token that is still valid expires within its lifetime from now.
+	err := s.revocationStore.RevokeToken(tokenID, TimeFunc().Add(accessTokenLifetime))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to the user so far, which
+// ends all of their sessions.
+func (s *security) RevokeUserTokens(userID string) error {
+	now := TimeFunc()
+
+	err := s.revocationStore.RevokeUserTokens(userID, now)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.refreshTokenStore.RevokeUserRefreshTokens(userID, now)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = s.sessionStore.RevokeUserSessions(userID, now)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (s *security) checkRevocation(claims *jwtClaims) error {
+	revoked, err := s.revocationStore.IsTokenRevoked(claims.Id)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if revoked {
+		return errTokenRevoked
+	}
+
+	revokedAt, err := s.revocationStore.FindUserTokensRevokedAt(claims.UserID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if revokedAt != nil && !claims.issuedAt().After(revokedAt.Truncate(time.Microsecond)) {
+		return errTokenRevoked
+	}
+
+	return nil
+}
+
+// issuedAt is when the token was issued, to the microsecond that the stores keep. Tokens
+// without iat_ns only have the second, and count as issued at its start.
+func (c *jwtClaims) issuedAt() time.Time {
+	if c.IssuedAtNano == 0 {
+		return time.Unix(c.IssuedAt, 0)
+	}
+
+	return time.Unix(0, c.IssuedAtNano).Truncate(time.Microsecond)
+}
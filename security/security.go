@@ -2,53 +2,88 @@ package security
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 )
 
 const (
-	environmentSecurityKey = "AUTHGO_SECURITY_KEY"
-	jwtAudience            = "eies.land"
-	jwtIssuer              = "authgo"
-	jwtCookieName          = "authgo_token"
-	ctxKeyUserID           = contextKeyUserID("ctxKeyUserID")
-	ctxKeyUserEmail        = contextKeyUserEmail("ctxKeyUserEmail")
-	formKeyEmail           = "email"
-	formKeyPassword        = "password"
-	UnknownUserID          = "UnknownUserID"
-	UnknownUserEmail       = "UnknownUserEmail"
+	jwtAudience               = "eies.land"
+	jwtCookieName             = "authgo_token"
+	ctxKeyUserID              = contextKeyUserID("ctxKeyUserID")
+	ctxKeyUserEmail           = contextKeyUserEmail("ctxKeyUserEmail")
+	ctxKeyTokenID             = contextKeyTokenID("ctxKeyTokenID")
+	ctxKeyPrincipalType       = contextKeyPrincipalType("ctxKeyPrincipalType")
+	ctxKeySessionID           = contextKeySessionID("ctxKeySessionID")
+	ctxKeyRoles               = contextKeyRoles("ctxKeyRoles")
+	ctxKeyAuthorities         = contextKeyAuthorities("ctxKeyAuthorities")
+	environmentTokenSources   = "AUTHGO_TOKEN_SOURCES"
+	tokenSourceHeader         = "header"
+	tokenSourceCookie         = "cookie"
+	headerAuthorization       = "Authorization"
+	headerWWWAuthenticate     = "WWW-Authenticate"
+	authorizationSchemeBearer = "bearer"
+	formKeyEmail              = "email"
+	formKeyPassword           = "password"
+	UnknownUserID             = "UnknownUserID"
+	UnknownUserEmail          = "UnknownUserEmail"
 )
 
 var (
 	TimeFunc                = time.Now
 	errInvalidSigningMethod = errors.New("authgo: invalid signing method")
-	errMissingSecurityKey   = errors.New("authgo: missing environment variable AUTHGO_SECURITY_KEY")
+	errMissingToken         = errors.New("authgo: missing token")
+	defaultTokenSources     = []string{tokenSourceHeader, tokenSourceCookie}
 	logoutCookie            = &http.Cookie{
 		Name:     jwtCookieName,
 		Value:    "",
+		Path:     "/",
 		Expires:  time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
 		HttpOnly: true,
 		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	}
+	logoutRefreshCookie = &http.Cookie{
+		Name:     refreshTokenCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
 	}
 )
 
 type contextKey string
 type contextKeyUserID contextKey
 type contextKeyUserEmail contextKey
+type contextKeyTokenID contextKey
+type contextKeyPrincipalType contextKey
+type contextKeySessionID contextKey
+type contextKeyRoles contextKey
+type contextKeyAuthorities contextKey
 
 type Subject interface {
 	UserID() string
 	UserEmail() string
 	UserPassword() string
 	UserActive() bool
+	UserEmailVerified() bool
+	UserPendingApproval() bool
+	UserFirstName() string
+	UserLastName() string
 }
 
 type authentication struct {
-	jwtToken *jwtToken
-	subj     Subject
+	jwtToken     *jwtToken
+	refreshToken *refreshToken
+	idToken      string
+	subj         Subject
 }
 
 type authorization struct {
@@ -59,12 +94,279 @@ type subjectByEmailFinder interface {
 	FindSubjectByEmail(email string) (Subject, error)
 }
 
-type security struct {
+type subjectByIDFinder interface {
+	FindSubjectByID(id string) (Subject, error)
+}
+
+type passwordUpdater interface {
+	UpdateUserPassword(userID, hashedPassword string) error
+}
+
+type subjectFinder interface {
 	subjectByEmailFinder
+	subjectByIDFinder
+}
+
+type security struct {
+	subjectFinder
+	signingKeyStore             signingKeyStore
+	refreshTokenStore           refreshTokenStore
+	revocationStore             revocationStore
+	singleUseStore              singleUseStore
+	clients                     clientFinder
+	authorizationCodeStore      authorizationCodeStore
+	mfaStore                    mfaStore
+	mfaPolicy                   mfaPolicy
+	webAuthnCredentialStore     webAuthnCredentialStore
+	webAuthn                    *webAuthnConfig
+	loginAttemptStore           loginAttemptStore
+	events                      eventRecorder
+	lockout                     *lockoutPolicy
+	breachedPasswordStore       breachedPasswordStore
+	passwordPolicy              *passwordPolicy
+	passwords                   *passwordHashing
+	passwordUpdater             passwordUpdater
+	passwordResetTokenStore     passwordResetTokenStore
+	mailer                      Mailer
+	emailVerificationTokenStore emailVerificationTokenStore
+	emailUpdater                emailUpdater
+	emailVerificationRequired   bool
+	registrar                   userRegistrar
+	invitationStore             invitationStore
+	sessionStore                sessionStore
+	authorities                 authorityFinder
+	personalAccessTokenStore    personalAccessTokenStore
+	registration                *registrationPolicy
+	keys                        *keySet
+	tokenSources                []string
+	issuer                      string
+	redirectHosts               []string
+	trustedProxies              []*net.IPNet
+}
+
+// Option configures the stores used by New.
+type Option func(*security)
+
+// WithSigningKeyStore persists the signing keys, so that they survive restarts and are
+// shared between instances. Keys are kept in memory by default.
+func WithSigningKeyStore(store signingKeyStore) Option {
+	return func(s *security) {
+		s.signingKeyStore = store
+	}
+}
+
+// WithRefreshTokenStore persists the refresh tokens. They are kept in memory by default.
+func WithRefreshTokenStore(store refreshTokenStore) Option {
+	return func(s *security) {
+		s.refreshTokenStore = store
+	}
+}
+
+// WithRevocationStore persists the token revocations. They are kept in memory by default.
+func WithRevocationStore(store revocationStore) Option {
+	return func(s *security) {
+		s.revocationStore = store
+	}
+}
+
+// WithSingleUseStore persists the values that may only be used once, such as pending logins.
+// They are kept in memory by default.
+func WithSingleUseStore(store singleUseStore) Option {
+	return func(s *security) {
+		s.singleUseStore = store
+	}
+}
+
+// WithClientFinder provides the registered OAuth 2.0 clients. There are none by default.
+func WithClientFinder(clients clientFinder) Option {
+	return func(s *security) {
+		s.clients = clients
+	}
+}
+
+// WithAuthorizationCodeStore persists the OAuth 2.0 authorization codes. They are kept in
+// memory by default.
+func WithAuthorizationCodeStore(store authorizationCodeStore) Option {
+	return func(s *security) {
+		s.authorizationCodeStore = store
+	}
+}
+
+// WithMFAStore persists the second factors of the users. They are kept in memory by default.
+func WithMFAStore(store mfaStore) Option {
+	return func(s *security) {
+		s.mfaStore = store
+	}
+}
+
+// WithMFAPolicy decides which users must use a second factor. By default it is optional.
+func WithMFAPolicy(policy mfaPolicy) Option {
+	return func(s *security) {
+		s.mfaPolicy = policy
+	}
 }
 
-func New(subjectFinder subjectByEmailFinder) *security {
-	return &security{subjectFinder}
+// WithWebAuthnCredentialStore persists the passkeys and security keys of the users. They are
+// kept in memory by default.
+func WithWebAuthnCredentialStore(store webAuthnCredentialStore) Option {
+	return func(s *security) {
+		s.webAuthnCredentialStore = store
+	}
+}
+
+// WithLoginAttemptStore persists the failed logins and lockouts. They are kept in memory by
+// default.
+func WithLoginAttemptStore(store loginAttemptStore) Option {
+	return func(s *security) {
+		s.loginAttemptStore = store
+	}
+}
+
+// WithEventRecorder records security events, such as failed logins and lockouts, in the
+// event log of the users. They are not recorded by default.
+func WithEventRecorder(events eventRecorder) Option {
+	return func(s *security) {
+		s.events = events
+	}
+}
+
+// WithBreachedPasswordStore persists the breached passwords that new passwords are checked
+// against. They are kept in memory by default.
+func WithBreachedPasswordStore(store breachedPasswordStore) Option {
+	return func(s *security) {
+		s.breachedPasswordStore = store
+	}
+}
+
+// WithPasswordHasher hashes new passwords with the hasher, instead of the one configured by
+// AUTHGO_PASSWORD_HASHER. Passwords hashed otherwise are rehashed when the user logs in.
+func WithPasswordHasher(hasher PasswordHasher) Option {
+	return func(s *security) {
+		s.passwords = resolvePasswordHashing()
+		s.passwords.hasher = hasher
+	}
+}
+
+// WithPasswordUpdater stores the passwords that are rehashed on login. Without it, hashes
+// are never upgraded.
+func WithPasswordUpdater(updater passwordUpdater) Option {
+	return func(s *security) {
+		s.passwordUpdater = updater
+	}
+}
+
+// WithPasswordResetTokenStore persists the password reset tokens. They are kept in memory by
+// default.
+func WithPasswordResetTokenStore(store passwordResetTokenStore) Option {
+	return func(s *security) {
+		s.passwordResetTokenStore = store
+	}
+}
+
+// WithMailer sends the mail to the users, instead of the mailer configured by the
+// environment.
+func WithMailer(mailer Mailer) Option {
+	return func(s *security) {
+		s.mailer = mailer
+	}
+}
+
+// WithEmailVerificationTokenStore persists the email verification tokens. They are kept in
+// memory by default.
+func WithEmailVerificationTokenStore(store emailVerificationTokenStore) Option {
+	return func(s *security) {
+		s.emailVerificationTokenStore = store
+	}
+}
+
+// WithEmailUpdater stores the emails that are verified. Without it, emails can not be
+// verified or changed.
+func WithEmailUpdater(updater emailUpdater) Option {
+	return func(s *security) {
+		s.emailUpdater = updater
+	}
+}
+
+// WithUserRegistrar creates the users that sign up themselves. Without it, registration is
+// closed whatever the mode.
+func WithUserRegistrar(registrar userRegistrar) Option {
+	return func(s *security) {
+		s.registrar = registrar
+	}
+}
+
+// WithInvitationStore persists the invitations to sign up. They are kept in memory by
+// default.
+func WithInvitationStore(store invitationStore) Option {
+	return func(s *security) {
+		s.invitationStore = store
+	}
+}
+
+// WithSessionStore persists the sessions of the users. They are kept in memory by default.
+func WithSessionStore(store sessionStore) Option {
+	return func(s *security) {
+		s.sessionStore = store
+	}
+}
+
+// WithPersonalAccessTokenStore persists the personal access tokens of the users. They are
+// kept in memory by default.
+func WithPersonalAccessTokenStore(store personalAccessTokenStore) Option {
+	return func(s *security) {
+		s.personalAccessTokenStore = store
+	}
+}
+
+// WithAuthorityFinder provides the roles and authorities that are put in the tokens of the
+// users. There are none by default.
+func WithAuthorityFinder(authorities authorityFinder) Option {
+	return func(s *security) {
+		s.authorities = authorities
+	}
+}
+
+func New(subjects subjectFinder, opts ...Option) *security {
+	s := &security{
+		subjectFinder:               subjects,
+		signingKeyStore:             &memorySigningKeyStore{},
+		refreshTokenStore:           &memoryRefreshTokenStore{},
+		revocationStore:             &memoryRevocationStore{},
+		singleUseStore:              &memorySingleUseStore{},
+		clients:                     memoryClientFinder{},
+		authorizationCodeStore:      &memoryAuthorizationCodeStore{},
+		mfaStore:                    &memoryMFAStore{},
+		mfaPolicy:                   optionalMFAPolicy{},
+		webAuthnCredentialStore:     &memoryWebAuthnCredentialStore{},
+		loginAttemptStore:           &memoryLoginAttemptStore{},
+		events:                      noopEventRecorder{},
+		breachedPasswordStore:       &memoryBreachedPasswordStore{},
+		passwords:                   resolvePasswordHashing(),
+		passwordResetTokenStore:     &memoryPasswordResetTokenStore{},
+		mailer:                      resolveMailer(),
+		emailVerificationTokenStore: &memoryEmailVerificationTokenStore{},
+		invitationStore:             &memoryInvitationStore{},
+		sessionStore:                &memorySessionStore{},
+		authorities:                 noAuthorityFinder{},
+		personalAccessTokenStore:    &memoryPersonalAccessTokenStore{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.keys = newKeySet(s.signingKeyStore)
+	s.tokenSources = resolveTokenSources()
+	s.issuer = resolveIssuer()
+	s.redirectHosts = resolveRedirectHosts()
+	s.webAuthn = resolveWebAuthnConfig(s.issuer)
+	s.lockout = resolveLockoutPolicy()
+	s.trustedProxies = resolveTrustedProxies()
+	s.passwordPolicy = resolvePasswordPolicy()
+	s.registration = resolveRegistrationPolicy()
+	s.emailVerificationRequired = resolveEmailVerificationRequired() || s.registration.requiresVerifiedEmail()
+
+	return s
 }
 
 func UserIDFromContext(ctx context.Context) string {
@@ -75,6 +377,17 @@ func UserIDFromContext(ctx context.Context) string {
 	return UnknownUserID
 }
 
+func tokenIDFromContext(ctx context.Context) string {
+	tokenID, _ := ctx.Value(ctxKeyTokenID).(string)
+	return tokenID
+}
+
+// ServiceAccountFromContext reports whether the request was made by a service account.
+func ServiceAccountFromContext(ctx context.Context) bool {
+	principalType, _ := ctx.Value(ctxKeyPrincipalType).(string)
+	return principalType == principalTypeServiceAccount
+}
+
 func UserEmailFromContext(ctx context.Context) string {
 	if userEmail, ok := ctx.Value(ctxKeyUserEmail).(string); ok && userEmail != "" {
 		return userEmail
@@ -83,85 +396,204 @@ func UserEmailFromContext(ctx context.Context) string {
 	return UnknownUserEmail
 }
 
-func (s *security) authenticateRequest(r *http.Request) (*authentication, error) {
+// authenticateRequest checks the credentials of the request. When the user still has to
+// pass a second factor, a challenge is returned instead of the authentication.
+func (s *security) authenticateRequest(r *http.Request) (*authentication, *mfaChallenge, error) {
 	err := r.ParseForm()
 
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 
-	subj, err := s.resolveSubject(r.Form.Get(formKeyEmail), r.Form.Get(formKeyPassword))
+	subj, err := s.resolveSubject(r, r.Form.Get(formKeyEmail), r.Form.Get(formKeyPassword))
 
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 
-	jwtToken, err := createToken(subj)
+	challenge, err := s.challengeMFA(subj)
 
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if challenge != nil {
+		return nil, challenge, nil
+	}
+
+	authN, err := s.createAuthentication(subj, s.loginGrant(r, amrPassword))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
 	}
 
-	return &authentication{jwtToken, subj}, nil
+	return authN, nil, nil
 }
 
-func (s *security) resolveSubject(email, password string) (Subject, error) {
-	subj, err := s.FindSubjectByEmail(email)
+func (s *security) createAuthentication(subj Subject, g *grant) (*authentication, error) {
+	if !g.serviceAccount && g.refreshTokenFamilyID == "" {
+		// A new login starts a new session, which its access tokens refer to.
+		id, err := uuid.NewV1()
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		g.refreshTokenFamilyID = id.String()
+	}
+
+	jwtToken, err := s.createToken(subj, g)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if subj == nil {
-		return nil, errors.New("authgo: subject is nil")
+	if g.serviceAccount {
+		return &authentication{jwtToken, nil, "", subj}, nil
 	}
 
-	if !subj.UserActive() {
-		return nil, errors.New("authgo: user is not active")
+	refreshToken, err := s.createRefreshToken(subj, g)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	err = validateHashedPassword(subj.UserPassword(), password)
+	err = s.saveSession(subj, g, jwtToken, refreshToken)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return subj, nil
+	var idToken string
+
+	if g.clientID != "" && hasScope(g.scope, scopeOpenID) {
+		idToken, err = s.createIDToken(subj, g)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return &authentication{jwtToken, refreshToken, idToken, subj}, nil
 }
 
-func authorizeRequest(r *http.Request) (*authorization, error) {
-	cookie, err := r.Cookie(jwtCookieName)
+func (s *security) authorizeRequest(r *http.Request) (*authorization, error) {
+	signedToken, err := s.tokenFromRequest(r)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	claims := &jwtClaims{}
-	token, err := jwt.ParseWithClaims(cookie.Value, claims, resolveSecurityKey)
+	claims, err := s.validateToken(r.Context(), signedToken)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if !token.Valid {
-		return nil, errors.New("authgo: invalid token")
+	return &authorization{claims}, nil
+}
+
+// validateToken parses the access token, and checks that neither it nor its session has been
+// revoked. Personal access tokens are accepted as well.
+func (s *security) validateToken(ctx context.Context, signedToken string) (*jwtClaims, error) {
+	if strings.HasPrefix(signedToken, personalAccessTokenPrefix) {
+		return s.validatePersonalAccessToken(ctx, signedToken)
 	}
 
-	jwt.TimeFunc = TimeFunc
+	claims, err := s.parseToken(signedToken)
 
-	if err := claims.Valid(); err != nil {
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return &authorization{claims}, nil
+	err = s.checkRevocation(claims)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.checkSession(claims)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return claims, nil
+}
+
+// tokenFromRequest looks for the token in the configured sources, in order of precedence.
+func (s *security) tokenFromRequest(r *http.Request) (string, error) {
+	for _, source := range s.tokenSources {
+		switch source {
+		case tokenSourceHeader:
+			if token, ok := bearerToken(r); ok {
+				return token, nil
+			}
+		case tokenSourceCookie:
+			if cookie, err := r.Cookie(jwtCookieName); err == nil && cookie.Value != "" {
+				return cookie.Value, nil
+			}
+		}
+	}
+
+	return "", errMissingToken
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get(headerAuthorization), " ", 2)
+
+	if len(parts) != 2 || !strings.EqualFold(parts[0], authorizationSchemeBearer) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(parts[1])
+
+	return token, token != ""
+}
+
+// resolveTokenSources reads the comma separated list of token sources, for example
+// "header,cookie", from the environment.
+func resolveTokenSources() []string {
+	value, ok := os.LookupEnv(environmentTokenSources)
+
+	if !ok {
+		return defaultTokenSources
+	}
+
+	var sources []string
+
+	for _, source := range strings.Split(value, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+
+		if source == tokenSourceHeader || source == tokenSourceCookie {
+			sources = append(sources, source)
+		}
+	}
+
+	if len(sources) == 0 {
+		return defaultTokenSources
+	}
+
+	return sources
 }
 
 func setAuthenticationCookie(w http.ResponseWriter, authN *authentication) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     jwtCookieName,
 		Value:    authN.jwtToken.signedToken,
+		Path:     "/",
 		Expires:  authN.jwtToken.expiresAt,
 		HttpOnly: true,
 		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookieName,
+		Value:    authN.refreshToken.value,
+		Path:     "/",
+		Expires:  authN.refreshToken.expiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
 	})
 }
@@ -0,0 +1,155 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	grantTypeClientCredentials   = "client_credentials"
+	paramClientAssertion         = "client_assertion"
+	paramClientAssertionType     = "client_assertion_type"
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionIDPrefix      = "client_assertion:"
+	maxClientAssertionLifetime   = 5 * time.Minute
+	oauthErrorUnauthorizedClient = "unauthorized_client"
+	principalTypeServiceAccount  = "service_account"
+)
+
+var (
+	errInvalidClientAssertion = errors.New("authgo: invalid client assertion")
+)
+
+// clientCredentialsRequest issues a token to the service account the client acts as. No
+// refresh token is issued, the client simply authenticates again.
+func (s *security) clientCredentialsRequest(r *http.Request, client *Client) (*authentication, error) {
+	if client.public() || client.ServiceAccountID == "" {
+		return nil, newOAuthError(oauthErrorUnauthorizedClient, "client has no service account")
+	}
+
+	subj, err := s.FindSubjectByID(client.ServiceAccountID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, newOAuthError(oauthErrorInvalidGrant, "service account is not active")
+	}
+
+	return s.createAuthentication(subj, &grant{
+		clientID:       client.ID,
+		scope:          r.PostForm.Get(paramScope),
+		authTime:       TimeFunc(),
+		serviceAccount: true,
+	})
+}
+
+// verifyClientAssertion authenticates a client with a JWT signed by its own private key, as
+// described in RFC 7523. Each assertion is accepted once.
+func (s *security) verifyClientAssertion(client *Client, assertion string) error {
+	public, err := parsePublicKey(client.PublicKey)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(assertion, claims, func(t *jwt.Token) (interface{}, error) {
+		if !signingMethodMatchesKey(t.Method, public) {
+			return nil, errInvalidSigningMethod
+		}
+
+		return public, nil
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	switch {
+	case !token.Valid:
+		return errInvalidClientAssertion
+	case claims.Issuer != client.ID, claims.Subject != client.ID:
+		return errInvalidClientAssertion
+	case !claims.VerifyAudience(s.issuer+tokenPath, true) && !claims.VerifyAudience(s.issuer, true):
+		return errInvalidClientAssertion
+	case claims.Id == "", claims.ExpiresAt == 0, claims.ExpiresAt > now.Add(maxClientAssertionLifetime).Unix():
+		return errInvalidClientAssertion
+	}
+
+	if err := claims.Valid(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	first, err := s.singleUseStore.UseOnce(clientAssertionIDPrefix+client.ID+":"+claims.Id, time.Unix(claims.ExpiresAt, 0))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !first {
+		return errInvalidClientAssertion
+	}
+
+	return nil
+}
+
+// ValidatePublicKey checks a public key before it is registered for a client.
+func ValidatePublicKey(value string) error {
+	public, err := parsePublicKey(value)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch public.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return nil
+	}
+
+	return errors.New("authgo: unsupported public key")
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key, as registered for a client.
+func parsePublicKey(value string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(value))
+
+	if block == nil {
+		return nil, errors.New("authgo: invalid public key")
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return public, nil
+}
+
+func signingMethodMatchesKey(method jwt.SigningMethod, public interface{}) bool {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodRSA)
+		return ok
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*signingMethodEdDSA)
+		return ok
+	}
+
+	return false
+}
@@ -0,0 +1,165 @@
+package security_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Client credentials", func() {
+
+	const (
+		serviceAccountID = "ce274fd4-5803-11e8-8879-afa0dd22785f"
+		secretClientID   = "ce274fd4-5803-11e8-8879-afa0dd227860"
+		keyClientID      = "ce274fd4-5803-11e8-8879-afa0dd227861"
+		publicClientID   = "ce274fd4-5803-11e8-8879-afa0dd227862"
+	)
+
+	var (
+		security     http.Handler
+		postToken    func(form url.Values, clientID, clientSecret string) *httptest.ResponseRecorder
+		clientSecret string
+		privateKey   *ecdsa.PrivateKey
+	)
+
+	BeforeEach(func() {
+		var (
+			secretHash string
+			err        error
+		)
+
+		clientSecret, secretHash, err = GenerateClientSecret()
+
+		Expect(err).To(BeNil())
+
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		Expect(err).To(BeNil())
+
+		der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+
+		Expect(err).To(BeNil())
+
+		s := New(
+			subjects{&subject{id: serviceAccountID, email: "batch@service-accounts.eies.land"}},
+			WithClientFinder(clients{
+				secretClientID: {ID: secretClientID, Name: "batch", SecretHash: secretHash, ServiceAccountID: serviceAccountID},
+				keyClientID: {
+					ID:               keyClientID,
+					Name:             "worker",
+					PublicKey:        string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+					ServiceAccountID: serviceAccountID,
+				},
+				publicClientID: {ID: publicClientID, Name: "spa", ServiceAccountID: serviceAccountID},
+			}),
+		)
+
+		security = s.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ServiceAccountFromContext(r.Context()) {
+				w.Write([]byte(UserIDFromContext(r.Context())))
+			}
+		}))
+
+		postToken = func(form url.Values, clientID, clientSecret string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if clientID != "" {
+				r.SetBasicAuth(clientID, clientSecret)
+			}
+
+			httpgo.ErrorHandlerFunc(s.PostToken).ServeHTTP(w, r)
+
+			return w
+		}
+	})
+
+	accessToken := func(w *httptest.ResponseRecorder) string {
+		var response map[string]interface{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response).NotTo(HaveKey("refresh_token"))
+
+		return response["access_token"].(string)
+	}
+
+	authorize := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		security.ServeHTTP(w, r)
+
+		return w
+	}
+
+	It("should issue a service account token for a client secret", func() {
+		w := postToken(url.Values{"grant_type": {"client_credentials"}}, secretClientID, clientSecret)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		w = authorize(accessToken(w))
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(serviceAccountID))
+	})
+
+	It("should reject a wrong client secret", func() {
+		w := postToken(url.Values{"grant_type": {"client_credentials"}}, secretClientID, "wrong")
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should reject public clients", func() {
+		w := postToken(url.Values{"grant_type": {"client_credentials"}, "client_id": {publicClientID}}, "", "")
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring("unauthorized_client"))
+	})
+
+	It("should accept a private key JWT assertion once", func() {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{
+			Audience:  "http://localhost:3000/oauth/token",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			Id:        "a1",
+			Issuer:    keyClientID,
+			Subject:   keyClientID,
+		})
+
+		assertion, err := token.SignedString(privateKey)
+
+		Expect(err).To(BeNil())
+
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {assertion},
+		}
+
+		w := postToken(form, "", "")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(authorize(accessToken(w)).Body.String()).To(Equal(serviceAccountID))
+
+		w = postToken(form, "", "")
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,273 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// sessionTouchInterval limits how often the last seen time of a session is written.
+	sessionTouchInterval = time.Minute
+)
+
+var (
+	errSessionRevoked = errors.New("authgo: session revoked")
+	errUnknownSession = errors.New("authgo: unknown session")
+)
+
+// Session is a login of a user on a device. It lives as long as its refresh tokens, and
+// shares its ID with their family. TokenID is the latest access token issued in it.
+type Session struct {
+	ID         string
+	UserID     string
+	ClientID   string
+	TokenID    string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+type sessionStore interface {
+	// SaveSession saves a new session, or replaces the one with the same ID.
+	SaveSession(session *Session) error
+	FindSession(id string) (*Session, error)
+	// FindUserSessions returns the sessions of the user that are neither revoked nor expired.
+	FindUserSessions(userID string) ([]*Session, error)
+	TouchSession(id string, lastSeenAt time.Time) error
+	RevokeSession(id string, revokedAt time.Time) error
+	RevokeUserSessions(userID string, revokedAt time.Time) error
+}
+
+type memorySessionStore struct {
+	sync.Mutex
+	sessions map[string]*Session
+}
+
+func (m *memorySessionStore) SaveSession(session *Session) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = make(map[string]*Session)
+	}
+
+	copied := *session
+	m.sessions[session.ID] = &copied
+
+	return nil
+}
+
+func (m *memorySessionStore) FindSession(id string) (*Session, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	session, ok := m.sessions[id]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *session
+
+	return &copied, nil
+}
+
+func (m *memorySessionStore) FindUserSessions(userID string) ([]*Session, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := TimeFunc()
+	sessions := []*Session{}
+
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *memorySessionStore) TouchSession(id string, lastSeenAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = lastSeenAt
+	}
+
+	return nil
+}
+
+func (m *memorySessionStore) RevokeSession(id string, revokedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if session, ok := m.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &revokedAt
+	}
+
+	return nil
+}
+
+func (m *memorySessionStore) RevokeUserSessions(userID string, revokedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &revokedAt
+		}
+	}
+
+	return nil
+}
+
+// SessionIDFromContext returns the session of the request, which is empty for tokens that
+// belong to none, such as those of service accounts.
+func SessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(ctxKeySessionID).(string)
+	return sessionID
+}
+
+// Sessions returns the active sessions of the user, the most recently seen first.
+func (s *security) Sessions(userID string) ([]*Session, error) {
+	return s.sessionStore.FindUserSessions(userID)
+}
+
+// RevokeSession ends a session of the user. Its refresh tokens can no longer be used, and
+// its access tokens are rejected.
+func (s *security) RevokeSession(userID, sessionID string) error {
+	session, err := s.sessionStore.FindSession(sessionID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if session == nil || session.UserID != userID {
+		return errUnknownSession
+	}
+
+	return s.revokeRefreshTokenFamily(session.ID)
+}
+
+// RevokeAllSessions ends every session of the user, which is the same as revoking all of
+// their tokens.
+func (s *security) RevokeAllSessions(userID string) error {
+	return s.RevokeUserTokens(userID)
+}
+
+// saveSession starts the session of a new login, or records the latest access token of an
+// existing one when its refresh token is rotated.
+func (s *security) saveSession(subj Subject, g *grant, jwtToken *jwtToken, refreshToken *refreshToken) error {
+	session, err := s.sessionStore.FindSession(refreshToken.record.FamilyID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+
+	if session == nil {
+		session = &Session{
+			ID:        refreshToken.record.FamilyID,
+			UserID:    subj.UserID(),
+			ClientID:  g.clientID,
+			UserAgent: g.userAgent,
+			IPAddress: g.ipAddress,
+			CreatedAt: now,
+		}
+	}
+
+	session.TokenID = jwtToken.claims.Id
+	session.LastSeenAt = now
+	session.ExpiresAt = refreshToken.expiresAt
+
+	return s.sessionStore.SaveSession(session)
+}
+
+// checkSession rejects the tokens of revoked sessions, and keeps track of when the session
+// was last seen.
+func (s *security) checkSession(claims *jwtClaims) error {
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	session, err := s.sessionStore.FindSession(claims.SessionID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if session == nil || session.RevokedAt != nil {
+		return errSessionRevoked
+	}
+
+	now := TimeFunc()
+
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+
+	return s.sessionStore.TouchSession(session.ID, now)
+}
+
+// revokeRefreshTokenFamily revokes the refresh tokens of a session, and the session itself.
+func (s *security) revokeRefreshTokenFamily(familyID string) error {
+	now := TimeFunc()
+
+	err := s.refreshTokenStore.RevokeRefreshTokenFamily(familyID, now)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.sessionStore.RevokeSession(familyID, now)
+}
+
+// loginGrant is the grant of a first-party login from the request, in which the user
+// authenticated with the methods amr.
+func (s *security) loginGrant(r *http.Request, amr ...string) *grant {
+	return &grant{
+		authTime:  TimeFunc(),
+		amr:       amr,
+		userAgent: r.UserAgent(),
+		ipAddress: s.clientIP(r),
+	}
+}
+
+type sessionsData struct {
+	Sessions []*Session
+	Current  string
+}
+
+// GetSessions lists the sessions of the current user, so that they can end the ones they do
+// not recognize.
+func (s *security) GetSessions(w http.ResponseWriter, r *http.Request) error {
+	sessions, err := s.Sessions(UserIDFromContext(r.Context()))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmpl, err := ParseTemplate(r, "./templates/sessions.html")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return tmpl.Execute(w, &sessionsData{sessions, SessionIDFromContext(r.Context())})
+}
@@ -0,0 +1,142 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Session", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			Authorize(next http.Handler) http.Handler
+			RefreshToken(w http.ResponseWriter, r *http.Request) error
+			Sessions(userID string) ([]*Session, error)
+			RevokeSession(userID, sessionID string) error
+			RevokeAllSessions(userID string) error
+		}
+	)
+
+	login := func(userAgent string) (*http.Cookie, *http.Cookie) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", userAgent)
+
+		httpgo.ErrorHandlerFunc(security.Authenticate).ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		return findCookie(w, "authgo_token"), findCookie(w, "authgo_refresh_token")
+	}
+
+	authorize := func(tokenCookie *http.Cookie) (int, string) {
+		var sessionID string
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(tokenCookie)
+
+		security.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sessionID = SessionIDFromContext(r.Context())
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+
+		return w.Code, sessionID
+	}
+
+	BeforeEach(func() {
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+	})
+
+	It("should record a session for each login", func() {
+		laptop, _ := login("Firefox")
+		login("Safari")
+
+		sessions, err := security.Sessions(userID)
+
+		Expect(err).To(BeNil())
+		Expect(sessions).To(HaveLen(2))
+
+		code, sessionID := authorize(laptop)
+
+		Expect(code).To(Equal(http.StatusNoContent))
+
+		for _, session := range sessions {
+			Expect(session.IPAddress).To(Equal("192.0.2.1"))
+
+			if session.ID == sessionID {
+				Expect(session.UserAgent).To(Equal("Firefox"))
+			} else {
+				Expect(session.UserAgent).To(Equal("Safari"))
+			}
+		}
+	})
+
+	It("should keep the session when the refresh token is rotated", func() {
+		_, refreshCookie := login("Firefox")
+
+		w := postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		sessions, err := security.Sessions(userID)
+
+		Expect(err).To(BeNil())
+		Expect(sessions).To(HaveLen(1))
+
+		_, sessionID := authorize(findCookie(w, "authgo_token"))
+
+		Expect(sessionID).To(Equal(sessions[0].ID))
+	})
+
+	It("should reject the tokens of a revoked session, and only of that one", func() {
+		laptop, refreshCookie := login("Firefox")
+		phone, _ := login("Safari")
+
+		_, sessionID := authorize(laptop)
+
+		Expect(security.RevokeSession("0c2a4ab6-5804-11e8-8879-afa0dd22785d", sessionID)).NotTo(Succeed())
+		Expect(security.RevokeSession(userID, sessionID)).To(Succeed())
+
+		code, _ := authorize(laptop)
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+		Expect(postForm(security.RefreshToken, "/token/refresh", csrfForm, refreshCookie, csrfCookie).Code).To(Equal(http.StatusUnauthorized))
+
+		code, _ = authorize(phone)
+
+		Expect(code).To(Equal(http.StatusNoContent))
+
+		sessions, err := security.Sessions(userID)
+
+		Expect(err).To(BeNil())
+		Expect(sessions).To(HaveLen(1))
+		Expect(sessions[0].UserAgent).To(Equal("Safari"))
+	})
+
+	It("should end every session when all are revoked", func() {
+		laptop, _ := login("Firefox")
+
+		Expect(security.RevokeAllSessions(userID)).To(Succeed())
+
+		code, _ := authorize(laptop)
+
+		Expect(code).To(Equal(http.StatusUnauthorized))
+		Expect(security.Sessions(userID)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,36 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// singleUseStore remembers the values that may only be used once, such as pending logins and
+// client assertions. Their ids are not token ids, but are made up of a prefix and the value.
+type singleUseStore interface {
+	// UseOnce reports whether the id was not used before, and remembers it until expiresAt.
+	// It checks and remembers in one step, so that only one of concurrent uses is the first.
+	UseOnce(id string, expiresAt time.Time) (bool, error)
+}
+
+type memorySingleUseStore struct {
+	sync.Mutex
+	used map[string]time.Time
+}
+
+func (m *memorySingleUseStore) UseOnce(id string, expiresAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.used == nil {
+		m.used = make(map[string]time.Time)
+	}
+
+	if _, ok := m.used[id]; ok {
+		return false, nil
+	}
+
+	m.used[id] = expiresAt
+
+	return true, nil
+}
@@ -0,0 +1,215 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ACRSingleFactor is the assurance level of a login with the password alone.
+	ACRSingleFactor = "aal1"
+	// ACRMultiFactor is the assurance level of a login with a second factor, or with a
+	// passkey that verified the user.
+	ACRMultiFactor            = "aal2"
+	amrPassword               = "pwd"
+	amrOTP                    = "otp"
+	amrWebAuthn               = "webauthn"
+	eventTypeReauthenticated  = "USER_REAUTHENTICATED"
+	oauthErrorStepUp          = "insufficient_user_authentication"
+	ctxKeyAuthTime            = contextKeyAuthTime("ctxKeyAuthTime")
+	ctxKeyAuthenticationLevel = contextKeyAuthenticationLevel("ctxKeyAuthenticationLevel")
+	ctxKeyAuthMethods         = contextKeyAuthMethods("ctxKeyAuthMethods")
+)
+
+var (
+	errReauthenticationNotAllowed = errors.New("authgo: only the sessions of users can be reauthenticated")
+	errStepUpRequired             = errors.New("authgo: a more recent or stronger authentication is required")
+)
+
+// acrLevels are the assurance levels, from the weakest.
+var acrLevels = []string{ACRSingleFactor, ACRMultiFactor}
+
+type contextKeyAuthTime contextKey
+type contextKeyAuthenticationLevel contextKey
+type contextKeyAuthMethods contextKey
+
+// AuthTimeFromContext returns when the user of the request last authenticated. It is zero
+// when they never did, as for personal access tokens.
+func AuthTimeFromContext(ctx context.Context) time.Time {
+	authTime, _ := ctx.Value(ctxKeyAuthTime).(int64)
+
+	if authTime == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(authTime, 0)
+}
+
+// ACRFromContext returns the assurance level of the last authentication of the user.
+func ACRFromContext(ctx context.Context) string {
+	acr, _ := ctx.Value(ctxKeyAuthenticationLevel).(string)
+	return acr
+}
+
+// AMRFromContext returns the methods of the last authentication of the user.
+func AMRFromContext(ctx context.Context) []string {
+	amr, _ := ctx.Value(ctxKeyAuthMethods).([]string)
+	return amr
+}
+
+// AuthenticatedRecently reports whether the user of the request authenticated within maxAge,
+// at the assurance level acr or above. An empty acr accepts any level. Impersonation never
+// passes, as the user did not authenticate, and the actor cannot reauthenticate as them.
+func AuthenticatedRecently(ctx context.Context, maxAge time.Duration, acr string) bool {
+	if ImpersonatingFromContext(ctx) {
+		return false
+	}
+
+	authTime := AuthTimeFromContext(ctx)
+
+	if authTime.IsZero() || TimeFunc().Sub(authTime) > maxAge {
+		return false
+	}
+
+	return acr == "" || acrRank(ACRFromContext(ctx)) >= acrRank(acr)
+}
+
+// RequireStepUp only lets requests through whose user authenticated within maxAge, at the
+// assurance level acr or above. Others are challenged as in RFC 9470, so that clients know to
+// send the user through the reauthentication first. It must come after Authorize.
+func RequireStepUp(maxAge time.Duration, acr string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return httpgo.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			if !AuthenticatedRecently(r.Context(), maxAge, acr) {
+				w.Header().Set(headerWWWAuthenticate, stepUpChallenge(maxAge, acr))
+
+				return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, errStepUpRequired))
+			}
+
+			next.ServeHTTP(w, r)
+
+			return nil
+		})
+	}
+}
+
+func stepUpChallenge(maxAge time.Duration, acr string) string {
+	challenge := fmt.Sprintf(`%s, error="%s", max_age=%d`, bearerChallenge, oauthErrorStepUp, int64(maxAge/time.Second))
+
+	if acr != "" {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, acr)
+	}
+
+	return challenge
+}
+
+// acrOf returns the assurance level that the authentication methods reach.
+func acrOf(amr []string) string {
+	if containsAny(amr, []string{amrOTP, amrWebAuthn}) {
+		return ACRMultiFactor
+	}
+
+	if containsAny(amr, []string{amrPassword}) {
+		return ACRSingleFactor
+	}
+
+	return ""
+}
+
+func acrRank(acr string) int {
+	for i, level := range acrLevels {
+		if level == acr {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Reauthenticate checks the password of the user again, and the code of their second factor
+// when they have one, and upgrades the current session with a fresh token. The session keeps
+// its refresh tokens, while the new ones carry the new authentication.
+func (s *security) Reauthenticate(w http.ResponseWriter, r *http.Request) error {
+	authZ, err := s.authorizeRequest(r)
+
+	if err != nil {
+		w.Header().Set(headerWWWAuthenticate, bearerChallenge)
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+	}
+
+	claims := authZ.jwtClaims
+
+	// Tokens handed out to clients, and those of machines or impersonators, cannot be
+	// upgraded by the user.
+	if claims.SessionID == "" || claims.ClientID != "" || claims.PrincipalType != "" || claims.Actor != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, errReauthenticationNotAllowed))
+	}
+
+	authN, err := s.reauthenticationRequest(r, claims)
+
+	if err != nil {
+		return authenticationError(w, err)
+	}
+
+	setAuthenticationCookie(w, authN)
+
+	return httpgo.WriteJSON(w, http.StatusOK, newTokenResponse(authN))
+}
+
+func (s *security) reauthenticationRequest(r *http.Request, claims *jwtClaims) (*authentication, error) {
+	err := r.ParseForm()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	subj, err := s.resolveSubject(r, claims.Subject, r.PostForm.Get(formKeyPassword))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if subj.UserID() != claims.UserID {
+		return nil, errInvalidCredentials
+	}
+
+	amr := []string{amrPassword}
+	factor, err := s.mfaStore.FindTOTPFactor(subj.UserID())
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if factor != nil && factor.ConfirmedAt != nil {
+		_, err = s.verifySecondFactor(r.Context(), factor, r.PostForm.Get(formKeyCode))
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		amr = append(amr, amrOTP)
+	}
+
+	g := s.loginGrant(r, amr...)
+	g.refreshTokenFamilyID = claims.SessionID
+
+	authN, err := s.createAuthentication(subj, g)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = s.events.RecordEvent(r.Context(), subj.UserID(), eventTypeReauthenticated, fmt.Sprintf("Reauthenticated at %q.", acrOf(amr)))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return authN, nil
+}
@@ -0,0 +1,124 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Step-up", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			Authorize(next http.Handler) http.Handler
+			Reauthenticate(w http.ResponseWriter, r *http.Request) error
+		}
+		events *eventLog
+		now    time.Time
+	)
+
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+	}
+
+	login := func() *http.Cookie {
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		return findCookie(w, "authgo_token")
+	}
+
+	serve := func(handler http.Handler, token *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(token)
+
+		security.Authorize(handler).ServeHTTP(w, r)
+
+		return w
+	}
+
+	noContent := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	BeforeEach(func() {
+		now = time.Now()
+		TimeFunc = func() time.Time {
+			return now
+		}
+
+		events = &eventLog{}
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")}, WithEventRecorder(events))
+	})
+
+	AfterEach(func() {
+		TimeFunc = time.Now
+	})
+
+	It("should put how the user authenticated in the context", func() {
+		var served *http.Request
+
+		serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = r
+		}), login())
+
+		Expect(AuthTimeFromContext(served.Context()).Unix()).To(Equal(now.Unix()))
+		Expect(AMRFromContext(served.Context())).To(Equal([]string{"pwd"}))
+		Expect(ACRFromContext(served.Context())).To(Equal(ACRSingleFactor))
+	})
+
+	It("should challenge stale authentications", func() {
+		token := login()
+		handler := RequireStepUp(5*time.Minute, "")(noContent)
+
+		w := serve(handler, token)
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		advance(6 * time.Minute)
+
+		w = serve(handler, token)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error="insufficient_user_authentication", max_age=300`))
+	})
+
+	It("should challenge weak authentications", func() {
+		w := serve(RequireStepUp(5*time.Minute, ACRMultiFactor)(noContent), login())
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("WWW-Authenticate")).To(ContainSubstring(`acr_values="aal2"`))
+	})
+
+	It("should upgrade the session when the user reauthenticates", func() {
+		token := login()
+
+		advance(6 * time.Minute)
+
+		w := postForm(security.Reauthenticate, "/reauthenticate", url.Values{"password": {"wrong"}}, token)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = postForm(security.Reauthenticate, "/reauthenticate", url.Values{"password": {"secret"}}, token)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(*events).To(ContainElement(recordedEvent{userID, "USER_REAUTHENTICATED"}))
+
+		w = serve(RequireStepUp(5*time.Minute, "")(noContent), findCookie(w, "authgo_token"))
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+})
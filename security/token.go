@@ -1,7 +1,10 @@
 package security
 
 import (
-	"os"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -9,9 +12,47 @@ import (
 	"github.com/satori/go.uuid"
 )
 
+const (
+	accessTokenLifetime = 15 * time.Minute
+	opaqueTokenSize     = 32
+	tokenTypeBearer     = "Bearer"
+	bearerChallenge     = `Bearer realm="authgo"`
+)
+
 type jwtClaims struct {
 	*jwt.StandardClaims
-	UserID string `json:"uid"`
+	UserID   string `json:"uid"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	// PrincipalType marks tokens of machine principals. It is empty for users.
+	PrincipalType string   `json:"principal_type,omitempty"`
+	SessionID     string   `json:"sid,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	Authorities   []string `json:"authorities,omitempty"`
+	// Actor is who really acts, when the token impersonates the user.
+	Actor *actorClaim `json:"act,omitempty"`
+	// AMR are the methods the user authenticated with at AuthTime, and ACR the assurance
+	// level they reach.
+	AMR []string `json:"amr,omitempty"`
+	ACR string   `json:"acr,omitempty"`
+	// IssuedAtNano is IssuedAt in nanoseconds, which tells the tokens issued in the second
+	// of a revocation apart.
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+}
+
+// grant describes what a token is issued for. Without a client it is a first-party login.
+type grant struct {
+	clientID             string
+	scope                string
+	refreshTokenFamilyID string
+	authTime             time.Time
+	nonce                string
+	serviceAccount       bool
+	userAgent            string
+	ipAddress            string
+	actor                *actorClaim
+	amr                  []string
 }
 
 type jwtToken struct {
@@ -20,7 +61,25 @@ type jwtToken struct {
 	expiresAt   time.Time
 }
 
-func createToken(subj Subject) (*jwtToken, error) {
+type tokenResponse struct {
+	AccessToken           string    `json:"accessToken"`
+	TokenType             string    `json:"tokenType"`
+	ExpiresAt             time.Time `json:"expiresAt"`
+	RefreshToken          string    `json:"refreshToken"`
+	RefreshTokenExpiresAt time.Time `json:"refreshTokenExpiresAt"`
+}
+
+func newTokenResponse(authN *authentication) *tokenResponse {
+	return &tokenResponse{
+		AccessToken:           authN.jwtToken.signedToken,
+		TokenType:             tokenTypeBearer,
+		ExpiresAt:             authN.jwtToken.expiresAt,
+		RefreshToken:          authN.refreshToken.value,
+		RefreshTokenExpiresAt: authN.refreshToken.expiresAt,
+	}
+}
+
+func (s *security) createToken(subj Subject, g *grant) (*jwtToken, error) {
 	id, err := uuid.NewV1()
 
 	if err != nil {
@@ -28,28 +87,48 @@ func createToken(subj Subject) (*jwtToken, error) {
 	}
 
 	now := TimeFunc()
-	expiresAt := now.AddDate(0, 0, 1)
+	lifetime := accessTokenLifetime
+
+	if g.actor != nil {
+		lifetime = impersonationLifetime
+	}
+
+	expiresAt := now.Add(lifetime)
 	claims := &jwtClaims{
 		&jwt.StandardClaims{
 			Audience:  jwtAudience,
 			ExpiresAt: expiresAt.Unix(),
 			Id:        id.String(),
 			IssuedAt:  now.Unix(),
-			Issuer:    jwtIssuer,
+			Issuer:    s.issuer,
 			NotBefore: now.Unix(),
 			Subject:   subj.UserEmail(),
 		},
 		subj.UserID(),
+		g.clientID,
+		g.scope,
+		g.authTime.Unix(),
+		"",
+		g.refreshTokenFamilyID,
+		nil,
+		nil,
+		g.actor,
+		g.amr,
+		acrOf(g.amr),
+		now.UnixNano(),
+	}
+
+	if g.serviceAccount {
+		claims.PrincipalType = principalTypeServiceAccount
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	securityKey, err := resolveSecurityKey(token)
+	err = s.addAuthorities(claims, subj, g)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	signedToken, err := token.SignedString(securityKey)
+	signedToken, err := s.signToken(claims)
 
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -58,16 +137,73 @@ func createToken(subj Subject) (*jwtToken, error) {
 	return &jwtToken{signedToken, claims, expiresAt}, nil
 }
 
-func resolveSecurityKey(t *jwt.Token) (interface{}, error) {
-	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-		return nil, errInvalidSigningMethod
+func (s *security) signToken(claims jwt.Claims) (string, error) {
+	k, err := s.keys.signingKey()
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header[jwtHeaderKeyID] = k.id
+
+	signedToken, err := token.SignedString(k.private)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return signedToken, nil
+}
+
+// The tokens are validated against TimeFunc, which tests may replace. It is set once, as
+// jwt-go reads it while other requests parse their tokens.
+func init() {
+	jwt.TimeFunc = func() time.Time {
+		return TimeFunc()
+	}
+}
+
+func (s *security) parseToken(signedToken string) (*jwtClaims, error) {
+	claims := &jwtClaims{}
+	token, err := jwt.ParseWithClaims(signedToken, claims, s.keys.verificationKey)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !token.Valid {
+		return nil, errors.New("authgo: invalid token")
 	}
 
-	securityKey, ok := os.LookupEnv(environmentSecurityKey)
+	if err := claims.Valid(); err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	if !ok {
-		return nil, errMissingSecurityKey
+	// Tokens for other audiences, such as ID tokens, are no access tokens.
+	if !claims.VerifyAudience(jwtAudience, true) {
+		return nil, errors.New("authgo: invalid audience")
 	}
 
-	return []byte(securityKey), nil
+	return claims, nil
+}
+
+// generateOpaqueToken returns a random token for values that are looked up server-side.
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenSize)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashOpaqueToken hashes an opaque token for storage. The tokens carry enough entropy that
+// a plain SHA-256 is sufficient.
+func hashOpaqueToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,112 @@
+package security
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	headerAuthUserID      = "X-Auth-User-Id"
+	headerAuthEmail       = "X-Auth-Email"
+	headerAuthAuthorities = "X-Auth-Authorities"
+	headerAccept          = "Accept"
+	headerForwardedProto  = "X-Forwarded-Proto"
+	headerForwardedHost   = "X-Forwarded-Host"
+	headerForwardedURI    = "X-Forwarded-Uri"
+	queryKeyRole          = "role"
+	queryKeyAuthority     = "authority"
+	// environmentRedirectHosts is the comma separated list of the hosts, such as
+	// "wiki.example.com", that users may be sent back to after the login.
+	environmentRedirectHosts = "AUTHGO_REDIRECT_HOSTS"
+)
+
+// GetVerify is the forward-auth endpoint, which lets a reverse proxy ask whether the request it
+// is about to pass on is authorized. The proxy sends along the cookies and headers of the
+// request. The repeatable role and authority query parameters require the user to have at
+// least one of the roles, and at least one of the authorities.
+//
+// Authorized requests are answered with 200 and the X-Auth-* headers, which the proxy should
+// copy to the upstream request. Browsers that are not signed in are redirected to the login
+// when the proxy gives the original URI in X-Forwarded-Uri, as Traefik and Caddy do, and
+// everyone else gets 401. They are sent back to the URL rebuilt from X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Uri after the login, if its host is one of
+// AUTHGO_REDIRECT_HOSTS, and to this server otherwise. Since nginx does not relay the response of auth_request, it should
+// map 401 to the login with error_page, and send the subrequest with proxy_method GET.
+func (s *security) GetVerify(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set(headerCacheControl, "no-store")
+
+	authZ, err := s.authorizeRequest(r)
+
+	if err != nil {
+		if uri := r.Header.Get(headerForwardedURI); uri != "" && acceptsHTML(r) {
+			http.Redirect(w, r, loginPath+"?"+url.Values{queryKeyRedirect: {s.safeRedirect(forwardedURL(r, uri))}}.Encode(), http.StatusFound)
+
+			return nil
+		}
+
+		w.Header().Set(headerWWWAuthenticate, bearerChallenge)
+
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, err))
+	}
+
+	query := r.URL.Query()
+	roles := query[queryKeyRole]
+	authorities := query[queryKeyAuthority]
+
+	if len(roles) > 0 && !containsAny(authZ.jwtClaims.Roles, roles) {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, ErrForbidden))
+	}
+
+	if len(authorities) > 0 && !containsAny(authZ.jwtClaims.Authorities, authorities) {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusForbidden, ErrForbidden))
+	}
+
+	w.Header().Set(headerAuthUserID, authZ.jwtClaims.UserID)
+	w.Header().Set(headerAuthEmail, authZ.jwtClaims.Subject)
+	w.Header().Set(headerAuthAuthorities, strings.Join(authZ.jwtClaims.Authorities, ","))
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(headerAccept), "text/html")
+}
+
+// forwardedURL is the URL the proxy was asked for. Without X-Forwarded-Host it is only the
+// URI, which is a path on this server.
+func forwardedURL(r *http.Request, uri string) string {
+	host := r.Header.Get(headerForwardedHost)
+
+	if host == "" || !strings.HasPrefix(uri, "/") {
+		return uri
+	}
+
+	scheme := "https"
+
+	if r.Header.Get(headerForwardedProto) == "http" {
+		scheme = "http"
+	}
+
+	return scheme + "://" + host + uri
+}
+
+// resolveRedirectHosts reads the hosts that users may be redirected to from the environment.
+func resolveRedirectHosts() []string {
+	var hosts []string
+
+	for _, host := range strings.Split(os.Getenv(environmentRedirectHosts), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
@@ -0,0 +1,124 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+var _ = Describe("Verify", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	var (
+		security interface {
+			Authenticate(w http.ResponseWriter, r *http.Request) error
+			GetVerify(w http.ResponseWriter, r *http.Request) error
+			PostLogin(w http.ResponseWriter, r *http.Request) error
+		}
+		token *http.Cookie
+	)
+
+	verify := func(target string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+
+		for key, values := range header {
+			r.Header[key] = values
+		}
+
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		httpgo.ErrorHandlerFunc(security.GetVerify).ServeHTTP(w, r)
+
+		return w
+	}
+
+	BeforeEach(func() {
+		security = New(
+			subjects{newSubject(userID, "erik@eies.land", "secret")},
+			WithAuthorityFinder(grants{userID: {Roles: []string{"ADMIN"}, Authorities: []string{"READ_USERS", "WRITE_USERS"}}}),
+		)
+
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		token = findCookie(w, "authgo_token")
+	})
+
+	It("should describe the user of an authorized request", func() {
+		w := verify("/verify", nil, token)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("X-Auth-User-Id")).To(Equal(userID))
+		Expect(w.Header().Get("X-Auth-Email")).To(Equal("erik@eies.land"))
+		Expect(w.Header().Get("X-Auth-Authorities")).To(Equal("READ_USERS,WRITE_USERS"))
+	})
+
+	It("should require the roles and authorities of the query", func() {
+		Expect(verify("/verify?role=ADMIN&authority=READ_CLIENTS&authority=WRITE_USERS", nil, token).Code).To(Equal(http.StatusOK))
+		Expect(verify("/verify?role=SUPPORT", nil, token).Code).To(Equal(http.StatusForbidden))
+		Expect(verify("/verify?authority=READ_CLIENTS", nil, token).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should reject unauthorized requests", func() {
+		w := verify("/verify", nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Header().Get("X-Auth-User-Id")).To(BeEmpty())
+	})
+
+	It("should redirect browsers to the login", func() {
+		w := verify("/verify", http.Header{"Accept": {"text/html"}, "X-Forwarded-Uri": {"/wiki/page?id=1"}})
+
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal("/login?rd=%2Fwiki%2Fpage%3Fid%3D1"))
+
+		w = verify("/verify", http.Header{"Accept": {"text/html"}, "X-Forwarded-Uri": {"//evil.example"}})
+
+		Expect(w.Header().Get("Location")).To(Equal("/login?rd=%2F"))
+	})
+
+	It("should send browsers back to the forwarded URL of an allowed host", func() {
+		os.Setenv("AUTHGO_REDIRECT_HOSTS", "wiki.example.com")
+		defer os.Unsetenv("AUTHGO_REDIRECT_HOSTS")
+
+		security = New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+
+		w := verify("/verify", http.Header{
+			"Accept":            {"text/html"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"wiki.example.com"},
+			"X-Forwarded-Uri":   {"/page?id=1"},
+		})
+
+		Expect(w.Header().Get("Location")).To(Equal("/login?rd=" + url.QueryEscape("https://wiki.example.com/page?id=1")))
+
+		w = postForm(security.PostLogin, "/login", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}, "rd": {"https://wiki.example.com/page?id=1"}})
+
+		Expect(w.Code).To(Equal(http.StatusSeeOther))
+		Expect(w.Header().Get("Location")).To(Equal("https://wiki.example.com/page?id=1"))
+
+		w = verify("/verify", http.Header{
+			"Accept":            {"text/html"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"evil.example"},
+			"X-Forwarded-Uri":   {"/page"},
+		})
+
+		Expect(w.Header().Get("Location")).To(Equal("/login?rd=%2F"))
+	})
+})
@@ -0,0 +1,865 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/di0nys1us/httpgo"
+	"github.com/pkg/errors"
+)
+
+const (
+	environmentWebAuthnRPID          = "AUTHGO_WEBAUTHN_RP_ID"
+	environmentWebAuthnOrigin        = "AUTHGO_WEBAUTHN_ORIGIN"
+	defaultWebAuthnRPID              = "localhost"
+	defaultWebAuthnOrigin            = "http://localhost:3000"
+	webAuthnRPName                   = "authgo"
+	webAuthnCeremonyLifetime         = 5 * time.Minute
+	webAuthnCeremonyAudience         = "authgo:webauthn"
+	webAuthnCeremonyIDPrefix         = "webauthn:"
+	webAuthnChallengeSize            = 32
+	webAuthnTypeCreate               = "webauthn.create"
+	webAuthnTypeGet                  = "webauthn.get"
+	webAuthnCredentialType           = "public-key"
+	webAuthnAttestationNone          = "none"
+	webAuthnRequirementRequired      = "required"
+	webAuthnRequirementPreferred     = "preferred"
+	webAuthnRequirementDiscouraged   = "discouraged"
+	authDataFlagUserPresent          = 0x01
+	authDataFlagUserVerified         = 0x04
+	authDataFlagAttestedCredential   = 0x40
+	authDataMinLength                = 37
+	coseKeyType                      = 1
+	coseKeyAlgorithm                 = 3
+	coseKeyCurve                     = -1
+	coseKeyX                         = -2
+	coseKeyY                         = -3
+	coseKeyTypeOKP                   = 1
+	coseKeyTypeEC2                   = 2
+	coseKeyTypeRSA                   = 3
+	coseAlgorithmES256               = -7
+	coseAlgorithmEdDSA               = -8
+	coseAlgorithmRS256               = -257
+	coseCurveP256                    = 1
+	coseCurveEd25519                 = 6
+	webAuthnTimeout                  = int(webAuthnCeremonyLifetime / time.Millisecond)
+	maxWebAuthnCredentialNameLength  = 64
+	defaultWebAuthnCredentialName    = "Passkey"
+	webAuthnAttestationObjectAuthKey = "authData"
+)
+
+var (
+	errInvalidWebAuthnCeremony   = errors.New("authgo: invalid or expired webauthn ceremony")
+	errInvalidWebAuthnCredential = errors.New("authgo: invalid webauthn credential")
+	errUnsupportedCOSEKey        = errors.New("authgo: unsupported COSE key")
+	webAuthnAlgorithms           = []int{coseAlgorithmES256, coseAlgorithmEdDSA, coseAlgorithmRS256}
+)
+
+// WebAuthnCredential is a registered WebAuthn credential, such as a passkey or a security
+// key. The ID is the base64url encoded credential id and PublicKey is the COSE encoded key.
+type WebAuthnCredential struct {
+	ID         string
+	UserID     string
+	Name       string
+	PublicKey  []byte
+	SignCount  int64
+	Transports []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+type webAuthnCredentialStore interface {
+	FindWebAuthnCredentials(userID string) ([]*WebAuthnCredential, error)
+	FindWebAuthnCredential(id string) (*WebAuthnCredential, error)
+	// SaveWebAuthnCredential stores the credential, as registered by the actor of the context.
+	SaveWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+	// UseWebAuthnCredential records the sign count of an assertion, and reports false if it
+	// did not increase. Authenticators that do not count always report zero.
+	UseWebAuthnCredential(id string, signCount int64, usedAt time.Time) (bool, error)
+	// DeleteWebAuthnCredential removes the credential, as removed by the actor of the context.
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) error
+}
+
+type memoryWebAuthnCredentialStore struct {
+	sync.Mutex
+	credentials map[string]*WebAuthnCredential
+}
+
+func (m *memoryWebAuthnCredentialStore) FindWebAuthnCredentials(userID string) ([]*WebAuthnCredential, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	credentials := []*WebAuthnCredential{}
+
+	for _, credential := range m.credentials {
+		if credential.UserID == userID {
+			copied := *credential
+			credentials = append(credentials, &copied)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (m *memoryWebAuthnCredentialStore) FindWebAuthnCredential(id string) (*WebAuthnCredential, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	credential, ok := m.credentials[id]
+
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *credential
+
+	return &copied, nil
+}
+
+func (m *memoryWebAuthnCredentialStore) SaveWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.credentials == nil {
+		m.credentials = make(map[string]*WebAuthnCredential)
+	}
+
+	copied := *credential
+	m.credentials[credential.ID] = &copied
+
+	return nil
+}
+
+func (m *memoryWebAuthnCredentialStore) UseWebAuthnCredential(id string, signCount int64, usedAt time.Time) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	credential, ok := m.credentials[id]
+
+	if !ok || !signCountIncreased(credential.SignCount, signCount) {
+		return false, nil
+	}
+
+	credential.SignCount = signCount
+	credential.LastUsedAt = &usedAt
+
+	return true, nil
+}
+
+func (m *memoryWebAuthnCredentialStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if credential, ok := m.credentials[id]; ok && credential.UserID == userID {
+		delete(m.credentials, id)
+	}
+
+	return nil
+}
+
+// webAuthnConfig is the relying party. The origin is where the login page is served from,
+// and the RP ID its domain, or a parent domain of it.
+type webAuthnConfig struct {
+	rpID   string
+	origin string
+}
+
+type webAuthnCeremonyClaims struct {
+	*jwt.StandardClaims
+	UserID       string `json:"uid,omitempty"`
+	Challenge    string `json:"challenge"`
+	Type         string `json:"type"`
+	SecondFactor bool   `json:"second_factor,omitempty"`
+}
+
+type webAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type webAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type webAuthnCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type webAuthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type webAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// webAuthnCreationOptions and webAuthnRequestOptions are handed to the browser. Binary
+// values are base64url encoded, the ceremony is returned with the response of the browser.
+type webAuthnCreationOptions struct {
+	Ceremony  string `json:"ceremony"`
+	PublicKey struct {
+		Challenge              string                         `json:"challenge"`
+		RP                     webAuthnRelyingParty           `json:"rp"`
+		User                   webAuthnUser                   `json:"user"`
+		PubKeyCredParams       []webAuthnCredentialParameters `json:"pubKeyCredParams"`
+		Timeout                int                            `json:"timeout"`
+		ExcludeCredentials     []webAuthnCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection webAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                         `json:"attestation"`
+	} `json:"publicKey"`
+}
+
+type webAuthnRequestOptions struct {
+	Ceremony  string `json:"ceremony"`
+	PublicKey struct {
+		Challenge        string                         `json:"challenge"`
+		RPID             string                         `json:"rpId"`
+		Timeout          int                            `json:"timeout"`
+		AllowCredentials []webAuthnCredentialDescriptor `json:"allowCredentials"`
+		UserVerification string                         `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+type webAuthnRegistrationRequest struct {
+	Ceremony   string `json:"ceremony"`
+	Name       string `json:"name"`
+	Credential struct {
+		ID       string `json:"id"`
+		Response struct {
+			ClientDataJSON    string   `json:"clientDataJSON"`
+			AttestationObject string   `json:"attestationObject"`
+			Transports        []string `json:"transports"`
+		} `json:"response"`
+	} `json:"credential"`
+}
+
+type webAuthnAssertionRequest struct {
+	Ceremony   string `json:"ceremony"`
+	Credential struct {
+		ID       string `json:"id"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AuthenticatorData string `json:"authenticatorData"`
+			Signature         string `json:"signature"`
+			UserHandle        string `json:"userHandle"`
+		} `json:"response"`
+	} `json:"credential"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// BeginWebAuthnRegistration returns the options to create a credential for the current user.
+func (s *security) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) error {
+	subj, err := s.FindSubjectByID(UserIDFromContext(r.Context()))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if subj == nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusUnauthorized, errors.New("authgo: subject is nil")))
+	}
+
+	credentials, err := s.webAuthnCredentialStore.FindWebAuthnCredentials(subj.UserID())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ceremony, challenge, err := s.createWebAuthnCeremony(&webAuthnCeremonyClaims{UserID: subj.UserID(), Type: webAuthnTypeCreate})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	options := &webAuthnCreationOptions{Ceremony: ceremony}
+	options.PublicKey.Challenge = challenge
+	options.PublicKey.RP = webAuthnRelyingParty{s.webAuthn.rpID, webAuthnRPName}
+	options.PublicKey.User = webAuthnUser{
+		ID:          base64.RawURLEncoding.EncodeToString([]byte(subj.UserID())),
+		Name:        subj.UserEmail(),
+		DisplayName: strings.TrimSpace(subj.UserFirstName() + " " + subj.UserLastName()),
+	}
+	options.PublicKey.Timeout = webAuthnTimeout
+	options.PublicKey.ExcludeCredentials = newWebAuthnCredentialDescriptors(credentials)
+	options.PublicKey.AuthenticatorSelection = webAuthnAuthenticatorSelection{webAuthnRequirementPreferred, webAuthnRequirementPreferred}
+	options.PublicKey.Attestation = webAuthnAttestationNone
+
+	for _, alg := range webAuthnAlgorithms {
+		options.PublicKey.PubKeyCredParams = append(options.PublicKey.PubKeyCredParams, webAuthnCredentialParameters{webAuthnCredentialType, alg})
+	}
+
+	return httpgo.WriteJSON(w, http.StatusOK, options)
+}
+
+// FinishWebAuthnRegistration verifies and stores the credential created by the browser.
+// Attestation statements are not verified, any authenticator is accepted.
+func (s *security) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) error {
+	request := &webAuthnRegistrationRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	credential, err := s.verifyWebAuthnRegistration(UserIDFromContext(r.Context()), request)
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	err = s.webAuthnCredentialStore.SaveWebAuthnCredential(r.Context(), credential)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return httpgo.WriteJSON(w, http.StatusCreated, map[string]string{"id": credential.ID, "name": credential.Name})
+}
+
+// BeginWebAuthnLogin returns the options to sign in with a passkey. When the password was
+// already checked and the login waits for its second factor, the credentials of that user
+// are asked for instead.
+func (s *security) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) error {
+	err := r.ParseForm()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	claims := &webAuthnCeremonyClaims{Type: webAuthnTypeGet}
+	credentials := []*WebAuthnCredential{}
+	userVerification := webAuthnRequirementRequired
+
+	if pending, err := s.parseMFAPending(r); err == nil {
+		claims.UserID = pending.UserID
+		claims.SecondFactor = true
+		userVerification = webAuthnRequirementDiscouraged
+
+		credentials, err = s.webAuthnCredentialStore.FindWebAuthnCredentials(pending.UserID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	ceremony, challenge, err := s.createWebAuthnCeremony(claims)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	options := &webAuthnRequestOptions{Ceremony: ceremony}
+	options.PublicKey.Challenge = challenge
+	options.PublicKey.RPID = s.webAuthn.rpID
+	options.PublicKey.Timeout = webAuthnTimeout
+	options.PublicKey.AllowCredentials = newWebAuthnCredentialDescriptors(credentials)
+	options.PublicKey.UserVerification = userVerification
+
+	return httpgo.WriteJSON(w, http.StatusOK, options)
+}
+
+// FinishWebAuthnLogin verifies the assertion of the browser and completes the login.
+func (s *security) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) error {
+	request := &webAuthnAssertionRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+
+	if err != nil {
+		return errors.WithStack(httpgo.ErrorWithStatusCode(http.StatusBadRequest, err))
+	}
+
+	subj, amr, err := s.verifyWebAuthnAssertion(request)
+
+	if err != nil {
+		return authenticationError(w, err)
+	}
+
+	authN, err := s.createAuthentication(subj, s.loginGrant(r, amr...))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	http.SetCookie(w, logoutMFAPendingCookie)
+	setAuthenticationCookie(w, authN)
+
+	return httpgo.WriteJSON(w, http.StatusOK, newTokenResponse(authN))
+}
+
+// RemoveWebAuthnCredential removes a credential of the user.
+func (s *security) RemoveWebAuthnCredential(ctx context.Context, userID, id string) error {
+	return s.webAuthnCredentialStore.DeleteWebAuthnCredential(ctx, userID, id)
+}
+
+func (s *security) createWebAuthnCeremony(claims *webAuthnCeremonyClaims) (string, string, error) {
+	challenge := make([]byte, webAuthnChallengeSize)
+
+	_, err := rand.Read(challenge)
+
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	id, err := generateOpaqueToken()
+
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	now := TimeFunc()
+	claims.StandardClaims = &jwt.StandardClaims{
+		Audience:  webAuthnCeremonyAudience,
+		ExpiresAt: now.Add(webAuthnCeremonyLifetime).Unix(),
+		Id:        id,
+		IssuedAt:  now.Unix(),
+		Issuer:    s.issuer,
+	}
+	claims.Challenge = base64.RawURLEncoding.EncodeToString(challenge)
+
+	ceremony, err := s.signToken(claims)
+
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	return ceremony, claims.Challenge, nil
+}
+
+// parseWebAuthnCeremony verifies the ceremony returned by the browser. Each ceremony can
+// only be finished once.
+func (s *security) parseWebAuthnCeremony(ceremony, ceremonyType string) (*webAuthnCeremonyClaims, error) {
+	claims := &webAuthnCeremonyClaims{}
+	token, err := jwt.ParseWithClaims(ceremony, claims, s.keys.verificationKey)
+
+	if err != nil || !token.Valid {
+		return nil, errInvalidWebAuthnCeremony
+	}
+
+	if claims.Valid() != nil || !claims.VerifyAudience(webAuthnCeremonyAudience, true) || claims.Type != ceremonyType {
+		return nil, errInvalidWebAuthnCeremony
+	}
+
+	first, err := s.singleUseStore.UseOnce(webAuthnCeremonyIDPrefix+claims.Id, time.Unix(claims.ExpiresAt, 0))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !first {
+		return nil, errInvalidWebAuthnCeremony
+	}
+
+	return claims, nil
+}
+
+func (s *security) verifyWebAuthnRegistration(userID string, request *webAuthnRegistrationRequest) (*WebAuthnCredential, error) {
+	claims, err := s.parseWebAuthnCeremony(request.Ceremony, webAuthnTypeCreate)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if claims.UserID != userID {
+		return nil, errInvalidWebAuthnCeremony
+	}
+
+	err = s.verifyWebAuthnClientData(request.Credential.Response.ClientDataJSON, claims)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	attestationObject, err := decodeWebAuthnBase64(request.Credential.Response.AttestationObject)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	attestation, _ := decoded.(map[interface{}]interface{})
+	rawAuthData, _ := attestation[webAuthnAttestationObjectAuthKey].([]byte)
+	authData, err := s.parseAuthenticatorData(rawAuthData)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if authData.flags&authDataFlagAttestedCredential == 0 {
+		return nil, errInvalidWebAuthnCredential
+	}
+
+	_, err = parseCOSEKey(authData.publicKey)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(authData.credentialID)
+	existing, err := s.webAuthnCredentialStore.FindWebAuthnCredential(id)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if existing != nil {
+		return nil, errors.New("authgo: webauthn credential already registered")
+	}
+
+	name := strings.TrimSpace(request.Name)
+
+	if name == "" {
+		name = defaultWebAuthnCredentialName
+	}
+
+	if len(name) > maxWebAuthnCredentialNameLength {
+		name = name[:maxWebAuthnCredentialNameLength]
+	}
+
+	return &WebAuthnCredential{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		PublicKey:  authData.publicKey,
+		SignCount:  int64(authData.signCount),
+		Transports: request.Credential.Response.Transports,
+		CreatedAt:  TimeFunc(),
+	}, nil
+}
+
+// verifyWebAuthnAssertion returns the subject the assertion was signed for, and the methods
+// they authenticated with. Passkeys replace both factors, so the user must be verified by the
+// authenticator, unless the password was checked already. As with passwords, users whose
+// email has to be verified first are refused.
+func (s *security) verifyWebAuthnAssertion(request *webAuthnAssertionRequest) (Subject, []string, error) {
+	claims, err := s.parseWebAuthnCeremony(request.Ceremony, webAuthnTypeGet)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	credential, err := s.webAuthnCredentialStore.FindWebAuthnCredential(strings.TrimRight(request.Credential.ID, "="))
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if credential == nil || (claims.UserID != "" && credential.UserID != claims.UserID) {
+		return nil, nil, errInvalidWebAuthnCredential
+	}
+
+	if request.Credential.Response.UserHandle != "" {
+		userHandle, err := decodeWebAuthnBase64(request.Credential.Response.UserHandle)
+
+		if err != nil || string(userHandle) != credential.UserID {
+			return nil, nil, errInvalidWebAuthnCredential
+		}
+	}
+
+	err = s.verifyWebAuthnClientData(request.Credential.Response.ClientDataJSON, claims)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	rawAuthData, err := decodeWebAuthnBase64(request.Credential.Response.AuthenticatorData)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	authData, err := s.parseAuthenticatorData(rawAuthData)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !claims.SecondFactor && authData.flags&authDataFlagUserVerified == 0 {
+		return nil, nil, errors.New("authgo: user was not verified by the authenticator")
+	}
+
+	signature, err := decodeWebAuthnBase64(request.Credential.Response.Signature)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	clientDataJSON, _ := decodeWebAuthnBase64(request.Credential.Response.ClientDataJSON)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	public, err := parseCOSEKey(credential.PublicKey)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !verifyWebAuthnSignature(public, append(rawAuthData, clientDataHash[:]...), signature) {
+		return nil, nil, errInvalidWebAuthnCredential
+	}
+
+	used, err := s.webAuthnCredentialStore.UseWebAuthnCredential(credential.ID, int64(authData.signCount), TimeFunc())
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !used {
+		return nil, nil, errors.New("authgo: webauthn sign count did not increase, the authenticator may be cloned")
+	}
+
+	subj, err := s.FindSubjectByID(credential.UserID)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if subj == nil || !subj.UserActive() {
+		return nil, nil, errors.New("authgo: user is not active")
+	}
+
+	err = s.checkEmailVerified(subj)
+
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if claims.SecondFactor {
+		return subj, []string{amrPassword, amrWebAuthn}, nil
+	}
+
+	return subj, []string{amrWebAuthn}, nil
+}
+
+func (s *security) verifyWebAuthnClientData(value string, claims *webAuthnCeremonyClaims) error {
+	raw, err := decodeWebAuthnBase64(value)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	clientData := &webAuthnClientData{}
+
+	err = json.Unmarshal(raw, clientData)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch {
+	case clientData.Type != claims.Type:
+		return errors.New("authgo: webauthn client data has the wrong type")
+	case strings.TrimRight(clientData.Challenge, "=") != claims.Challenge:
+		return errors.New("authgo: webauthn challenge does not match")
+	case clientData.Origin != s.webAuthn.origin:
+		return errors.New("authgo: webauthn origin does not match")
+	}
+
+	return nil
+}
+
+func (s *security) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinLength {
+		return nil, errInvalidWebAuthnCredential
+	}
+
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(s.webAuthn.rpID))
+
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return nil, errors.New("authgo: webauthn rp id does not match")
+	}
+
+	if authData.flags&authDataFlagUserPresent == 0 {
+		return nil, errors.New("authgo: user was not present")
+	}
+
+	if authData.flags&authDataFlagAttestedCredential == 0 {
+		return authData, nil
+	}
+
+	// The attested credential data is the AAGUID, the length of the credential id, the id
+	// and the COSE key.
+	rest := data[authDataMinLength:]
+
+	if len(rest) < 18 {
+		return nil, errInvalidWebAuthnCredential
+	}
+
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if len(rest) < idLength {
+		return nil, errInvalidWebAuthnCredential
+	}
+
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, n, err := decodeCBOR(rest)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	authData.publicKey = rest[:n]
+
+	return authData, nil
+}
+
+// parseCOSEKey supports the ES256, EdDSA and RS256 keys of RFC 8152.
+func parseCOSEKey(data []byte) (interface{}, error) {
+	decoded, _, err := decodeCBOR(data)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	key, ok := decoded.(map[interface{}]interface{})
+
+	if !ok {
+		return nil, errUnsupportedCOSEKey
+	}
+
+	keyType, _ := key[int64(coseKeyType)].(int64)
+	algorithm, _ := key[int64(coseKeyAlgorithm)].(int64)
+	curve, _ := key[int64(coseKeyCurve)].(int64)
+	x, _ := key[int64(coseKeyX)].([]byte)
+	y, _ := key[int64(coseKeyY)].([]byte)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == coseAlgorithmES256 && curve == coseCurveP256:
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+		if len(x) != 32 || len(y) != 32 || !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, errUnsupportedCOSEKey
+		}
+
+		return public, nil
+	case keyType == coseKeyTypeOKP && algorithm == coseAlgorithmEdDSA && curve == coseCurveEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedCOSEKey
+		}
+
+		return ed25519.PublicKey(x), nil
+	case keyType == coseKeyTypeRSA && algorithm == coseAlgorithmRS256:
+		// For RSA keys, -1 is the modulus and -2 the exponent.
+		n, _ := key[int64(coseKeyCurve)].([]byte)
+		e, _ := key[int64(coseKeyX)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedCOSEKey
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+
+	return nil, errUnsupportedCOSEKey
+}
+
+func verifyWebAuthnSignature(public interface{}, data, signature []byte) bool {
+	sum := sha256.Sum256(data)
+
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, sum[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) == nil
+	}
+
+	return false
+}
+
+func signCountIncreased(stored, received int64) bool {
+	return (stored == 0 && received == 0) || received > stored
+}
+
+func newWebAuthnCredentialDescriptors(credentials []*WebAuthnCredential) []webAuthnCredentialDescriptor {
+	descriptors := []webAuthnCredentialDescriptor{}
+
+	for _, credential := range credentials {
+		descriptors = append(descriptors, webAuthnCredentialDescriptor{webAuthnCredentialType, credential.ID, credential.Transports})
+	}
+
+	return descriptors
+}
+
+// decodeWebAuthnBase64 accepts base64url with or without padding, as browsers differ.
+func decodeWebAuthnBase64(value string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return decoded, nil
+}
+
+// resolveWebAuthnConfig reads the relying party from the environment. It defaults to the
+// issuer, when that is a URL.
+func resolveWebAuthnConfig(issuer string) *webAuthnConfig {
+	config := &webAuthnConfig{defaultWebAuthnRPID, defaultWebAuthnOrigin}
+
+	if u, err := url.Parse(issuer); err == nil && u.Scheme != "" && u.Host != "" {
+		config.rpID = u.Hostname()
+		config.origin = u.Scheme + "://" + u.Host
+	}
+
+	if rpID, ok := os.LookupEnv(environmentWebAuthnRPID); ok && rpID != "" {
+		config.rpID = rpID
+	}
+
+	if origin, ok := os.LookupEnv(environmentWebAuthnOrigin); ok && origin != "" {
+		config.origin = strings.TrimSuffix(origin, "/")
+	}
+
+	return config
+}
@@ -0,0 +1,369 @@
+package security_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/di0nys1us/httpgo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/di0nys1us/authgo/security"
+)
+
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeCBOR encodes the values a software authenticator needs. Maps are given as pairs to
+// keep their order.
+func encodeCBOR(value interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			buf := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(buf[1:], uint16(n))
+			return buf
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []cborPair:
+		buf := header(5, uint64(len(v)))
+
+		for _, pair := range v {
+			buf = append(buf, encodeCBOR(pair.key)...)
+			buf = append(buf, encodeCBOR(pair.value)...)
+		}
+
+		return buf
+	}
+
+	panic("unsupported CBOR value")
+}
+
+// authenticator is a software authenticator with an ES256 key.
+type authenticator struct {
+	id        []byte
+	key       *ecdsa.PrivateKey
+	signCount uint32
+}
+
+func newAuthenticator() *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		panic(err)
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return &authenticator{id: id, key: key}
+}
+
+func (a *authenticator) credentialID() string {
+	return base64.RawURLEncoding.EncodeToString(a.id)
+}
+
+func (a *authenticator) authData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+
+	if !attested {
+		return data
+	}
+
+	coseKey := encodeCBOR([]cborPair{
+		{1, 2},
+		{3, -7},
+		{-1, 1},
+		{-2, a.key.X.FillBytes(make([]byte, 32))},
+		{-3, a.key.Y.FillBytes(make([]byte, 32))},
+	})
+
+	data = append(data, make([]byte, 16)...)
+	data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+	data = append(data, a.id...)
+
+	return append(data, coseKey...)
+}
+
+func clientDataJSON(ceremonyType, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{"type": ceremonyType, "challenge": challenge, "origin": "http://localhost:3000"})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+func (a *authenticator) create(ceremony, challenge string) []byte {
+	attestationObject := encodeCBOR([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(0x45, true)},
+	})
+
+	return mustMarshal(map[string]interface{}{
+		"ceremony": ceremony,
+		"name":     "Software key",
+		"credential": map[string]interface{}{
+			"id": a.credentialID(),
+			"response": map[string]interface{}{
+				"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON("webauthn.create", challenge)),
+				"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+			},
+		},
+	})
+}
+
+func (a *authenticator) get(ceremony, challenge, userID string, flags byte) []byte {
+	a.signCount++
+
+	authData := a.authData(flags, false)
+	clientData := clientDataJSON("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+
+	if err != nil {
+		panic(err)
+	}
+
+	return mustMarshal(map[string]interface{}{
+		"ceremony": ceremony,
+		"credential": map[string]interface{}{
+			"id": a.credentialID(),
+			"response": map[string]interface{}{
+				"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+				"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+				"signature":         base64.RawURLEncoding.EncodeToString(signature),
+				"userHandle":        base64.RawURLEncoding.EncodeToString([]byte(userID)),
+			},
+		},
+	})
+}
+
+func mustMarshal(value interface{}) []byte {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+var _ = Describe("WebAuthn", func() {
+
+	const (
+		userID = "ce274fd4-5803-11e8-8879-afa0dd22785d"
+	)
+
+	type options struct {
+		Ceremony  string `json:"ceremony"`
+		PublicKey struct {
+			Challenge        string `json:"challenge"`
+			AllowCredentials []struct {
+				ID string `json:"id"`
+			} `json:"allowCredentials"`
+			UserVerification string `json:"userVerification"`
+		} `json:"publicKey"`
+	}
+
+	post := func(handler http.Handler, target string, body []byte, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+
+		for key, values := range header {
+			r.Header[key] = values
+		}
+
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	decodeOptions := func(w *httptest.ResponseRecorder) *options {
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		o := &options{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), o)).To(Succeed())
+
+		return o
+	}
+
+	It("should register a passkey and use it to sign in, or as a second factor", func() {
+		security := New(subjects{newSubject(userID, "erik@eies.land", "secret")})
+		credentials := url.Values{"email": {"erik@eies.land"}, "password": {"secret"}}
+		a := newAuthenticator()
+
+		w := postForm(security.Authenticate, "/authenticate", credentials)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		tokens := &struct {
+			AccessToken string `json:"accessToken"`
+		}{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), tokens)).To(Succeed())
+
+		bearer := http.Header{"Authorization": {"Bearer " + tokens.AccessToken}}
+
+		By("registering the credential")
+
+		o := decodeOptions(post(security.Authorize(httpgo.ErrorHandlerFunc(security.BeginWebAuthnRegistration)), "/webauthn/register/begin", nil, bearer))
+		w = post(security.Authorize(httpgo.ErrorHandlerFunc(security.FinishWebAuthnRegistration)), "/webauthn/register/finish", a.create(o.Ceremony, o.PublicKey.Challenge), bearer)
+
+		Expect(w.Code).To(Equal(http.StatusCreated))
+
+		By("not finishing the same ceremony twice")
+
+		w = post(security.Authorize(httpgo.ErrorHandlerFunc(security.FinishWebAuthnRegistration)), "/webauthn/register/finish", a.create(o.Ceremony, o.PublicKey.Challenge), bearer)
+
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		By("signing in without a password")
+
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil))
+
+		Expect(o.PublicKey.AllowCredentials).To(BeEmpty())
+		Expect(o.PublicKey.UserVerification).To(Equal("required"))
+
+		assertion := a.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x05)
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", assertion, nil)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(findCookie(w, "authgo_token")).NotTo(BeNil())
+
+		By("rejecting a replayed assertion")
+
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", assertion, nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("rejecting a sign count that did not increase")
+
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil))
+		a.signCount--
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", a.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x05), nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("rejecting a passwordless login without user verification")
+
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil))
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", a.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x01), nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("rejecting a signature by another key")
+
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil))
+		other := newAuthenticator()
+		other.id = a.id
+		other.signCount = a.signCount + 10
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", other.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x05), nil)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		By("using it as the second factor after the password")
+
+		w = postForm(security.Authenticate, "/authenticate", credentials)
+
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+
+		challenge := &struct {
+			Methods []string `json:"methods"`
+		}{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), challenge)).To(Succeed())
+		Expect(challenge.Methods).To(Equal([]string{"webauthn"}))
+
+		pending := findCookie(w, "authgo_mfa_pending")
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil, pending))
+
+		Expect(o.PublicKey.AllowCredentials).To(HaveLen(1))
+		Expect(o.PublicKey.AllowCredentials[0].ID).To(Equal(a.credentialID()))
+
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", a.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x01), nil)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(findCookie(w, "authgo_token")).NotTo(BeNil())
+
+		By("no longer asking for it once it is removed")
+
+		Expect(security.RemoveWebAuthnCredential(context.Background(), userID, a.credentialID())).To(Succeed())
+		Expect(postForm(security.Authenticate, "/authenticate", credentials).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not sign in a user whose email is not verified, when it is required", func() {
+		os.Setenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED", "true")
+		defer os.Unsetenv("AUTHGO_EMAIL_VERIFICATION_REQUIRED")
+
+		subj := newSubject(userID, "erik@eies.land", "secret")
+		security := New(subjects{subj}, WithMailer(&outbox{}))
+		a := newAuthenticator()
+
+		w := postForm(security.Authenticate, "/authenticate", url.Values{"email": {"erik@eies.land"}, "password": {"secret"}})
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		tokens := &struct {
+			AccessToken string `json:"accessToken"`
+		}{}
+
+		Expect(json.Unmarshal(w.Body.Bytes(), tokens)).To(Succeed())
+
+		bearer := http.Header{"Authorization": {"Bearer " + tokens.AccessToken}}
+		o := decodeOptions(post(security.Authorize(httpgo.ErrorHandlerFunc(security.BeginWebAuthnRegistration)), "/webauthn/register/begin", nil, bearer))
+
+		Expect(post(security.Authorize(httpgo.ErrorHandlerFunc(security.FinishWebAuthnRegistration)), "/webauthn/register/finish", a.create(o.Ceremony, o.PublicKey.Challenge), bearer).Code).To(Equal(http.StatusCreated))
+
+		subj.unverified = true
+		o = decodeOptions(post(httpgo.ErrorHandlerFunc(security.BeginWebAuthnLogin), "/webauthn/login/begin", nil, nil))
+		w = post(httpgo.ErrorHandlerFunc(security.FinishWebAuthnLogin), "/webauthn/login/finish", a.get(o.Ceremony, o.PublicKey.Challenge, userID, 0x05), nil)
+
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(findCookie(w, "authgo_token")).To(BeNil())
+	})
+})
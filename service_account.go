@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceAccountEmailDomain = "service-accounts.authgo"
+)
+
+// INTERFACES
+
+type serviceAccountSaver interface {
+	saveServiceAccount(ctx context.Context, u *user, c *client, roleIDs []string) error
+}
+
+type serviceAccountRepository interface {
+	serviceAccountSaver
+}
+
+// STRUCTS
+
+// saveServiceAccount creates the service account user, assigns its roles and registers the
+// client it obtains its tokens with, all in one transaction.
+func (db *db) saveServiceAccount(ctx context.Context, u *user, c *client, roleIDs []string) error {
+	return db.commit(func(tx *tx) error {
+		id, err := db.generateUUID()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		eventID, err := db.generateUUID()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		u.ID = id
+		u.Email = fmt.Sprintf("%s@%s", id, serviceAccountEmailDomain)
+		u.Password = ""
+		u.Enabled = true
+		u.ServiceAccount = true
+		u.Events = append(u.Events, &event{
+			ID:          eventID,
+			CreatedBy:   security.ActorIDFromContext(ctx),
+			CreatedAt:   time.Now(),
+			Type:        eventTypeUserCreated,
+			Description: fmt.Sprintf("Service account %q created.", u.FirstName),
+		})
+
+		_, err = tx.NamedExec(sqlSaveServiceAccount, u)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, roleID := range roleIDs {
+			_, err = tx.Exec(sqlSaveUserRole, u.ID, roleID)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		c.ServiceAccountID = u.ID
+
+		c.ID, err = tx.save(c, sqlSaveClient)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+}
+
+const (
+	sqlSaveServiceAccount = `
+		insert into "authgo"."user" (
+			"id",
+			"first_name",
+			"last_name",
+			"email",
+			"password",
+			"enabled",
+			"deleted",
+			"service_account",
+			"events"
+		) values (
+			:id,
+			:first_name,
+			:last_name,
+			:email,
+			:password,
+			:enabled,
+			:deleted,
+			:service_account,
+			:events
+		);
+	`
+	sqlSaveUserRole = `
+		insert into "authgo"."user_role" (
+			"user_id",
+			"role_id"
+		) values (
+			$1,
+			$2
+		);
+	`
+)
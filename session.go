@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// INTERFACES
+
+type sessionsByUserIDFinder interface {
+	findSessionsByUserID(userID string) ([]*session, error)
+}
+
+type sessionRepository interface {
+	sessionsByUserIDFinder
+}
+
+// STRUCTS
+
+type session struct {
+	ID         string     `db:"id"`
+	UserID     string     `db:"user_id"`
+	ClientID   string     `db:"client_id"`
+	TokenID    string     `db:"token_id"`
+	UserAgent  string     `db:"user_agent"`
+	IPAddress  string     `db:"ip_address"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastSeenAt time.Time  `db:"last_seen_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+func (db *db) findSessionsByUserID(userID string) ([]*session, error) {
+	sessions := []*session{}
+
+	err := db.Select(&sessions, sqlFindSessionsByUserID, userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return sessions, nil
+}
+
+func (db *db) SaveSession(s *security.Session) error {
+	_, err := db.NamedExec(sqlSaveSession, session(*s))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) FindSession(id string) (*security.Session, error) {
+	s := session{}
+
+	err := db.Get(&s, sqlFindSession, id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	found := security.Session(s)
+
+	return &found, nil
+}
+
+func (db *db) FindUserSessions(userID string) ([]*security.Session, error) {
+	sessions, err := db.findSessionsByUserID(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := []*security.Session{}
+
+	for _, s := range sessions {
+		found := security.Session(*s)
+		result = append(result, &found)
+	}
+
+	return result, nil
+}
+
+func (db *db) TouchSession(id string, lastSeenAt time.Time) error {
+	_, err := db.Exec(sqlTouchSession, id, lastSeenAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) RevokeSession(id string, revokedAt time.Time) error {
+	_, err := db.Exec(sqlRevokeSession, id, revokedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (db *db) RevokeUserSessions(userID string, revokedAt time.Time) error {
+	_, err := db.Exec(sqlRevokeUserSessions, userID, revokedAt)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlSaveSession = `
+		insert into "authgo"."session" (
+			"id",
+			"user_id",
+			"client_id",
+			"token_id",
+			"user_agent",
+			"ip_address",
+			"created_at",
+			"last_seen_at",
+			"expires_at"
+		) values (
+			:id,
+			:user_id,
+			cast(nullif(:client_id, '') as uuid),
+			:token_id,
+			:user_agent,
+			:ip_address,
+			:created_at,
+			:last_seen_at,
+			:expires_at
+		)
+		on conflict ("id") do update set
+			"token_id" = excluded."token_id",
+			"last_seen_at" = excluded."last_seen_at",
+			"expires_at" = excluded."expires_at";
+	`
+	sqlFindSession = `
+		select
+			"session"."id",
+			"session"."user_id",
+			coalesce("session"."client_id"::text, '') as "client_id",
+			"session"."token_id",
+			"session"."user_agent",
+			"session"."ip_address",
+			"session"."created_at",
+			"session"."last_seen_at",
+			"session"."expires_at",
+			"session"."revoked_at"
+		from "authgo"."session"
+		where "session"."id" = $1;
+	`
+	sqlFindSessionsByUserID = `
+		select
+			"session"."id",
+			"session"."user_id",
+			coalesce("session"."client_id"::text, '') as "client_id",
+			"session"."token_id",
+			"session"."user_agent",
+			"session"."ip_address",
+			"session"."created_at",
+			"session"."last_seen_at",
+			"session"."expires_at",
+			"session"."revoked_at"
+		from "authgo"."session"
+		where "session"."user_id" = $1
+			and "session"."revoked_at" is null
+			and "session"."expires_at" > now()
+		order by "session"."last_seen_at" desc;
+	`
+	sqlTouchSession = `
+		update "authgo"."session" set
+			"last_seen_at" = $2
+		where "session"."id" = $1;
+	`
+	sqlRevokeSession = `
+		update "authgo"."session" set
+			"revoked_at" = $2
+		where "session"."id" = $1
+			and "session"."revoked_at" is null;
+	`
+	sqlRevokeUserSessions = `
+		update "authgo"."session" set
+			"revoked_at" = $2
+		where "session"."user_id" = $1
+			and "session"."revoked_at" is null;
+	`
+)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type sessionResolver struct {
+	session *session
+}
+
+func (r *sessionResolver) ID() graphql.ID {
+	return graphQLID(r.session.ID)
+}
+
+func (r *sessionResolver) UserAgent() string {
+	return r.session.UserAgent
+}
+
+func (r *sessionResolver) IPAddress() string {
+	return r.session.IPAddress
+}
+
+func (r *sessionResolver) CreatedAt() string {
+	return r.session.CreatedAt.Format(time.RFC3339)
+}
+
+func (r *sessionResolver) LastSeenAt() string {
+	return r.session.LastSeenAt.Format(time.RFC3339)
+}
+
+func (r *sessionResolver) ExpiresAt() string {
+	return r.session.ExpiresAt.Format(time.RFC3339)
+}
+
+// Current reports whether the session is the one of the request.
+func (r *sessionResolver) Current(ctx context.Context) bool {
+	return r.session.ID == security.SessionIDFromContext(ctx)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/pkg/errors"
+)
+
+// STRUCTS
+
+type signingKey struct {
+	ID         string    `db:"id"`
+	Algorithm  string    `db:"algorithm"`
+	PrivateKey string    `db:"private_key"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (db *db) FindSigningKeys(createdSince time.Time) ([]*security.SigningKey, error) {
+	rows := []*signingKey{}
+
+	err := db.Select(&rows, sqlFindSigningKeys, createdSince)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	keys := make([]*security.SigningKey, 0, len(rows))
+
+	for _, row := range rows {
+		keys = append(keys, &security.SigningKey{
+			ID:         row.ID,
+			Algorithm:  row.Algorithm,
+			PrivateKey: row.PrivateKey,
+			CreatedAt:  row.CreatedAt,
+		})
+	}
+
+	return keys, nil
+}
+
+func (db *db) SaveSigningKey(key *security.SigningKey) error {
+	_, err := db.NamedExec(sqlSaveSigningKey, &signingKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: key.PrivateKey,
+		CreatedAt:  key.CreatedAt,
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	sqlFindSigningKeys = `
+		select
+			"signing_key"."id",
+			"signing_key"."algorithm",
+			"signing_key"."private_key",
+			"signing_key"."created_at"
+		from "authgo"."signing_key"
+		where "signing_key"."created_at" > $1
+		order by "signing_key"."created_at";
+	`
+	sqlSaveSigningKey = `
+		insert into "authgo"."signing_key" (
+			"id",
+			"algorithm",
+			"private_key",
+			"created_at"
+		) values (
+			:id,
+			:algorithm,
+			:private_key,
+			:created_at
+		);
+	`
+)
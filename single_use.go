@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UseOnce remembers the id until expiresAt, and reports whether it was not used before. The
+// insert is the check, so that concurrent uses cannot both come first.
+func (db *db) UseOnce(id string, expiresAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseOnce, id, expiresAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+const (
+	sqlUseOnce = `
+		insert into "authgo"."single_use" ("id", "expires_at")
+		values ($1, $2)
+		on conflict ("id") do nothing;
+	`
+)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUseOnce(t *testing.T) {
+	db, err := newDB()
+
+	if err != nil {
+		t.Skipf("no database: %v", err)
+	}
+
+	defer db.DB.Close()
+
+	id, err := db.generateUUID()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ids of the single-use values are not UUIDs.
+	id = "mfa_pending:" + id
+	expiresAt := time.Now().Add(time.Minute)
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		first int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ok, err := db.UseOnce(id, expiresAt)
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if ok {
+				mutex.Lock()
+				first++
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if first != 1 {
+		t.Fatalf("expected one first use, got %d", first)
+	}
+}
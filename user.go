@@ -28,11 +28,21 @@ type userSaver interface {
 	saveUser(ctx context.Context, user *user) error
 }
 
+type userUpdater interface {
+	updateUser(ctx context.Context, user *user, password string) error
+}
+
+type userApprover interface {
+	approveUser(ctx context.Context, id string) (bool, error)
+}
+
 type userRepository interface {
 	allUsersFinder
 	userByIDFinder
 	userByEmailFinder
 	userSaver
+	userUpdater
+	userApprover
 }
 
 // STRUCTS
@@ -47,6 +57,13 @@ type user struct {
 	Enabled   bool   `db:"enabled" json:"enabled,omitempty"`
 	Deleted   bool   `db:"deleted" json:"deleted,omitempty"`
 	Events    events `db:"events" json:"events,omitempty"`
+	// ServiceAccount users are machine identities. They have no password and obtain their
+	// tokens through the client credentials grant.
+	ServiceAccount bool `db:"service_account" json:"serviceAccount,omitempty"`
+	// EmailVerified is set once the user followed a link sent to their email.
+	EmailVerified bool `db:"email_verified" json:"emailVerified,omitempty"`
+	// PendingApproval is set for users that registered and wait for an administrator.
+	PendingApproval bool `db:"pending_approval" json:"pendingApproval,omitempty"`
 }
 
 func (u *user) save(tx *tx) error {
@@ -115,6 +132,22 @@ func (u *user) UserActive() bool {
 	return u.Enabled && !u.Deleted
 }
 
+func (u *user) UserEmailVerified() bool {
+	return u.EmailVerified
+}
+
+func (u *user) UserPendingApproval() bool {
+	return u.PendingApproval && !u.Deleted
+}
+
+func (u *user) UserFirstName() string {
+	return u.FirstName
+}
+
+func (u *user) UserLastName() string {
+	return u.LastName
+}
+
 func (db *db) findAllUsers() ([]*user, error) {
 	users := []*user{}
 
@@ -160,24 +193,134 @@ func (db *db) findUserByEmail(email string) (*user, error) {
 }
 
 func (db *db) FindSubjectByEmail(email string) (security.Subject, error) {
-	return db.findUserByEmail(email)
+	u, err := db.findUserByEmail(email)
+
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	return u, nil
 }
 
-func (db *db) saveUser(ctx context.Context, user *user) error {
-	return db.commit(func(tx *tx) error {
-		eventID, err := db.generateUUID()
+func (db *db) FindSubjectByID(id string) (security.Subject, error) {
+	u, err := db.findUserByID(id)
+
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// UpdateUserPassword replaces the password hash of a user, such as when it is upgraded to
+// the current hasher after a login.
+func (db *db) UpdateUserPassword(userID, hashedPassword string) error {
+	_, err := db.Exec(sqlUpdateUserPassword, userID, hashedPassword)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// UpdateUserEmail replaces the email of a user with one they verified.
+func (db *db) UpdateUserEmail(userID, email string) error {
+	_, err := db.Exec(sqlUpdateUserEmail, userID, email)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// RegisterUser creates a user that signed up themselves, with the roles new users get by
+// default.
+func (db *db) RegisterUser(registration *security.Registration) (string, error) {
+	u := &user{
+		FirstName:       registration.FirstName,
+		LastName:        registration.LastName,
+		Email:           registration.Email,
+		Password:        registration.HashedPassword,
+		Enabled:         registration.Enabled,
+		EmailVerified:   registration.EmailVerified,
+		PendingApproval: registration.PendingApproval,
+		Events:          events{},
+	}
+
+	err := db.commit(func(tx *tx) error {
+		id, err := tx.save(u, sqlRegisterUser)
 
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		user.Password, err = security.GenerateHashedPassword(user.Password)
+		u.ID = id
+
+		_, err = tx.Exec(sqlSaveUserDefaultRoles, u.ID)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return u.ID, nil
+}
+
+// approveUser enables a user that registered and waits for approval. It reports false when
+// there is no such user. Users that were disabled on purpose are left alone.
+func (db *db) approveUser(ctx context.Context, id string) (bool, error) {
+	if !isUUID(id) {
+		return false, nil
+	}
+
+	approved := false
+
+	err := db.commit(func(tx *tx) error {
+		result, err := tx.Exec(sqlApproveUser, id)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
 
 		if err != nil {
 			return errors.WithStack(err)
 		}
 
-		userID, err := security.UserIDFromContext(ctx)
+		if rowsAffected != 1 {
+			return nil
+		}
+
+		approved = true
+
+		return db.appendUserEvent(ctx, tx, id, eventTypeUserEnabled, "User approved.")
+	})
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return approved, nil
+}
+
+func (db *db) saveUser(ctx context.Context, user *user) error {
+	return db.commit(func(tx *tx) error {
+		eventID, err := db.generateUUID()
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		user.Password, err = security.GenerateHashedPassword(user.Password)
 
 		if err != nil {
 			return errors.WithStack(err)
@@ -185,7 +328,7 @@ func (db *db) saveUser(ctx context.Context, user *user) error {
 
 		event := &event{
 			ID:          eventID,
-			CreatedBy:   userID,
+			CreatedBy:   security.ActorIDFromContext(ctx),
 			CreatedAt:   time.Now(),
 			Type:        eventTypeUserCreated,
 			Description: fmt.Sprintf("User %q created.", user.Email),
@@ -203,6 +346,41 @@ func (db *db) saveUser(ctx context.Context, user *user) error {
 	})
 }
 
+// updateUser stores the changes to the user, as long as nobody changed it since the version
+// it has. The password is only replaced when a new one is given.
+func (db *db) updateUser(ctx context.Context, user *user, password string) error {
+	description := fmt.Sprintf("User %q updated.", user.Email)
+
+	err := db.commit(func(tx *tx) error {
+		if password != "" {
+			hashedPassword, err := security.GenerateHashedPassword(password)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			user.Password = hashedPassword
+			description = fmt.Sprintf("User %q updated, with a new password.", user.Email)
+		}
+
+		err := user.update(tx)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return db.appendUserEvent(ctx, tx, user.ID, eventTypeUserUpdated, description)
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	user.Version++
+
+	return nil
+}
+
 const (
 	sqlSaveUser = `
 		insert into "authgo"."user" (
@@ -235,6 +413,60 @@ const (
 		where "user"."id" = :id
 			and "user"."version" = :old_version;
 	`
+	sqlUpdateUserPassword = `
+		update "authgo"."user" set
+			"version" = "user"."version" + 1,
+			"password" = $2
+		where "user"."id" = $1;
+	`
+	sqlRegisterUser = `
+		insert into "authgo"."user" (
+			"first_name",
+			"last_name",
+			"email",
+			"password",
+			"enabled",
+			"email_verified",
+			"pending_approval",
+			"events"
+		) values (
+			:first_name,
+			:last_name,
+			:email,
+			:password,
+			:enabled,
+			:email_verified,
+			:pending_approval,
+			:events
+		) returning "user"."id";
+	`
+	sqlSaveUserDefaultRoles = `
+		insert into "authgo"."user_role" (
+			"user_id",
+			"role_id"
+		)
+		select
+			$1,
+			"role"."id"
+		from "authgo"."role"
+		where "role"."registration_default";
+	`
+	sqlApproveUser = `
+		update "authgo"."user" set
+			"version" = "user"."version" + 1,
+			"enabled" = true,
+			"pending_approval" = false
+		where "user"."id" = $1
+			and "user"."pending_approval"
+			and not "user"."deleted";
+	`
+	sqlUpdateUserEmail = `
+		update "authgo"."user" set
+			"version" = "user"."version" + 1,
+			"email" = $2,
+			"email_verified" = true
+		where "user"."id" = $1;
+	`
 	sqlDeleteUser   = ``
 	sqlFindUserByID = `
 		select
@@ -245,6 +477,9 @@ const (
 			"user"."email",
 			"user"."enabled",
 			"user"."deleted",
+			"user"."email_verified",
+			"user"."pending_approval",
+			"user"."service_account",
 			"user"."events"
 		from "authgo"."user"
 		where "user"."id" = $1;
@@ -258,7 +493,10 @@ const (
 			"user"."email",
 			"user"."password",
 			"user"."enabled",
-			"user"."deleted"
+			"user"."deleted",
+			"user"."email_verified",
+			"user"."pending_approval",
+			"user"."service_account"
 		from "authgo"."user"
 		where "user"."email" = $1;
 	`
@@ -271,6 +509,9 @@ const (
 			"user"."email",
 			"user"."enabled",
 			"user"."deleted",
+			"user"."email_verified",
+			"user"."pending_approval",
+			"user"."service_account",
 			"user"."events"
 		from "authgo"."user"
 		order by "user"."id";
@@ -284,6 +525,9 @@ const (
 			"user"."email",
 			"user"."enabled",
 			"user"."deleted",
+			"user"."email_verified",
+			"user"."pending_approval",
+			"user"."service_account",
 			"user"."events"
 		from "authgo"."user"
 			inner join "authgo"."user_role" on "user_role"."user_id" = "user"."id"
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/graph-gophers/graphql-go"
 )
 
@@ -29,10 +31,6 @@ func (r *userResolver) Email() string {
 	return r.user.Email
 }
 
-func (r *userResolver) Password() string {
-	return r.user.Password
-}
-
 func (r *userResolver) Enabled() bool {
 	return r.user.Enabled
 }
@@ -41,14 +39,90 @@ func (r *userResolver) Deleted() bool {
 	return r.user.Deleted
 }
 
-func (r *userResolver) Events() ([]*eventResolver, error) {
+func (r *userResolver) EmailVerified() bool {
+	return r.user.EmailVerified
+}
+
+func (r *userResolver) PendingApproval() bool {
+	return r.user.PendingApproval
+}
+
+func (r *userResolver) ServiceAccount() bool {
+	return r.user.ServiceAccount
+}
+
+func (r *userResolver) Events(ctx context.Context) (*[]*eventResolver, error) {
+	if err := authorizeField(ctx, "User.events", r.user.ID); err != nil {
+		return nil, err
+	}
+
 	var resolvers []*eventResolver
 
 	for _, event := range r.user.Events {
 		resolvers = append(resolvers, &eventResolver{r.repository, event})
 	}
 
-	return resolvers, nil
+	return &resolvers, nil
+}
+
+func (r *userResolver) Credentials(ctx context.Context) (*[]*webAuthnCredentialResolver, error) {
+	if err := authorizeField(ctx, "User.credentials", r.user.ID); err != nil {
+		return nil, err
+	}
+
+	credentials, err := r.repository.findWebAuthnCredentialsByUserID(r.user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*webAuthnCredentialResolver
+
+	for _, credential := range credentials {
+		resolvers = append(resolvers, &webAuthnCredentialResolver{credential})
+	}
+
+	return &resolvers, nil
+}
+
+func (r *userResolver) Sessions(ctx context.Context) (*[]*sessionResolver, error) {
+	if err := authorizeField(ctx, "User.sessions", r.user.ID); err != nil {
+		return nil, err
+	}
+
+	sessions, err := r.repository.findSessionsByUserID(r.user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*sessionResolver
+
+	for _, session := range sessions {
+		resolvers = append(resolvers, &sessionResolver{session})
+	}
+
+	return &resolvers, nil
+}
+
+func (r *userResolver) PersonalAccessTokens(ctx context.Context) (*[]*personalAccessTokenResolver, error) {
+	if err := authorizeField(ctx, "User.personalAccessTokens", r.user.ID); err != nil {
+		return nil, err
+	}
+
+	tokens, err := r.repository.findPersonalAccessTokensByUserID(r.user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []*personalAccessTokenResolver
+
+	for _, token := range tokens {
+		resolvers = append(resolvers, &personalAccessTokenResolver{token})
+	}
+
+	return &resolvers, nil
 }
 
 func (r *userResolver) Roles() ([]*roleResolver, error) {
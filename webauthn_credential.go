@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/di0nys1us/authgo/security"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// INTERFACES
+
+type webAuthnCredentialsByUserIDFinder interface {
+	findWebAuthnCredentialsByUserID(userID string) ([]*webAuthnCredential, error)
+}
+
+type webAuthnCredentialRepository interface {
+	webAuthnCredentialsByUserIDFinder
+}
+
+// STRUCTS
+
+type webAuthnCredential struct {
+	ID         string         `db:"id" json:"id,omitempty"`
+	UserID     string         `db:"user_id" json:"userId,omitempty"`
+	Name       string         `db:"name" json:"name,omitempty"`
+	PublicKey  []byte         `db:"public_key" json:"-"`
+	SignCount  int64          `db:"sign_count" json:"signCount"`
+	Transports pq.StringArray `db:"transports" json:"transports,omitempty"`
+	CreatedAt  time.Time      `db:"created_at" json:"createdAt,omitempty"`
+	LastUsedAt *time.Time     `db:"last_used_at" json:"lastUsedAt,omitempty"`
+}
+
+func (c *webAuthnCredential) credential() *security.WebAuthnCredential {
+	return &security.WebAuthnCredential{
+		ID:         c.ID,
+		UserID:     c.UserID,
+		Name:       c.Name,
+		PublicKey:  c.PublicKey,
+		SignCount:  c.SignCount,
+		Transports: c.Transports,
+		CreatedAt:  c.CreatedAt,
+		LastUsedAt: c.LastUsedAt,
+	}
+}
+
+func (db *db) findWebAuthnCredentialsByUserID(userID string) ([]*webAuthnCredential, error) {
+	credentials := []*webAuthnCredential{}
+
+	err := db.Select(&credentials, sqlFindWebAuthnCredentialsByUserID, userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return credentials, nil
+}
+
+func (db *db) FindWebAuthnCredentials(userID string) ([]*security.WebAuthnCredential, error) {
+	credentials, err := db.findWebAuthnCredentialsByUserID(userID)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := []*security.WebAuthnCredential{}
+
+	for _, c := range credentials {
+		result = append(result, c.credential())
+	}
+
+	return result, nil
+}
+
+func (db *db) FindWebAuthnCredential(id string) (*security.WebAuthnCredential, error) {
+	c := &webAuthnCredential{}
+
+	err := db.Get(c, sqlFindWebAuthnCredentialByID, id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.credential(), nil
+}
+
+// SaveWebAuthnCredential stores the credential, and records the registration in the events
+// of the user.
+func (db *db) SaveWebAuthnCredential(ctx context.Context, credential *security.WebAuthnCredential) error {
+	return db.commit(func(tx *tx) error {
+		c := &webAuthnCredential{
+			ID:         credential.ID,
+			UserID:     credential.UserID,
+			Name:       credential.Name,
+			PublicKey:  credential.PublicKey,
+			SignCount:  credential.SignCount,
+			Transports: pq.StringArray(credential.Transports),
+			CreatedAt:  credential.CreatedAt,
+			LastUsedAt: credential.LastUsedAt,
+		}
+
+		if c.Transports == nil {
+			c.Transports = pq.StringArray{}
+		}
+
+		_, err := tx.NamedExec(sqlSaveWebAuthnCredential, c)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return db.appendUserEvent(ctx, tx, credential.UserID, eventTypeCredentialRegistered, fmt.Sprintf("Credential %q registered.", credential.Name))
+	})
+}
+
+func (db *db) UseWebAuthnCredential(id string, signCount int64, usedAt time.Time) (bool, error) {
+	result, err := db.Exec(sqlUseWebAuthnCredential, id, signCount, usedAt)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// DeleteWebAuthnCredential removes the credential, and records the removal in the events
+// of the user.
+func (db *db) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	return db.commit(func(tx *tx) error {
+		var name string
+
+		err := tx.Get(&name, sqlDeleteWebAuthnCredential, userID, id)
+
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return db.appendUserEvent(ctx, tx, userID, eventTypeCredentialRemoved, fmt.Sprintf("Credential %q removed.", name))
+	})
+}
+
+const (
+	sqlFindWebAuthnCredentialsByUserID = `
+		select
+			"webauthn_credential"."id",
+			"webauthn_credential"."user_id",
+			"webauthn_credential"."name",
+			"webauthn_credential"."public_key",
+			"webauthn_credential"."sign_count",
+			"webauthn_credential"."transports",
+			"webauthn_credential"."created_at",
+			"webauthn_credential"."last_used_at"
+		from "authgo"."webauthn_credential"
+		where "webauthn_credential"."user_id" = $1
+		order by "webauthn_credential"."created_at";
+	`
+	sqlFindWebAuthnCredentialByID = `
+		select
+			"webauthn_credential"."id",
+			"webauthn_credential"."user_id",
+			"webauthn_credential"."name",
+			"webauthn_credential"."public_key",
+			"webauthn_credential"."sign_count",
+			"webauthn_credential"."transports",
+			"webauthn_credential"."created_at",
+			"webauthn_credential"."last_used_at"
+		from "authgo"."webauthn_credential"
+		where "webauthn_credential"."id" = $1;
+	`
+	sqlSaveWebAuthnCredential = `
+		insert into "authgo"."webauthn_credential" (
+			"id",
+			"user_id",
+			"name",
+			"public_key",
+			"sign_count",
+			"transports",
+			"created_at",
+			"last_used_at"
+		) values (
+			:id,
+			:user_id,
+			:name,
+			:public_key,
+			:sign_count,
+			:transports,
+			:created_at,
+			:last_used_at
+		);
+	`
+	sqlUseWebAuthnCredential = `
+		update "authgo"."webauthn_credential" set
+			"sign_count" = $2,
+			"last_used_at" = $3
+		where "webauthn_credential"."id" = $1
+			and (("webauthn_credential"."sign_count" = 0 and $2 = 0) or "webauthn_credential"."sign_count" < $2);
+	`
+	sqlDeleteWebAuthnCredential = `
+		delete from "authgo"."webauthn_credential"
+		where "webauthn_credential"."user_id" = $1
+			and "webauthn_credential"."id" = $2
+		returning "webauthn_credential"."name";
+	`
+)
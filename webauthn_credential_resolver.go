@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+type webAuthnCredentialResolver struct {
+	credential *webAuthnCredential
+}
+
+func (r *webAuthnCredentialResolver) ID() graphql.ID {
+	return graphQLID(r.credential.ID)
+}
+
+func (r *webAuthnCredentialResolver) Name() string {
+	return r.credential.Name
+}
+
+func (r *webAuthnCredentialResolver) Transports() []string {
+	return r.credential.Transports
+}
+
+func (r *webAuthnCredentialResolver) CreatedAt() string {
+	return r.credential.CreatedAt.Format(time.RFC3339)
+}
+
+func (r *webAuthnCredentialResolver) LastUsedAt() *string {
+	if r.credential.LastUsedAt == nil {
+		return nil
+	}
+
+	lastUsedAt := r.credential.LastUsedAt.Format(time.RFC3339)
+
+	return &lastUsedAt
+}